@@ -20,12 +20,29 @@ func AddRoutes(r *gin.RouterGroup) {
 	r.POST("/shutdown", handlers.ShutdownHandler)
 	r.POST("/line-data", handlers.LineDataHandler)
 	r.GET("/fetch-studies", handlers.FetchStudyHandler)
+	r.GET("/join-study/consent", handlers.JoinStudyConsentHandler)
 	r.POST("/join-study", handlers.JoinStudyHandler)
 	r.POST("/leave-study", handlers.LeaveStudyHandler)
+	r.POST("/withdraw-study", handlers.WithdrawStudyHandler)
+	r.GET("/consent", handlers.ConsentGetHandler)
+	r.POST("/consent", handlers.ConsentPostHandler)
 	r.POST("/speech-failure/eleven", handlers.ElevenSpeechFailureHandler)
 	r.GET("/speech-settings/eleven", handlers.ElevenSpeechSettingsGetHandler)
 	r.POST("/speech-settings/eleven", handlers.ElevenSpeechSettingsPostHandler)
 	r.GET("/participant-settings/eleven", handlers.ParticipantElevenSpeechSettingsHandler)
 	r.GET("/favorites", handlers.FavoritesGetHandler)
 	r.PUT("/favorites", handlers.FavoritesPutHandler)
+	r.GET("/favorites/history", handlers.FavoritesHistoryHandler)
+	r.GET("/favorites/history/:etag", handlers.FavoritesVersionGetHandler)
+	r.POST("/favorites/history/:etag/restore", handlers.FavoritesRestoreHandler)
+	r.GET("/favorites/library", handlers.PhraseLibraryHandler)
+	r.GET("/config", handlers.ConfigGetHandler)
+	r.GET("/devices", handlers.DevicesGetHandler)
+	r.POST("/devices/:clientId/revoke", handlers.DeviceRevokeHandler)
+	r.GET("/profile/export", handlers.ProfileExportHandler)
+	r.POST("/profile/erase", handlers.ProfileEraseHandler)
+	r.GET("/messages", handlers.MessagesGetHandler)
+	r.POST("/messages/:messageId/read", handlers.MessageReadHandler)
+	r.GET("/questionnaires", handlers.QuestionnairesGetHandler)
+	r.POST("/questionnaires/:questionnaireId", handlers.QuestionnaireResponseHandler)
 }
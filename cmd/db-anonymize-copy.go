@@ -0,0 +1,108 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+)
+
+// dbAnonymizeCopyCmd represents the anonymize-copy command
+var dbAnonymizeCopyCmd = &cobra.Command{
+	Use:   "anonymize-copy target-env",
+	Short: "Copy the database to another environment, anonymizing it",
+	Long: `Copy the stored objects from the database in the env environment
+to the database in the target environment, anonymizing them on the way.
+API keys are replaced, UPNs and admin emails are consistently hashed,
+and phrases are scrambled (preserving their length).
+Use the salt flag to hash and scramble the same way across copies.
+Use the study flag to copy only the objects for specific studies.
+The target may not be the production environment unless the
+allow-production flag is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.SetFlags(0)
+		env, _ := cmd.Flags().GetString("env")
+		target := args[0]
+		allowProduction, _ := cmd.Flags().GetBool("allow-production")
+		studyIds, _ := cmd.Flags().GetStringSlice("study")
+		salt, _ := cmd.Flags().GetString("salt")
+		if salt == "" {
+			salt = uuid.NewString()
+		}
+		useStorageLogger()
+		anonymizeCopy(env, target, storage.TransferFilter{StudyIds: studyIds}, salt, allowProduction)
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbAnonymizeCopyCmd)
+	dbAnonymizeCopyCmd.Args = cobra.ExactArgs(1)
+	dbAnonymizeCopyCmd.Flags().StringSlice("study", nil, "Only copy objects for these study IDs")
+	dbAnonymizeCopyCmd.Flags().String("salt", "", "Salt for hashing and scrambling (default random)")
+	dbAnonymizeCopyCmd.Flags().Bool("allow-production", false, "Allow the target to be the production environment")
+}
+
+func anonymizeCopy(source, target string, filter storage.TransferFilter, salt string, allowProduction bool) {
+	if err := platform.PushConfig(source); err != nil {
+		log.Fatalf("Can't load environment %q: %v", source, err)
+	}
+	// the source may be the default environment, so compare the loaded configurations
+	sourceEnv := platform.GetConfig()
+	log.Printf("Reading from %s...", source)
+	objects, err := storage.DumpObjects(filter)
+	if err != nil {
+		log.Fatalf("Dump failed: %v", err)
+	}
+	platform.PopConfig()
+	storage.AnonymizeObjects(objects, salt)
+	// round-trip through JSON, just as a dump file would
+	var b bytes.Buffer
+	if err := platform.DumpObjectsToStream(objects, &b); err != nil {
+		log.Fatalf("Encode failed: %v", err)
+	}
+	stored, err := platform.LoadObjectsFromStream(&b)
+	if err != nil {
+		log.Fatalf("Decode failed: %v", err)
+	}
+	if err := platform.PushConfig(target); err != nil {
+		log.Fatalf("Can't load environment %q: %v", target, err)
+	}
+	targetEnv := platform.GetConfig()
+	if sameEnvironment(targetEnv, sourceEnv) {
+		log.Fatalf("The source and target environments must be different")
+	}
+	if !allowProduction {
+		// the target may be named anything, so compare it with the loaded production configuration
+		if err := platform.PushConfig("production"); err == nil {
+			productionEnv := platform.GetConfig()
+			platform.PopConfig()
+			if sameEnvironment(targetEnv, productionEnv) {
+				log.Fatalf("Can't copy into the production environment without the allow-production flag")
+			}
+		}
+	}
+	log.Printf("Writing to %s...", target)
+	counts, err := storage.LoadObjects(stored, filter, false)
+	for _, c := range storage.TransferCategories() {
+		if n, ok := counts[c[0]]; ok {
+			log.Printf("Copied %d %s (%s)", n, c[0], c[1])
+		}
+	}
+	if err != nil {
+		log.Fatalf("Load failed: %v", err)
+	}
+}
+
+// sameEnvironment reports whether two loaded configurations use the same database.
+func sameEnvironment(a, b platform.Environment) bool {
+	return (a.Name != "" && a.Name == b.Name) || (a.DbUrl == b.DbUrl && a.DbKeyPrefix == b.DbKeyPrefix)
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+)
+
+// dbDumpCmd represents the dump command
+var dbDumpCmd = &cobra.Command{
+	Use:   "dump [file]",
+	Short: "Dump the database to a JSON file",
+	Long: `Dump the stored objects in the database as JSON, grouped by category.
+If no file is given, the JSON is written to stdout.
+Use the study flag to dump only the objects for specific studies,
+and the prefix flag to dump only the categories with specific storage prefixes.
+Report contents are kept in S3 and are not dumped.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.SetFlags(0)
+		env, _ := cmd.Flags().GetString("env")
+		if err := platform.PushConfig(env); err != nil {
+			log.Fatalf("Can't load environment %q: %v", env, err)
+		}
+		useStorageLogger()
+		studyIds, _ := cmd.Flags().GetStringSlice("study")
+		prefixes, _ := cmd.Flags().GetStringSlice("prefix")
+		path := ""
+		if len(args) > 0 {
+			path = args[0]
+		}
+		dump(storage.TransferFilter{StudyIds: studyIds, Prefixes: prefixes}, path)
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbDumpCmd)
+	dbDumpCmd.Args = cobra.MaximumNArgs(1)
+	dbDumpCmd.Flags().StringSlice("study", nil, "Only dump objects for these study IDs")
+	dbDumpCmd.Flags().StringSlice("prefix", nil, "Only dump categories with these storage prefixes")
+}
+
+func dump(filter storage.TransferFilter, path string) {
+	objects, err := storage.DumpObjects(filter)
+	if err != nil {
+		log.Fatalf("Dump failed: %v", err)
+	}
+	if path == "" {
+		err = platform.DumpObjectsToStream(objects, os.Stdout)
+	} else {
+		err = platform.DumpObjectsToPath(objects, path)
+	}
+	if err != nil {
+		log.Fatalf("Write failed: %v", err)
+	}
+	for _, c := range storage.TransferCategories() {
+		if objs, ok := objects[c[0]]; ok {
+			log.Printf("Dumped %d %s (%s)", len(objs), c[0], c[1])
+		}
+	}
+}
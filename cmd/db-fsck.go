@@ -0,0 +1,401 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package cmd
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+
+	"github.com/spf13/cobra"
+)
+
+// dbFsckCmd represents the fsck command
+var dbFsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check the database for consistency",
+	Long: `Cross-check the relationships between stored objects and report
+any inconsistencies by category. Use the repair flag to fix them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.SetFlags(0)
+		env, _ := cmd.Flags().GetString("env")
+		if err := platform.PushConfig(env); err != nil {
+			log.Fatalf("Can't load environment %q: %v", env, err)
+		}
+		useStorageLogger()
+		repair, _ := cmd.Flags().GetBool("repair")
+		f := &fsck{repair: repair, counts: make(map[string]int)}
+		log.Println("Checking profile map entries...")
+		f.checkProfileMap()
+		log.Println("Checking active participants...")
+		f.checkActiveParticipants()
+		log.Println("Checking participant lists...")
+		f.checkParticipantLists()
+		log.Println("Checking line stats...")
+		f.checkLineStats()
+		log.Println("Checking participant phrase stats...")
+		f.checkParticipantPhraseStats()
+		log.Println("Checking questionnaire responses...")
+		f.checkQuestionnaireResponses()
+		log.Println("Checking consent records...")
+		f.checkConsentRecords()
+		log.Println("Checking messages...")
+		f.checkMessages()
+		log.Println("Checking speech monitors...")
+		f.checkMonitors()
+		f.summarize()
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbFsckCmd)
+	dbFsckCmd.Args = cobra.NoArgs
+	dbFsckCmd.Flags().Bool("repair", false, "Repair the problems that are found")
+}
+
+// fsck accumulates the problems found in each category
+type fsck struct {
+	repair   bool
+	counts   map[string]int
+	repaired int
+}
+
+// problem reports a problem in the category and, if repairing, runs the fix.
+func (f *fsck) problem(category, format string, fix func() error, args ...any) {
+	f.counts[category]++
+	log.Printf("  "+format, args...)
+	if !f.repair || fix == nil {
+		return
+	}
+	if err := fix(); err != nil {
+		log.Printf("    Repair failed: %v", err)
+		return
+	}
+	f.repaired++
+	log.Printf("    Repaired.")
+}
+
+func (f *fsck) summarize() {
+	total := 0
+	categories := make([]string, 0, len(f.counts))
+	for c, n := range f.counts {
+		categories = append(categories, c)
+		total += n
+	}
+	if total == 0 {
+		log.Println("No problems found.")
+		return
+	}
+	slices.Sort(categories)
+	log.Println("Problems found:")
+	for _, c := range categories {
+		log.Printf("  %s: %d", c, f.counts[c])
+	}
+	if f.repair {
+		log.Printf("Repaired %d of %d problems.", f.repaired, total)
+	} else {
+		log.Println("Use the repair flag to fix these problems.")
+	}
+}
+
+// studyParticipants returns all the participants in all the studies, keyed by study ID
+// and lowercase UPN. Participant lists for unknown studies are included.
+func studyParticipants() map[string]map[string]*storage.StudyParticipant {
+	ids, err := storage.GetAllStudyIds()
+	if err != nil {
+		log.Fatal(err)
+	}
+	collect := func(id string) error {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+		return nil
+	}
+	if err := platform.MapKeys(context.Background(), collect, storage.ParticipantIndex("")); err != nil {
+		log.Fatal(err)
+	}
+	result := make(map[string]map[string]*storage.StudyParticipant)
+	for _, studyId := range ids {
+		participants, err := storage.GetAllStudyParticipants(studyId)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m := make(map[string]*storage.StudyParticipant)
+		for _, p := range participants {
+			m[strings.ToLower(p.Upn)] = p
+		}
+		result[studyId] = m
+	}
+	return result
+}
+
+func (f *fsck) checkProfileMap() {
+	memberships, err := storage.GetAllProfileStudyMemberships()
+	if err != nil {
+		log.Fatal(err)
+	}
+	participants := studyParticipants()
+	for profileId, m := range memberships {
+		studyId, upn := m[0], m[1]
+		remove := func() error { return storage.RemoveProfileStudyMembership(profileId) }
+		p := participants[studyId][strings.ToLower(upn)]
+		if p == nil {
+			f.problem("profile map", "Profile %s maps to missing participant %s in study %s",
+				remove, profileId, upn, studyId)
+		} else if p.ProfileId != profileId {
+			f.problem("profile map", "Profile %s maps to participant %s in study %s, who has profile %q",
+				remove, profileId, upn, studyId, p.ProfileId)
+		} else if p.Started == 0 || p.Finished != 0 {
+			f.problem("profile map", "Profile %s maps to inactive participant %s in study %s",
+				remove, profileId, upn, studyId)
+		}
+	}
+}
+
+func (f *fsck) checkActiveParticipants() {
+	memberships, err := storage.GetAllProfileStudyMemberships()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for studyId, participants := range studyParticipants() {
+		for _, p := range participants {
+			if p.ProfileId == "" || p.Started == 0 || p.Finished != 0 {
+				continue
+			}
+			add := func() error { return storage.SetProfileStudyMembership(p.ProfileId, studyId, p.Upn) }
+			m, ok := memberships[p.ProfileId]
+			if !ok {
+				f.problem("active participants", "Participant %s in study %s has profile %s with no map entry",
+					add, p.Upn, studyId, p.ProfileId)
+			} else if m[0] != studyId || !strings.EqualFold(m[1], p.Upn) {
+				f.problem("active participants", "Participant %s in study %s has profile %s mapped to %s in study %s",
+					nil, p.Upn, studyId, p.ProfileId, m[1], m[0])
+			}
+		}
+	}
+}
+
+func (f *fsck) checkParticipantLists() {
+	studyIds, err := storage.GetAllStudyIds()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for studyId, participants := range studyParticipants() {
+		if slices.Contains(studyIds, studyId) {
+			continue
+		}
+		remove := func() error {
+			return platform.DeleteStorage(context.Background(), storage.ParticipantIndex(studyId))
+		}
+		f.problem("participant lists", "Missing study %s has a list of %d participants",
+			remove, studyId, len(participants))
+	}
+}
+
+func (f *fsck) checkLineStats() {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(context.Background(), collect, storage.StudyTypedLineStatsIndex("")); err != nil {
+		log.Fatal(err)
+	}
+	participants := studyParticipants()
+	for _, id := range ids {
+		studyId, upn, _ := strings.Cut(id, "+")
+		if participants[studyId][strings.ToLower(upn)] != nil {
+			continue
+		}
+		remove := func() error {
+			return platform.DeleteStorage(context.Background(), storage.StudyTypedLineStatsIndex(id))
+		}
+		f.problem("line stats", "Line stats exist for missing participant %s in study %s",
+			remove, upn, studyId)
+	}
+}
+
+func (f *fsck) checkParticipantPhraseStats() {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(context.Background(), collect, storage.ParticipantPhraseStatsIndex("")); err != nil {
+		log.Fatal(err)
+	}
+	participants := studyParticipants()
+	for _, id := range ids {
+		studyId, upn, _ := strings.Cut(id, "+")
+		if participants[studyId][strings.ToLower(upn)] != nil {
+			continue
+		}
+		remove := func() error {
+			return platform.DeleteStorage(context.Background(), storage.ParticipantPhraseStatsIndex(id))
+		}
+		f.problem("phrase stats", "Phrase stats exist for missing participant %s in study %s",
+			remove, upn, studyId)
+	}
+}
+
+func (f *fsck) checkQuestionnaireResponses() {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(context.Background(), collect, storage.QuestionnaireResponseList("")); err != nil {
+		log.Fatal(err)
+	}
+	participants := studyParticipants()
+	for _, id := range ids {
+		studyId, upn, _ := strings.Cut(id, "+")
+		if participants[studyId][strings.ToLower(upn)] != nil {
+			continue
+		}
+		remove := func() error {
+			return platform.DeleteStorage(context.Background(), storage.QuestionnaireResponseList(id))
+		}
+		f.problem("questionnaire responses", "Questionnaire responses exist for missing participant %s in study %s",
+			remove, upn, studyId)
+	}
+}
+
+func (f *fsck) checkConsentRecords() {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(context.Background(), collect, storage.ConsentRecordList("")); err != nil {
+		log.Fatal(err)
+	}
+	participants := studyParticipants()
+	for _, id := range ids {
+		studyId, upn, _ := strings.Cut(id, "+")
+		if participants[studyId][strings.ToLower(upn)] != nil {
+			continue
+		}
+		remove := func() error {
+			return platform.DeleteStorage(context.Background(), storage.ConsentRecordList(id))
+		}
+		f.problem("consent records", "Consent records exist for missing participant %s in study %s",
+			remove, upn, studyId)
+	}
+}
+
+func (f *fsck) checkMessages() {
+	ctx := context.Background()
+	studyIds, err := storage.GetAllStudyIds()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(ctx, collect, storage.StudyMessageIndex("")); err != nil {
+		log.Fatal(err)
+	}
+	for _, studyId := range ids {
+		if slices.Contains(studyIds, studyId) {
+			continue
+		}
+		remove := func() error { return platform.DeleteStorage(ctx, storage.StudyMessageIndex(studyId)) }
+		f.problem("messages", "Messages exist for missing study %s", remove, studyId)
+	}
+	ids = nil
+	if err := platform.MapKeys(ctx, collect, storage.ProfileMessageIndex("")); err != nil {
+		log.Fatal(err)
+	}
+	if err := platform.MapKeys(ctx, collect, storage.UndeliveredMessageSet("")); err != nil {
+		log.Fatal(err)
+	}
+	slices.Sort(ids)
+	for _, profileId := range slices.Compact(ids) {
+		messages, err := storage.GetAllProfileMessages(profileId)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var copies []string
+		for _, pm := range messages {
+			copies = append(copies, pm.Id)
+			m, err := storage.GetStudyMessage(pm.StudyId, pm.Id)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if m != nil {
+				continue
+			}
+			remove := func() error {
+				if err := platform.MapRemove(ctx, storage.ProfileMessageIndex(profileId), pm.Id); err != nil {
+					return err
+				}
+				return platform.RemoveMembers(ctx, storage.UndeliveredMessageSet(profileId), pm.Id)
+			}
+			f.problem("messages", "Profile %s has a copy of missing message %s in study %s",
+				remove, profileId, pm.Id, pm.StudyId)
+		}
+		undelivered, err := platform.FetchMembers(ctx, storage.UndeliveredMessageSet(profileId))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, id := range undelivered {
+			if slices.Contains(copies, id) {
+				continue
+			}
+			remove := func() error { return platform.RemoveMembers(ctx, storage.UndeliveredMessageSet(profileId), id) }
+			f.problem("messages", "Profile %s has pending message %s, which it doesn't have a copy of",
+				remove, profileId, id)
+		}
+	}
+}
+
+func (f *fsck) checkMonitors() {
+	ctx := context.Background()
+	scheduled, err := storage.GetAllMonitoredProfiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var monitored []string
+	m := new(storage.SpeechMonitor)
+	mapper := func() error {
+		monitored = append(monitored, m.ProfileId)
+		return nil
+	}
+	if err := platform.MapObjects(ctx, mapper, m); err != nil {
+		log.Fatal(err)
+	}
+	for _, profileId := range monitored {
+		remove := func() error { return storage.RemoveMonitor(profileId) }
+		s, err := storage.GetSpeechSettings(profileId)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if s == nil || s.ApiKey == "" {
+			f.problem("speech monitors", "Monitor for profile %s has no speech settings",
+				remove, profileId)
+		} else if !slices.Contains(scheduled, profileId) {
+			reschedule := func() error { return storage.EnsureMonitor(profileId, s.ApiKey) }
+			f.problem("speech monitors", "Monitor for profile %s is not scheduled",
+				reschedule, profileId)
+		}
+	}
+	for _, profileId := range scheduled {
+		if slices.Contains(monitored, profileId) {
+			continue
+		}
+		remove := func() error { return storage.RemoveMonitor(profileId) }
+		f.problem("speech monitors", "Scheduled monitor for profile %s doesn't exist",
+			remove, profileId)
+	}
+}
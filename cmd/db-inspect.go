@@ -0,0 +1,156 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+)
+
+// dbInspectCmd represents the inspect command
+var dbInspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Inspect and edit stored objects",
+	Long: `This is the parent command for inspecting and editing stored objects.
+Unlike the cli command, it decodes stored values to JSON.
+It must be invoked with a subcommand.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.SetFlags(0)
+		log.Fatal("You must specify a subcommand.")
+	},
+}
+
+// dbInspectListCmd represents the inspect list command
+var dbInspectListCmd = &cobra.Command{
+	Use:   "list [prefix]",
+	Short: "List stored keys",
+	Long: `List the stored keys that start with the given storage prefix.
+If no prefix is given, list the registered prefixes and their kinds.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pushInspectConfig(cmd)
+		if len(args) == 0 {
+			for _, r := range storage.RegisteredPrefixes() {
+				fmt.Printf("%-30s %s\n", r.Prefix, r.Kind)
+			}
+			return
+		}
+		keys, err := storage.ListStoredKeys(args[0])
+		if err != nil {
+			log.Fatalf("Can't list keys: %v", err)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+	},
+}
+
+// dbInspectShowCmd represents the inspect show command
+var dbInspectShowCmd = &cobra.Command{
+	Use:   "show key [field-or-index]",
+	Short: "Show a stored value as JSON",
+	Long: `Show the value stored at a key, decoded to JSON.
+For maps and lists, give a field or index to show just that element.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pushInspectConfig(cmd)
+		var val any
+		var err error
+		if len(args) == 1 {
+			val, err = storage.InspectStoredKey(args[0])
+		} else {
+			val, err = storage.InspectStoredElement(args[0], args[1])
+		}
+		if err != nil {
+			log.Fatalf("Can't inspect %q: %v", args[0], err)
+		}
+		if val == nil {
+			log.Fatalf("Nothing is stored at %q", args[0])
+		}
+		printJSON(val)
+	},
+}
+
+// dbInspectEditCmd represents the inspect edit command
+var dbInspectEditCmd = &cobra.Command{
+	Use:   "edit key [field-or-index]",
+	Short: "Replace a stored value from JSON",
+	Long: `Replace an existing stored value with one read as JSON from a file (or stdin).
+For maps and lists, give the field or index of the element to replace.
+The JSON must match the stored type, and is re-encoded just as the server would.
+Nothing is changed unless the yes flag is given; without it, the edit is only checked.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pushInspectConfig(cmd)
+		key, element := args[0], ""
+		if len(args) > 1 {
+			element = args[1]
+		}
+		path, _ := cmd.Flags().GetString("file")
+		confirmed, _ := cmd.Flags().GetBool("yes")
+		var js []byte
+		var err error
+		if path == "" || path == "-" {
+			js, err = io.ReadAll(os.Stdin)
+		} else {
+			js, err = os.ReadFile(path)
+		}
+		if err != nil {
+			log.Fatalf("Can't read JSON: %v", err)
+		}
+		val, err := storage.EditStoredValue(key, element, js, !confirmed)
+		if errors.Is(err, platform.NotFoundError) {
+			log.Fatalf("Only existing values can be edited, and there is none at %q %s", key, element)
+		} else if err != nil {
+			log.Fatalf("Can't edit %q: %v", key, err)
+		}
+		printJSON(val)
+		if confirmed {
+			log.Printf("Stored the above value.")
+		} else {
+			log.Printf("The above value would be stored. Use the yes flag to store it.")
+		}
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbInspectCmd)
+	dbInspectCmd.Args = cobra.NoArgs
+	dbInspectCmd.AddCommand(dbInspectListCmd)
+	dbInspectListCmd.Args = cobra.MaximumNArgs(1)
+	dbInspectCmd.AddCommand(dbInspectShowCmd)
+	dbInspectShowCmd.Args = cobra.RangeArgs(1, 2)
+	dbInspectCmd.AddCommand(dbInspectEditCmd)
+	dbInspectEditCmd.Args = cobra.RangeArgs(1, 2)
+	dbInspectEditCmd.Flags().StringP("file", "f", "", "The file containing the JSON (default stdin)")
+	dbInspectEditCmd.Flags().Bool("yes", false, "Actually store the edited value")
+}
+
+func pushInspectConfig(cmd *cobra.Command) {
+	log.SetFlags(0)
+	env, _ := cmd.Flags().GetString("env")
+	if err := platform.PushConfig(env); err != nil {
+		log.Fatalf("Can't load environment %q: %v", env, err)
+	}
+	useStorageLogger()
+}
+
+func printJSON(val any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(val); err != nil {
+		log.Fatalf("Can't encode JSON: %v", err)
+	}
+}
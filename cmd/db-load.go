@@ -0,0 +1,66 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+)
+
+// dbLoadCmd represents the load command
+var dbLoadCmd = &cobra.Command{
+	Use:   "load file",
+	Short: "Load the database from a JSON file",
+	Long: `Load stored objects from a JSON file produced by the dump command.
+Loaded objects replace any existing objects with the same keys.
+Use the study flag to load only the objects for specific studies,
+and the prefix flag to load only the categories with specific storage prefixes.
+Use the dry-run flag to check the file and see what would be loaded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.SetFlags(0)
+		env, _ := cmd.Flags().GetString("env")
+		if err := platform.PushConfig(env); err != nil {
+			log.Fatalf("Can't load environment %q: %v", env, err)
+		}
+		useStorageLogger()
+		studyIds, _ := cmd.Flags().GetStringSlice("study")
+		prefixes, _ := cmd.Flags().GetStringSlice("prefix")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		load(storage.TransferFilter{StudyIds: studyIds, Prefixes: prefixes}, args[0], dryRun)
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbLoadCmd)
+	dbLoadCmd.Args = cobra.ExactArgs(1)
+	dbLoadCmd.Flags().StringSlice("study", nil, "Only load objects for these study IDs")
+	dbLoadCmd.Flags().StringSlice("prefix", nil, "Only load categories with these storage prefixes")
+	dbLoadCmd.Flags().Bool("dry-run", false, "Report what would be loaded without loading it")
+}
+
+func load(filter storage.TransferFilter, path string, dryRun bool) {
+	objects, err := platform.LoadObjectsFromPath(path)
+	if err != nil {
+		log.Fatalf("Read failed: %v", err)
+	}
+	counts, err := storage.LoadObjects(objects, filter, dryRun)
+	verb := "Loaded"
+	if dryRun {
+		verb = "Would load"
+	}
+	for _, c := range storage.TransferCategories() {
+		if n, ok := counts[c[0]]; ok {
+			log.Printf("%s %d of %d %s (%s)", verb, n, len(objects[c[0]]), c[0], c[1])
+		}
+	}
+	if err != nil {
+		log.Fatalf("Load failed: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package cmd
+
+import (
+	"context"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+// dbMigrateCmd represents the migrate command
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate stored objects to the current schema",
+	Long: `Run all the schema migrations that the database hasn't had yet.
+The server won't start against a database with pending migrations.
+Use the dry-run flag to see what would be changed without changing it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.SetFlags(0)
+		env, _ := cmd.Flags().GetString("env")
+		if err := platform.PushConfig(env); err != nil {
+			log.Fatalf("Can't load environment %q: %v", env, err)
+		}
+		useStorageLogger()
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		migrate(dryRun)
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbMigrateCmd.Args = cobra.NoArgs
+	dbMigrateCmd.Flags().Bool("dry-run", false, "Report what would be migrated without migrating it")
+}
+
+func migrate(dryRun bool) {
+	ctx := context.Background()
+	pending, err := platform.PendingMigrations(ctx)
+	if err != nil {
+		log.Fatalf("Can't check for migrations: %v", err)
+	}
+	if len(pending) == 0 {
+		log.Println("The database is up to date.")
+		return
+	}
+	report := func(m platform.Migration, examined int64) {
+		if examined == 0 {
+			log.Printf("Migrating %s to version %d (%s)...", m.Type, m.Version, m.Description)
+		} else if examined%1000 == 0 {
+			log.Printf("  ...examined %d", examined)
+		}
+	}
+	changed, err := platform.RunMigrations(ctx, dryRun, report)
+	verb := "Changed"
+	if dryRun {
+		verb = "Would change"
+	}
+	reported := make(map[string]bool)
+	for _, m := range pending {
+		if n, ok := changed[m.Type]; ok && !reported[m.Type] {
+			reported[m.Type] = true
+			log.Printf("%s %d stored %s values.", verb, n, m.Type)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	if !dryRun {
+		log.Printf("Ran %d migrations.", len(pending))
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"log"
 
 	"github.com/spf13/cobra"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+	"go.uber.org/zap"
 )
 
 // dbCmd represents the db command
@@ -27,3 +29,18 @@ It must be invoked with a subcommand.`,
 func init() {
 	rootCmd.AddCommand(dbCmd)
 }
+
+// useStorageLogger gives the storage layer a logger when it's used outside the server.
+// Only warnings and errors are logged, and they go to stderr.
+func useStorageLogger() {
+	if storage.ServerLogger != nil {
+		return
+	}
+	config := zap.NewDevelopmentConfig()
+	config.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+	logger, err := config.Build()
+	if err != nil {
+		log.Fatalf("Can't create a logger: %v", err)
+	}
+	storage.ServerLogger = logger
+}
@@ -8,6 +8,7 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/whisper-project/in-my-voice.server.golang/api/swift"
 	"github.com/whisper-project/in-my-voice.server.golang/gui/admin"
@@ -47,6 +48,11 @@ func init() {
 }
 
 func serve(address, port string) {
+	if pending, err := platform.PendingMigrations(context.Background()); err != nil {
+		log.Fatalf("Can't check for migrations: %v", err)
+	} else if len(pending) > 0 {
+		log.Fatalf("The database has %d pending migrations; run the db migrate command first.", len(pending))
+	}
 	//goland:noinspection SpellCheckingInspection
 	if err := storage.EnsureSuperAdmin(user("WUc6n`bmkao+orplhgy4vzp")); err != nil {
 		panic(err)
@@ -22,10 +22,25 @@ func AddRoutes(r *gin.RouterGroup) {
 	r.POST("/:sessionId/home", handlers.AuthMiddleware, handlers.PostHomeHandler)
 	r.GET("/:sessionId/users", handlers.AuthMiddleware, handlers.GetUsersHandler)
 	r.POST("/:sessionId/users", handlers.AuthMiddleware, handlers.PostUsersHandler)
+	r.GET("/:sessionId/settings", handlers.AuthMiddleware, handlers.GetSettingsHandler)
+	r.POST("/:sessionId/settings", handlers.AuthMiddleware, handlers.PostSettingsHandler)
 	r.GET("/:sessionId/participants", handlers.AuthMiddleware, handlers.GetParticipantsHandler)
 	r.POST("/:sessionId/participants", handlers.AuthMiddleware, handlers.PostParticipantsHandler)
 	r.GET("/:sessionId/reports", handlers.AuthMiddleware, handlers.GetReportsHandler)
 	r.POST("/:sessionId/reports", handlers.AuthMiddleware, handlers.PostReportsHandler)
+	r.GET("/:sessionId/problems", handlers.AuthMiddleware, handlers.GetProblemsHandler)
+	r.GET("/:sessionId/libraries", handlers.AuthMiddleware, handlers.GetLibrariesHandler)
+	r.POST("/:sessionId/libraries", handlers.AuthMiddleware, handlers.PostLibrariesHandler)
+	r.GET("/:sessionId/questionnaires", handlers.AuthMiddleware, handlers.GetQuestionnairesHandler)
+	r.POST("/:sessionId/questionnaires", handlers.AuthMiddleware, handlers.PostQuestionnairesHandler)
+	r.GET("/:sessionId/messages", handlers.AuthMiddleware, handlers.GetMessagesHandler)
+	r.POST("/:sessionId/messages", handlers.AuthMiddleware, handlers.PostMessagesHandler)
+	r.GET("/:sessionId/consent", handlers.AuthMiddleware, handlers.GetConsentHandler)
+	r.POST("/:sessionId/consent", handlers.AuthMiddleware, handlers.PostConsentHandler)
+	r.GET("/:sessionId/versions", handlers.AuthMiddleware, handlers.GetVersionsHandler)
+	r.POST("/:sessionId/versions", handlers.AuthMiddleware, handlers.PostVersionsHandler)
+	r.GET("/:sessionId/flags", handlers.AuthMiddleware, handlers.GetFlagsHandler)
+	r.POST("/:sessionId/flags", handlers.AuthMiddleware, handlers.PostFlagsHandler)
 	r.GET("/:sessionId/admins", handlers.AuthMiddleware, handlers.GetAdminsHandler)
 	r.POST("/:sessionId/admins", handlers.AuthMiddleware, handlers.PostAdminsHandler)
 	r.GET("/:sessionId/studies", handlers.AuthMiddleware, handlers.GetStudiesHandler)
@@ -14,12 +14,13 @@ import (
 	"net/url"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/whisper-project/in-my-voice.server.golang/middleware"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
 	"github.com/whisper-project/in-my-voice.server.golang/services"
 	"github.com/whisper-project/in-my-voice.server.golang/storage"
 	"go.uber.org/zap"
@@ -329,6 +330,132 @@ func PostUsersHandler(c *gin.Context) {
 	c.Redirect(http.StatusSeeOther, target)
 }
 
+func GetSettingsHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleUserManager) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	study, _ := storage.GetStudy(u.StudyId)
+	if study == nil {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	thresholds := make([]string, 0, len(study.AlertThresholds))
+	for _, t := range study.AlertThresholds {
+		thresholds = append(thresholds, fmt.Sprintf("%d", t))
+	}
+	settings := map[string]string{
+		"Thresholds":   strings.Join(thresholds, ", "),
+		"FailureCount": fmt.Sprintf("%d", study.FailureAlertCount),
+	}
+	if study.AlertDigest {
+		settings["Digest"] = "true"
+	}
+	settings["PhraseMin"] = fmt.Sprintf("%d", study.PhraseMinParticipants)
+	settings["PhraseRedaction"] = study.PhraseRedaction
+	if study.PhraseScrubPII {
+		settings["PhraseScrub"] = "true"
+	}
+	settings["InactivityDays"] = fmt.Sprintf("%d", study.InactivityDays)
+	settings["InactivityMessage"] = study.InactivityMessage
+	settings["Arms"] = storage.FormatStudyArms(study.Arms)
+	settings["ArmRandomization"] = study.ArmRandomization
+	settings["ArmBlockSize"] = fmt.Sprintf("%d", study.ArmBlockSize)
+	if study.ArmAssignOnCreate {
+		settings["ArmAssignOnCreate"] = "true"
+	}
+	c.HTML(http.StatusOK, "admin/settings.tmpl.html",
+		gin.H{"Study": study.Name, "Settings": settings, "Message": c.Query("msg")})
+}
+
+func PostSettingsHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleUserManager) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	study, err := storage.GetStudy(u.StudyId)
+	if err != nil || study == nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	thresholds, err := storage.ParseAlertThresholds(c.PostForm("thresholds"))
+	if err != nil {
+		msg := url.QueryEscape("Alert thresholds must be percentages between 1 and 100, separated by commas.")
+		c.Redirect(http.StatusSeeOther, "./settings?msg="+msg)
+		return
+	}
+	failureCount, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("failures")), 10, 64)
+	if err != nil || failureCount < 0 {
+		msg := url.QueryEscape("The speech failure count must be a non-negative number.")
+		c.Redirect(http.StatusSeeOther, "./settings?msg="+msg)
+		return
+	}
+	phraseMin, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("phraseMin")), 10, 64)
+	if err != nil || phraseMin < 0 {
+		msg := url.QueryEscape("The minimum number of participants must be a non-negative number.")
+		c.Redirect(http.StatusSeeOther, "./settings?msg="+msg)
+		return
+	}
+	inactivityDays, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("inactivityDays")), 10, 64)
+	if err != nil || inactivityDays < 0 {
+		msg := url.QueryEscape("The number of inactive days must be a non-negative number.")
+		c.Redirect(http.StatusSeeOther, "./settings?msg="+msg)
+		return
+	}
+	redaction := c.PostForm("phraseRedaction")
+	if redaction != storage.PhraseRedactionHash {
+		redaction = storage.PhraseRedactionRedact
+	}
+	arms, err := storage.ParseStudyArms(strings.ReplaceAll(c.PostForm("arms"), "\r\n", "\n"))
+	if err != nil {
+		msg := url.QueryEscape(fmt.Sprintf("Arms not changed: %v.", err))
+		c.Redirect(http.StatusSeeOther, "./settings?msg="+msg)
+		return
+	}
+	armBlockSize, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("armBlockSize")), 10, 64)
+	if err != nil {
+		armBlockSize = -1
+	}
+	armRandomization := c.PostForm("armRandomization")
+	if armRandomization != storage.ArmRandomizationStratified {
+		armRandomization = storage.ArmRandomizationBlock
+	}
+	armAssignOnCreate := c.PostForm("armAssignOnCreate") == "on"
+	// only changes to the arms restart their randomization
+	if storage.FormatStudyArms(arms) != storage.FormatStudyArms(study.Arms) ||
+		armRandomization != study.ArmRandomization || armBlockSize != study.ArmBlockSize ||
+		armAssignOnCreate != study.ArmAssignOnCreate {
+		err := study.SetArms(arms, armRandomization, armBlockSize, armAssignOnCreate)
+		if errors.Is(err, storage.InvalidStudyArmsError) || errors.Is(err, storage.StudyArmInUseError) {
+			msg := url.QueryEscape(fmt.Sprintf("Arms not changed: %v.", err))
+			c.Redirect(http.StatusSeeOther, "./settings?msg="+msg)
+			return
+		} else if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+	}
+	study.AlertThresholds = thresholds
+	study.FailureAlertCount = failureCount
+	study.AlertDigest = c.PostForm("digest") == "on"
+	study.PhraseMinParticipants = phraseMin
+	study.PhraseRedaction = redaction
+	study.PhraseScrubPII = c.PostForm("phraseScrub") == "on"
+	study.InactivityDays = inactivityDays
+	study.InactivityMessage = strings.ReplaceAll(strings.TrimSpace(c.PostForm("inactivityMessage")), "\r\n", "\n")
+	if err := study.Save(); err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	msg := url.QueryEscape("Study settings updated successfully.")
+	c.Redirect(http.StatusSeeOther, "./settings?msg="+msg)
+}
+
 func GetParticipantsHandler(c *gin.Context) {
 	u := getAuthenticatedUser(c)
 	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleParticipantManager) {
@@ -347,11 +474,21 @@ func GetParticipantsHandler(c *gin.Context) {
 		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
+	consent, err := storage.GetCurrentConsentDocument(u.StudyId)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	var consentVersion int64
+	if consent != nil {
+		consentVersion = consent.Version
+	}
 	slices.SortFunc(participants, CompareParticipantsFunc(c.Query("sort")))
 	message := c.Query("msg")
 	editId := c.Query("edit")
 	deleteId := c.Query("delete")
 	var pEdit map[string]string
+	var favorites, devices []map[string]string
 	pList := make([]map[string]string, 0, len(participants))
 	for _, p := range participants {
 		if deleteId == p.Upn {
@@ -369,6 +506,11 @@ func GetParticipantsHandler(c *gin.Context) {
 			editId = ""
 			pEdit = map[string]string{"UPN": p.Upn}
 			pEdit["Memo"] = p.Memo
+			pEdit["Stratum"] = p.Stratum
+			pEdit["Arm"] = p.Arm
+			if p.ArmAssigned > 0 {
+				pEdit["ArmAssigned"] = formatDateTime(p.ArmAssigned)
+			}
 			if p.Assigned > 0 {
 				pEdit["Assigned"] = formatDateTime(p.Assigned)
 			}
@@ -380,15 +522,90 @@ func GetParticipantsHandler(c *gin.Context) {
 			if p.Finished > 0 {
 				pEdit["Finished"] = formatDateTime(p.Finished)
 			}
+			if p.ConsentVersion > 0 {
+				pEdit["Consent"] = fmt.Sprintf("version %d at %s", p.ConsentVersion, formatDateTime(p.ConsentAccepted))
+			}
+			if p.Withdrawn > 0 {
+				pEdit["Withdrawn"] = formatDateTime(p.Withdrawn)
+				// a withdrawal that didn't complete can be retried
+				if done, err := storage.HasWithdrawalTombstone(p); err == nil && !done {
+					pEdit["Withdrawable"] = "true"
+				}
+			} else if p.Started > 0 {
+				pEdit["Withdrawable"] = "true"
+			}
+			if p.ProfileId != "" {
+				pDevices, err := storage.GetProfileDevices(p.ProfileId)
+				if err != nil {
+					c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+					return
+				}
+				for _, d := range pDevices {
+					status := "Signed in"
+					if d.Revoked > 0 {
+						status = "Revoked " + formatDateTime(d.Revoked)
+					}
+					devices = append(devices, map[string]string{
+						"ClientId":   d.ClientId,
+						"ClientType": d.ClientType,
+						"Platform":   d.Platform,
+						"AppVersion": d.AppVersion,
+						"FirstSeen":  formatDateTime(d.FirstSeen),
+						"LastSeen":   formatDateTime(d.LastSeen),
+						"Status":     status,
+					})
+				}
+				versions, err := storage.GetFavoritesHistory(p.ProfileId)
+				if err != nil {
+					c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+					return
+				}
+				for i, v := range versions {
+					favorites = append(favorites, map[string]string{
+						"ETag":     v.ETag,
+						"Saved":    formatDateTime(v.Saved),
+						"ClientId": v.ClientId,
+						"Current":  strconv.FormatBool(i == 0),
+					})
+				}
+			}
 		}
-		pList = append(pList, MakeParticipantMap(p))
+		pList = append(pList, MakeParticipantMap(p, consentVersion))
 	}
 	if editId != "" {
 		c.Redirect(http.StatusSeeOther, "./participants")
 		return
 	}
-	c.HTML(http.StatusOK, "admin/participants.tmpl.html",
-		gin.H{"Study": study.Name, "Participants": pList, "Edit": pEdit, "Message": message})
+	tombstones, err := storage.FetchWithdrawals(u.StudyId)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	withdrawals := make([]map[string]string, 0, len(tombstones))
+	for _, w := range tombstones {
+		data := "Kept"
+		if w.Purged {
+			data = "Deleted"
+		}
+		withdrawals = append(withdrawals, map[string]string{
+			"UPN":       w.Upn,
+			"Withdrawn": formatDateTime(w.Withdrawn),
+			"By":        w.By,
+			"Reason":    w.Reason,
+			"Data":      data,
+		})
+	}
+	c.HTML(http.StatusOK, "admin/participants.tmpl.html", gin.H{
+		"Study":        study.Name,
+		"Participants": pList,
+		"Edit":         pEdit,
+		"Favorites":    favorites,
+		"Devices":      devices,
+		"Withdrawals":  withdrawals,
+		"HasArms":      len(study.Arms) > 0,
+		"Stratified":   len(study.Arms) > 0 && study.ArmRandomization == storage.ArmRandomizationStratified,
+		"Message":      message,
+	})
 }
 
 func PostParticipantsHandler(c *gin.Context) {
@@ -403,10 +620,19 @@ func PostParticipantsHandler(c *gin.Context) {
 	msg := ""
 	editAgain := false
 	memo := strings.TrimSpace(c.PostForm("memo"))
+	stratum := strings.TrimSpace(c.PostForm("stratum"))
 	apiKey := strings.TrimSpace(c.PostForm("key"))
 	voiceId := strings.TrimSpace(c.PostForm("voice"))
 	var p *storage.StudyParticipant
 	var err error
+	if op == "restore-favorites" {
+		restoreParticipantFavorites(c, u.StudyId, upn, c.PostForm("etag"))
+		return
+	}
+	if op == "withdraw" {
+		withdrawParticipant(c, u, upn)
+		return
+	}
 	if op == "add" {
 		p, err = storage.CreateStudyParticipant(u.StudyId, upn)
 		if err != nil {
@@ -445,6 +671,26 @@ func PostParticipantsHandler(c *gin.Context) {
 			return
 		}
 	}
+	if stratum != p.Stratum {
+		if p.Arm != "" {
+			msg = url.QueryEscape("You can't change the stratum once the participant has been assigned an arm.")
+			editAgain = true
+		} else if err = p.UpdateStratum(stratum); err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+	}
+	study, err := storage.GetStudy(u.StudyId)
+	if err != nil || study == nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	if op == "add" && study.ArmAssignOnCreate {
+		if err = storage.AssignStudyArm(study, p); err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+	}
 	// edits to apiKey or voiceId must be processed together
 	if apiKey != p.ApiKey || voiceId != p.VoiceId {
 		allowChanges := true
@@ -490,31 +736,591 @@ func PostParticipantsHandler(c *gin.Context) {
 				editAgain = true
 			}
 		}
-		if allowChanges && p.Started > 0 {
-			// update the user to their new settings
-			didUpdate, err := storage.UpdateSpeechSettings(p.ProfileId, apiKey, voiceId, voiceName, "")
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
-				return
-			}
-			if didUpdate {
-				if err := storage.ProfileClientSpeechDidUpdate(p.ProfileId, "none"); err != nil {
-					middleware.CtxLog(c).Info("ignoring update notifications error",
-						zap.String("profileId", p.ProfileId), zap.Error(err))
-				}
-			}
+		if allowChanges && p.Started > 0 && study.UsesParticipantVoice(p) {
+			// update the user to their new settings
+			didUpdate, err := storage.UpdateSpeechSettings(p.ProfileId, apiKey, voiceId, voiceName, "")
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+				return
+			}
+			if didUpdate {
+				if err := storage.ProfileClientSpeechDidUpdate(p.ProfileId, "none"); err != nil {
+					middleware.CtxLog(c).Info("ignoring update notifications error",
+						zap.String("profileId", p.ProfileId), zap.Error(err))
+				}
+			}
+		}
+	}
+	target := "./participants?msg=" + msg
+	if editAgain {
+		target = fmt.Sprintf("./participants?edit=%s&msg=%s", upn, msg)
+	}
+	c.Redirect(http.StatusSeeOther, target)
+}
+
+// restoreParticipantFavorites makes a saved version of a participant's favorites current,
+// and lets the participant's clients know their favorites have changed.
+func restoreParticipantFavorites(c *gin.Context, studyId, upn, etag string) {
+	p, err := storage.GetStudyParticipant(studyId, upn)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	if p == nil || p.ProfileId == "" {
+		msg := url.QueryEscape("Participant not found.")
+		c.Redirect(http.StatusSeeOther, "./participants?msg="+msg)
+		return
+	}
+	f, changed, err := storage.RestoreFavoritesVersion(p.ProfileId, "console", etag)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	msg := url.QueryEscape("Favorites restored successfully.")
+	if f == nil {
+		msg = url.QueryEscape("That favorites version is no longer available.")
+	} else if !changed {
+		msg = url.QueryEscape("That favorites version is already current.")
+	} else if err := storage.ProfileClientFavoritesDidUpdate(p.ProfileId, "none"); err != nil {
+		middleware.CtxLog(c).Info("ignoring update notifications error",
+			zap.String("profileId", p.ProfileId), zap.Error(err))
+	}
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("./participants?edit=%s&msg=%s", url.QueryEscape(upn), msg))
+}
+
+// withdrawParticipant withdraws a participant at the request of an admin,
+// letting the participant know in the app if they were still active.
+func withdrawParticipant(c *gin.Context, u *storage.AdminUser, upn string) {
+	p, err := storage.GetStudyParticipant(u.StudyId, upn)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	reason := strings.TrimSpace(c.PostForm("reason"))
+	notWithdrawable := url.QueryEscape("Only participants who have enrolled can be withdrawn, and only once.")
+	if p == nil || p.Started == 0 {
+		c.Redirect(http.StatusSeeOther, "./participants?msg="+notWithdrawable)
+		return
+	}
+	if reason == "" {
+		msg := url.QueryEscape("You must give a reason for the withdrawal.")
+		c.Redirect(http.StatusSeeOther, fmt.Sprintf("./participants?edit=%s&msg=%s", url.QueryEscape(upn), msg))
+		return
+	}
+	purge := c.PostForm("data") == "purge"
+	// a participant whose withdrawal didn't complete can be withdrawn again
+	if _, err := storage.WithdrawStudyParticipant(u.StudyId, p.Upn, u.Email, reason, purge); errors.Is(err, storage.ParticipantNotWithdrawableError) {
+		c.Redirect(http.StatusSeeOther, "./participants?msg="+notWithdrawable)
+		return
+	} else if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	if p.Finished == 0 {
+		message := "You have been withdrawn from the study. Thank you for participating!"
+		if err := storage.QueueProfileMessage(p.ProfileId, message); err != nil {
+			middleware.CtxLog(c).Info("ignoring withdrawal message error",
+				zap.String("profileId", p.ProfileId), zap.Error(err))
+		}
+	}
+	msg := "Participant withdrawn; their data has been kept."
+	if purge {
+		msg = "Participant withdrawn; their data has been deleted."
+	}
+	c.Redirect(http.StatusSeeOther, "./participants?msg="+url.QueryEscape(msg))
+}
+
+func GetReportsHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleResearcher) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	study, _ := storage.GetStudy(u.StudyId)
+	if study == nil {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	if generateId := c.Query("generate"); generateId != "" {
+		report, err := storage.GetStudyReport(u.StudyId, generateId)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+		if report == nil || (report.Raw && !u.HasRole(storage.AdminRoleDataSteward)) {
+			// shouldn't happen
+			msg := url.QueryEscape("Report not found.")
+			c.Redirect(http.StatusSeeOther, "./reports?msg="+msg)
+			return
+		}
+		if err = report.Generate(); err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+		msg := url.QueryEscape("Report generated successfully.")
+		c.Redirect(http.StatusSeeOther, "./reports?msg="+msg)
+	}
+	message := c.Query("msg")
+	deleteId := c.Query("delete")
+	// create the list of existing reports
+	reports, err := storage.FetchAllStudyReports(u.StudyId)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	slices.SortFunc(reports, CompareReportsFunc(c.Query("sort")))
+	reportList := make([]map[string]string, 0, len(reports))
+	steward := u.HasRole(storage.AdminRoleDataSteward)
+	for _, r := range reports {
+		if r.Raw && !steward {
+			// only data stewards can see raw phrase content
+			continue
+		}
+		if r.ReportId == deleteId || r.Generated == 0 {
+			if err := r.Delete(); err != nil {
+				c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+				return
+			}
+			continue
+		}
+		var restricted string
+		if len(r.Upns) > 0 {
+			restricted = "Yes"
+		}
+		reportType := r.Type + " (" + r.Format + ")"
+		if r.Raw {
+			reportType += " (raw)"
+		}
+		reportList = append(reportList, map[string]string{
+			"Id":        r.ReportId,
+			"Name":      r.Name,
+			"Type":      reportType,
+			"Start":     formatDate(r.Start),
+			"End":       formatDate(r.End),
+			"Upns":      restricted,
+			"Generated": formatDateTime(r.Generated),
+			"Filename":  r.Filename,
+		})
+	}
+	// create select for report generation
+	participants, err := storage.GetAllStudyParticipants(u.StudyId)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	slices.SortFunc(participants, CompareParticipantsFunc(""))
+	upns := make([]string, 0, len(participants))
+	for _, p := range participants {
+		if p.Started == 0 {
+			continue
+		}
+		upns = append(upns, p.Upn)
+	}
+	c.HTML(http.StatusOK, "admin/reports.tmpl.html",
+		gin.H{"Study": study.Name, "Upns": upns, "Message": message, "Reports": reportList, "Steward": steward})
+}
+
+func PostReportsHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleResearcher) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	study, err := storage.GetStudy(u.StudyId)
+	if err != nil || study == nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	op := c.PostForm("op")
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		// shouldn't happen
+		message := url.QueryEscape("The report name must not be empty.")
+		c.Redirect(http.StatusSeeOther, "./reports?msg="+message)
+		return
+	}
+	switch op {
+	case storage.ReportTypeLines, storage.ReportTypePhrases, storage.ReportTypeCombined,
+		storage.ReportTypeEngagement, storage.ReportTypeQuestionnaires:
+	default:
+		// shouldn't happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	// lines reports always have a date range, but other reports
+	// without dates cover the study's lifetime
+	var start, end int64
+	startString, endString := c.PostForm("start"), c.PostForm("end")
+	if op == storage.ReportTypeLines || startString != "" || endString != "" {
+		start, end, err = storage.ComputeReportDates(startString, endString, "2006-01-02")
+		if err != nil {
+			// shouldn't happen
+			middleware.CtxLog(c).Info("Invalid date in a posted report request",
+				zap.String("start", startString), zap.String("end", endString), zap.Error(err))
+			message := url.QueryEscape("Invalid start or end date.")
+			c.Redirect(http.StatusSeeOther, "./reports?msg="+message)
+			return
+		}
+	}
+	format := c.PostForm("format")
+	if format == "" {
+		format = storage.ReportFormatXlsx
+	}
+	// create the report object
+	r := storage.NewStudyReport(study.Id, name, op, format, start, end, c.PostFormArray("upns"))
+	if op == storage.ReportTypePhrases || op == storage.ReportTypeCombined || op == storage.ReportTypeQuestionnaires {
+		r.Raw = c.PostForm("raw") == "on" && u.HasRole(storage.AdminRoleDataSteward)
+	}
+	if err := r.CheckFormat(); err != nil {
+		message := url.QueryEscape(fmt.Sprintf("Can't generate a %s report as %s.", op, format))
+		c.Redirect(http.StatusSeeOther, "./reports?msg="+message)
+		return
+	}
+	if err := r.Generate(); err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	msg := url.QueryEscape("Report generated successfully.")
+	c.Redirect(http.StatusSeeOther, "./reports?msg="+msg)
+}
+
+func DownloadReportHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleResearcher) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	report, err := storage.GetStudyReport(u.StudyId, c.Param("reportId"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if report == nil || (report.Raw && !u.HasRole(storage.AdminRoleDataSteward)) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	data, err := report.Retrieve()
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	defer data.Close()
+	c.Header("Content-Type", storage.ReportContentType(report.Format))
+	c.Status(http.StatusOK)
+	c.Stream(func(w io.Writer) bool {
+		if _, err := io.Copy(w, data); err != nil {
+			return true
+		}
+		return false
+	})
+}
+
+func GetProblemsHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || !u.HasRole(storage.AdminRoleParticipantManager) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	kind, status, scope := c.Query("kind"), c.Query("status"), c.Query("scope")
+	if status == "" {
+		status = "open"
+	}
+	if !u.HasRole(storage.AdminRoleSuperAdmin) {
+		// only developers can see problems from outside their study
+		scope = ""
+	}
+	filters := url.Values{"kind": {kind}, "status": {status}, "scope": {scope}}.Encode()
+	reports, err := storage.GetAllProblemReports()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	inScope := func(p *storage.ProblemReport) bool {
+		return scope == "all" || (u.StudyId != "" && p.StudyId == u.StudyId)
+	}
+	if id := c.Query("resolve"); id != "" {
+		for _, p := range reports {
+			if p.Fingerprint == id && inScope(p) {
+				if err := storage.ResolveProblemReport(id); err != nil {
+					c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+					return
+				}
+			}
+		}
+		msg := url.QueryEscape("Problem marked resolved.")
+		c.Redirect(http.StatusSeeOther, "./problems?"+filters+"&msg="+msg)
+		return
+	}
+	if id := c.Query("delete"); id != "" {
+		for _, p := range reports {
+			if p.Fingerprint == id && inScope(p) {
+				if err := storage.DeleteProblemReport(id); err != nil {
+					c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+					return
+				}
+			}
+		}
+		msg := url.QueryEscape("Problem deleted.")
+		c.Redirect(http.StatusSeeOther, "./problems?"+filters+"&msg="+msg)
+		return
+	}
+	slices.SortFunc(reports, func(a, b *storage.ProblemReport) int { return timeCompare(b.LastSeen, a.LastSeen, 0) })
+	studyNames := make(map[string]string)
+	problemList := make([]map[string]string, 0, len(reports))
+	for _, p := range reports {
+		if !inScope(p) {
+			continue
+		}
+		if kind != "" && p.Kind != kind {
+			continue
+		}
+		if (status == "open" && p.Resolved != 0) || (status == "resolved" && p.Resolved == 0) {
+			continue
+		}
+		if _, ok := studyNames[p.StudyId]; !ok && p.StudyId != "" {
+			if study, _ := storage.GetStudy(p.StudyId); study != nil {
+				studyNames[p.StudyId] = study.Name
+			}
+		}
+		problemList = append(problemList, map[string]string{
+			"Id":         p.Fingerprint,
+			"Kind":       p.Kind,
+			"Study":      studyNames[p.StudyId],
+			"Upn":        p.Upn,
+			"ClientType": p.ClientType,
+			"Message":    p.Message,
+			"Detail":     p.Detail,
+			"Count":      fmt.Sprintf("%d", p.Count),
+			"FirstSeen":  formatDateTime(p.FirstSeen),
+			"LastSeen":   formatDateTime(p.LastSeen),
+			"Resolved":   formatDateTime(p.Resolved),
+		})
+	}
+	c.HTML(http.StatusOK, "admin/problems.tmpl.html", gin.H{
+		"Problems":  problemList,
+		"Filters":   map[string]string{"Kind": kind, "Status": status, "Scope": scope},
+		"Developer": u.HasRole(storage.AdminRoleSuperAdmin),
+		"Message":   c.Query("msg"),
+	})
+}
+
+func GetLibrariesHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleParticipantManager) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	study, _ := storage.GetStudy(u.StudyId)
+	if study == nil {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	if id := c.Query("delete"); id != "" {
+		if err := storage.DeletePhraseLibrary(u.StudyId, id); err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+		msg := url.QueryEscape("Library deleted successfully.")
+		c.Redirect(http.StatusSeeOther, "./libraries?msg="+msg)
+		return
+	}
+	libraries, err := storage.GetAllPhraseLibraries(u.StudyId)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	slices.SortFunc(libraries, func(a, b *storage.PhraseLibrary) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	editId := c.Query("edit")
+	var lEdit map[string]string
+	lList := make([]map[string]string, 0, len(libraries))
+	for _, l := range libraries {
+		if editId == l.Id {
+			editId = ""
+			lEdit = map[string]string{"Id": l.Id, "Name": l.Name, "Phrases": strings.Join(l.Phrases, "\n")}
+		}
+		lList = append(lList, map[string]string{
+			"Id":      l.Id,
+			"Name":    l.Name,
+			"Count":   strconv.Itoa(len(l.Phrases)),
+			"Updated": formatDateTime(l.Updated),
+		})
+	}
+	if editId != "" {
+		c.Redirect(http.StatusSeeOther, "./libraries")
+		return
+	}
+	c.HTML(http.StatusOK, "admin/libraries.tmpl.html",
+		gin.H{"Study": study.Name, "Libraries": lList, "Edit": lEdit, "Message": c.Query("msg")})
+}
+
+func PostLibrariesHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleParticipantManager) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	phrases := storage.ParsePhrases(c.PostForm("phrases"))
+	if name == "" {
+		msg := url.QueryEscape("Library name cannot be blank.")
+		c.Redirect(http.StatusSeeOther, "./libraries?msg="+msg)
+		return
+	}
+	var l *storage.PhraseLibrary
+	msg := url.QueryEscape("Library added successfully.")
+	if c.PostForm("op") == "add" {
+		l = storage.NewPhraseLibrary(u.StudyId, name, phrases)
+	} else {
+		// op == edit
+		var err error
+		if l, err = storage.GetPhraseLibrary(u.StudyId, c.PostForm("id")); err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+		if l == nil {
+			msg = url.QueryEscape("Library not found.")
+			c.Redirect(http.StatusSeeOther, "./libraries?msg="+msg)
+			return
+		}
+		l.Name, l.Phrases = name, phrases
+		msg = url.QueryEscape("Library updated successfully.")
+	}
+	if err := storage.SavePhraseLibrary(l); err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "./libraries?msg="+msg)
+}
+
+func GetQuestionnairesHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleParticipantManager) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	study, _ := storage.GetStudy(u.StudyId)
+	if study == nil {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	if id := c.Query("delete"); id != "" {
+		if err := storage.DeleteQuestionnaire(u.StudyId, id); err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+		msg := url.QueryEscape("Questionnaire deleted successfully.")
+		c.Redirect(http.StatusSeeOther, "./questionnaires?msg="+msg)
+		return
+	}
+	questionnaires, err := storage.GetAllQuestionnaires(u.StudyId)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	editId := c.Query("edit")
+	var qEdit map[string]string
+	qList := make([]map[string]string, 0, len(questionnaires))
+	for _, q := range questionnaires {
+		active := ""
+		if q.Active {
+			active = "true"
+		}
+		if editId == q.Id {
+			editId = ""
+			qEdit = map[string]string{
+				"Id":       q.Id,
+				"Name":     q.Name,
+				"Schedule": q.Schedule,
+				"Items":    storage.FormatQuestionnaireItems(q.Items),
+				"Active":   active,
+			}
+		}
+		qList = append(qList, map[string]string{
+			"Id":       q.Id,
+			"Name":     q.Name,
+			"Schedule": q.Schedule,
+			"Count":    strconv.Itoa(len(q.Items)),
+			"Active":   active,
+			"Updated":  formatDateTime(q.Updated),
+		})
+	}
+	if editId != "" {
+		c.Redirect(http.StatusSeeOther, "./questionnaires")
+		return
+	}
+	c.HTML(http.StatusOK, "admin/questionnaires.tmpl.html",
+		gin.H{"Study": study.Name, "Questionnaires": qList, "Edit": qEdit, "Message": c.Query("msg")})
+}
+
+func PostQuestionnairesHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleParticipantManager) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		msg := url.QueryEscape("Questionnaire name cannot be blank.")
+		c.Redirect(http.StatusSeeOther, "./questionnaires?msg="+msg)
+		return
+	}
+	items, err := storage.ParseQuestionnaireItems(c.PostForm("items"))
+	if err != nil {
+		msg := url.QueryEscape(fmt.Sprintf("Couldn't save the questionnaire: %v.", err))
+		c.Redirect(http.StatusSeeOther, "./questionnaires?msg="+msg)
+		return
+	}
+	schedule := c.PostForm("schedule")
+	switch schedule {
+	case storage.QuestionnaireScheduleOnce, storage.QuestionnaireScheduleDaily, storage.QuestionnaireScheduleWeekly:
+	default:
+		// shouldn't happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	var q *storage.Questionnaire
+	msg := url.QueryEscape("Questionnaire added successfully.")
+	if c.PostForm("op") == "add" {
+		q = storage.NewQuestionnaire(u.StudyId, name, items, schedule)
+	} else {
+		// op == edit
+		if q, err = storage.GetQuestionnaire(u.StudyId, c.PostForm("id")); err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+		if q == nil {
+			msg = url.QueryEscape("Questionnaire not found.")
+			c.Redirect(http.StatusSeeOther, "./questionnaires?msg="+msg)
+			return
 		}
+		q.Name, q.Schedule = name, schedule
+		q.UpdateItems(items)
+		q.Active = c.PostForm("active") == "on"
+		msg = url.QueryEscape("Questionnaire updated successfully.")
 	}
-	target := "./participants?msg=" + msg
-	if editAgain {
-		target = fmt.Sprintf("./participants?edit=%s&msg=%s", upn, msg)
+	if err := storage.SaveQuestionnaire(q); err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
 	}
-	c.Redirect(http.StatusSeeOther, target)
+	c.Redirect(http.StatusSeeOther, "./questionnaires?msg="+msg)
 }
 
-func GetReportsHandler(c *gin.Context) {
+func GetMessagesHandler(c *gin.Context) {
 	u := getAuthenticatedUser(c)
-	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleResearcher) {
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleParticipantManager) {
 		// should never happen
 		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
@@ -525,159 +1331,216 @@ func GetReportsHandler(c *gin.Context) {
 		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	if generateId := c.Query("generate"); generateId != "" {
-		report, err := storage.GetStudyReport(u.StudyId, generateId)
-		if err != nil {
-			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
-			return
-		}
-		if report == nil {
-			// shouldn't happen
-			msg := url.QueryEscape("Report not found.")
-			c.Redirect(http.StatusSeeOther, "./reports?msg="+msg)
-			return
-		}
-		if err = report.Generate(); err != nil {
+	if id := c.Query("delete"); id != "" {
+		if err := storage.DeleteStudyMessage(u.StudyId, id); err != nil {
 			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 			return
 		}
-		msg := url.QueryEscape("Report generated successfully.")
-		c.Redirect(http.StatusSeeOther, "./reports?msg="+msg)
+		msg := url.QueryEscape("Message deleted successfully.")
+		c.Redirect(http.StatusSeeOther, "./messages?msg="+msg)
+		return
 	}
-	message := c.Query("msg")
-	deleteId := c.Query("delete")
-	// create the list of existing reports
-	reports, err := storage.FetchAllStudyReports(u.StudyId)
+	messages, err := storage.GetAllStudyMessages(u.StudyId)
 	if err != nil {
 		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	slices.SortFunc(reports, CompareReportsFunc(c.Query("sort")))
-	reportList := make([]map[string]string, 0, len(reports))
-	for _, r := range reports {
-		if r.ReportId == deleteId || r.Generated == 0 {
-			if err := r.Delete(); err != nil {
-				c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
-				return
+	viewId := c.Query("view")
+	var mView map[string]string
+	var receipts []map[string]string
+	mList := make([]map[string]string, 0, len(messages))
+	for _, m := range messages {
+		rs, err := m.GetMessageReceipts()
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+		delivered, read := 0, 0
+		for _, r := range rs {
+			if r.Delivered != 0 {
+				delivered++
+			}
+			if r.Read != 0 {
+				read++
 			}
-			continue
 		}
-		var restricted string
-		if len(r.Upns) > 0 {
-			restricted = "Yes"
+		if viewId == m.Id {
+			viewId = ""
+			mView = map[string]string{"Id": m.Id, "Title": m.Title, "Body": m.Body}
+			for _, r := range rs {
+				receipts = append(receipts, map[string]string{
+					"Upn":       r.Upn,
+					"Delivered": formatDateTime(r.Delivered),
+					"Read":      formatDateTime(r.Read),
+				})
+			}
 		}
-		reportList = append(reportList, map[string]string{
-			"Id":        r.ReportId,
-			"Name":      r.Name,
-			"Type":      r.Type,
-			"Start":     formatDate(r.Start),
-			"End":       formatDate(r.End),
-			"Upns":      restricted,
-			"Generated": formatDateTime(r.Generated),
-			"Filename":  r.Filename,
+		mList = append(mList, map[string]string{
+			"Id":         m.Id,
+			"Title":      m.Title,
+			"Author":     m.Author,
+			"Sent":       formatDateTime(m.Sent),
+			"Recipients": strconv.Itoa(len(rs)),
+			"Delivered":  strconv.Itoa(delivered),
+			"Read":       strconv.Itoa(read),
 		})
 	}
-	// create select for report generation
+	if viewId != "" {
+		c.Redirect(http.StatusSeeOther, "./messages")
+		return
+	}
 	participants, err := storage.GetAllStudyParticipants(u.StudyId)
 	if err != nil {
 		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	slices.SortFunc(participants, CompareParticipantsFunc(""))
-	upns := make([]string, 0, len(participants))
+	var upns []string
 	for _, p := range participants {
-		if p.Started == 0 {
-			continue
+		if p.ProfileId != "" && p.Started > 0 && p.Finished == 0 {
+			upns = append(upns, p.Upn)
 		}
-		upns = append(upns, p.Upn)
 	}
-	c.HTML(http.StatusOK, "admin/reports.tmpl.html",
-		gin.H{"Study": study.Name, "Upns": upns, "Message": message, "Reports": reportList})
+	slices.Sort(upns)
+	c.HTML(http.StatusOK, "admin/messages.tmpl.html", gin.H{
+		"Study":    study.Name,
+		"Messages": mList,
+		"View":     mView,
+		"Receipts": receipts,
+		"Upns":     upns,
+		"Message":  c.Query("msg"),
+	})
 }
 
-func PostReportsHandler(c *gin.Context) {
+func PostMessagesHandler(c *gin.Context) {
 	u := getAuthenticatedUser(c)
-	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleResearcher) {
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleParticipantManager) {
 		// should never happen
 		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	study, err := storage.GetStudy(u.StudyId)
-	if err != nil || study == nil {
-		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+	title := strings.TrimSpace(c.PostForm("title"))
+	body := strings.ReplaceAll(strings.TrimSpace(c.PostForm("body")), "\r\n", "\n")
+	if title == "" || body == "" {
+		msg := url.QueryEscape("The message title and body cannot be blank.")
+		c.Redirect(http.StatusSeeOther, "./messages?msg="+msg)
 		return
 	}
-	op := c.PostForm("op")
-	name := strings.TrimSpace(c.PostForm("name"))
-	if name == "" {
+	target := c.PostForm("target")
+	switch target {
+	case storage.MessageTargetAll, storage.MessageTargetInactive, storage.MessageTargetSelected:
+	default:
 		// shouldn't happen
-		message := url.QueryEscape("The report name must not be empty.")
-		c.Redirect(http.StatusSeeOther, "./reports?msg="+message)
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	// create the report object
-	var r *storage.StudyReport
-	if op == storage.ReportTypeLines {
-		startString, endString := c.PostForm("start"), c.PostForm("end")
-		start, end, err := storage.ComputeReportDates(startString, endString, "2006-01-02")
-		if err != nil {
-			// shouldn't happen
-			middleware.CtxLog(c).Info("Invalid date in a posted report request",
-				zap.String("start", startString), zap.String("end", endString), zap.Error(err))
-			message := url.QueryEscape("Invalid start or end date.")
-			c.Redirect(http.StatusSeeOther, "./reports?msg="+message)
-			return
-		}
-		upns := c.PostFormArray("upns")
-		r = storage.NewStudyReport(study.Id, name, storage.ReportTypeLines, start, end, upns)
-	} else if op == storage.ReportTypePhrases {
-		r = storage.NewStudyReport(study.Id, name, storage.ReportTypePhrases, 0, 0, nil)
-	} else {
-		// shouldn't happen
+	recipients, err := storage.SelectMessageRecipients(u.StudyId, target, c.PostFormArray("upns"))
+	if errors.Is(err, storage.NoMessageRecipientsError) {
+		msg := url.QueryEscape("No enrolled participants match those recipients.")
+		c.Redirect(http.StatusSeeOther, "./messages?msg="+msg)
+		return
+	}
+	if err != nil {
 		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	if err := r.Generate(); err != nil {
+	if _, err := storage.SendStudyMessage(u.StudyId, u.Email, title, body, recipients); err != nil {
 		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	msg := url.QueryEscape("Report generated successfully.")
-	c.Redirect(http.StatusSeeOther, "./reports?msg="+msg)
+	msg := url.QueryEscape(fmt.Sprintf("Message sent to %d participants.", len(recipients)))
+	c.Redirect(http.StatusSeeOther, "./messages?msg="+msg)
 }
 
-func DownloadReportHandler(c *gin.Context) {
+func GetConsentHandler(c *gin.Context) {
 	u := getAuthenticatedUser(c)
-	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleResearcher) {
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleParticipantManager) {
 		// should never happen
 		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	report, err := storage.GetStudyReport(u.StudyId, c.Param("reportId"))
-	if err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+	study, _ := storage.GetStudy(u.StudyId)
+	if study == nil {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	if report == nil {
-		c.AbortWithStatus(http.StatusNotFound)
+	documents, err := storage.GetAllConsentDocuments(u.StudyId)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	data, err := report.Retrieve()
+	participants, err := storage.GetAllStudyParticipants(u.StudyId)
 	if err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
 		return
 	}
-	defer data.Close()
-	//goland:noinspection SpellCheckingInspection
-	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Status(http.StatusOK)
-	c.Stream(func(w io.Writer) bool {
-		if _, err := io.Copy(w, data); err != nil {
-			return true
+	accepted := make(map[int64]int)
+	for _, p := range participants {
+		if p.ConsentVersion > 0 && p.Started > 0 && p.Finished == 0 {
+			accepted[p.ConsentVersion]++
 		}
-		return false
+	}
+	viewId := c.Query("view")
+	var dView map[string]string
+	dList := make([]map[string]string, 0, len(documents))
+	for _, d := range documents {
+		version := strconv.FormatInt(d.Version, 10)
+		if viewId == version {
+			viewId = ""
+			dView = map[string]string{"Version": version, "Title": d.Title, "Text": d.Text}
+		}
+		dList = append(dList, map[string]string{
+			"Version":   version,
+			"Title":     d.Title,
+			"Author":    d.Author,
+			"Published": formatDateTime(d.Published),
+			"Accepted":  strconv.Itoa(accepted[d.Version]),
+		})
+	}
+	if viewId != "" {
+		c.Redirect(http.StatusSeeOther, "./consent")
+		return
+	}
+	// new versions start as a copy of the current one
+	var current map[string]string
+	if len(documents) > 0 {
+		current = map[string]string{"Title": documents[0].Title, "Text": documents[0].Text}
+	}
+	c.HTML(http.StatusOK, "admin/consent.tmpl.html", gin.H{
+		"Study":     study.Name,
+		"Documents": dList,
+		"View":      dView,
+		"Current":   current,
+		"Message":   c.Query("msg"),
 	})
 }
 
+func PostConsentHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || u.StudyId == "" || !u.HasRole(storage.AdminRoleParticipantManager) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	title := strings.TrimSpace(c.PostForm("title"))
+	text := strings.ReplaceAll(strings.TrimSpace(c.PostForm("text")), "\r\n", "\n")
+	if title == "" || text == "" {
+		msg := url.QueryEscape("The consent title and text cannot be blank.")
+		c.Redirect(http.StatusSeeOther, "./consent?msg="+msg)
+		return
+	}
+	d, err := storage.PublishConsentDocument(u.StudyId, u.Email, title, text)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	msg := fmt.Sprintf("Consent version %d published.", d.Version)
+	if d.Version > 1 {
+		msg += " Enrolled participants will be asked to accept it."
+	}
+	c.Redirect(http.StatusSeeOther, "./consent?msg="+url.QueryEscape(msg))
+}
+
 func GetAdminsHandler(c *gin.Context) {
 	u := getAuthenticatedUser(c)
 	if u == nil || !u.HasRole(storage.AdminRoleSuperAdmin) {
@@ -872,7 +1735,7 @@ func PostStudiesHandler(c *gin.Context) {
 		s.Name = name
 		s.AdminEmail = email
 	} else {
-		s = &storage.Study{Id: uuid.NewString(), Name: name, AdminEmail: email, Active: true}
+		s = storage.NewStudy(name, email)
 	}
 	if err := storage.EnsureStudyAdminUser(s.Id, email); err != nil {
 		msg := url.QueryEscape(fmt.Sprintf("Cannot use %s as the admin for this study.", email))
@@ -892,6 +1755,229 @@ func PostStudiesHandler(c *gin.Context) {
 	c.Redirect(http.StatusSeeOther, "./studies?msg="+msg)
 }
 
+func GetVersionsHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || !u.HasRole(storage.AdminRoleSuperAdmin) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	if deleteType := c.Query("delete"); deleteType != "" {
+		if err := storage.DeleteClientVersionPolicy(deleteType); err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+		msg := url.QueryEscape(fmt.Sprintf("Version policy for %s deleted.", deleteType))
+		c.Redirect(http.StatusSeeOther, "./versions?msg="+msg)
+		return
+	}
+	policies, err := storage.GetAllClientVersionPolicies()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	counts, err := storage.GetClientVersionDistribution()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	editType := c.Query("edit")
+	var editPolicy map[string]string
+	policyList := make([]map[string]string, 0, len(policies))
+	for _, p := range policies {
+		policyMap := map[string]string{
+			"ClientType":  p.ClientType,
+			"MinVersion":  p.MinVersion,
+			"WarnVersion": p.WarnVersion,
+			"Author":      p.Author,
+			"Updated":     formatDateTime(p.Updated),
+		}
+		if editType == p.ClientType {
+			editPolicy = policyMap
+		}
+		policyList = append(policyList, policyMap)
+	}
+	countList := make([]map[string]string, 0, len(counts))
+	for _, count := range counts {
+		status := count.Status
+		if status == storage.ClientVersionUnpoliced {
+			status = "no policy"
+		}
+		countList = append(countList, map[string]string{
+			"ClientType": count.ClientType,
+			"AppVersion": count.AppVersion,
+			"Devices":    fmt.Sprintf("%d", count.Devices),
+			"Status":     status,
+		})
+	}
+	c.HTML(http.StatusOK, "admin/versions.tmpl.html", gin.H{
+		"Policies": policyList,
+		"Counts":   countList,
+		"Edit":     editPolicy,
+		"Message":  c.Query("msg"),
+	})
+}
+
+func PostVersionsHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || !u.HasRole(storage.AdminRoleSuperAdmin) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	clientType := strings.TrimSpace(c.PostForm("clientType"))
+	minVersion := strings.TrimSpace(c.PostForm("minVersion"))
+	warnVersion := strings.TrimSpace(c.PostForm("warnVersion"))
+	if clientType == "" {
+		msg := url.QueryEscape("You must specify a client type.")
+		c.Redirect(http.StatusSeeOther, "./versions?msg="+msg)
+		return
+	}
+	if minVersion == "" && warnVersion == "" {
+		msg := url.QueryEscape("You must specify a minimum or a recommended version.")
+		c.Redirect(http.StatusSeeOther, "./versions?msg="+msg)
+		return
+	}
+	if minVersion != "" && warnVersion != "" && platform.CompareVersions(warnVersion, minVersion) < 0 {
+		msg := url.QueryEscape("The recommended version can't be lower than the minimum version.")
+		c.Redirect(http.StatusSeeOther, "./versions?msg="+msg)
+		return
+	}
+	_, err := storage.SaveClientVersionPolicy(clientType, minVersion, warnVersion, u.Email)
+	if errors.Is(err, storage.InvalidClientVersionError) {
+		msg := url.QueryEscape("Versions must be numbers separated by periods, such as 1.2.3.")
+		c.Redirect(http.StatusSeeOther, "./versions?msg="+msg)
+		return
+	} else if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	msg := url.QueryEscape(fmt.Sprintf("Version policy for %s saved.", clientType))
+	c.Redirect(http.StatusSeeOther, "./versions?msg="+msg)
+}
+
+func GetFlagsHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || !u.HasRole(storage.AdminRoleSuperAdmin) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	if deleteName := c.Query("delete"); deleteName != "" {
+		if err := storage.DeleteFeatureFlag(deleteName); err != nil {
+			c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+			return
+		}
+		msg := url.QueryEscape(fmt.Sprintf("Flag %s deleted.", deleteName))
+		c.Redirect(http.StatusSeeOther, "./flags?msg="+msg)
+		return
+	}
+	flags, err := storage.GetAllFeatureFlags()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	studies, err := storage.GetAllStudies()
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	editName := c.Query("edit")
+	var editFlag map[string]string
+	flagList := make([]map[string]string, 0, len(flags))
+	for _, f := range flags {
+		rollout := "None"
+		if f.RolloutPercent > 0 {
+			rollout = fmt.Sprintf("%s for %d%%", f.RolloutValue, f.RolloutPercent)
+		}
+		flagMap := map[string]string{
+			"Name":           f.Name,
+			"Type":           f.Type,
+			"Description":    f.Description,
+			"Default":        f.Default,
+			"Overrides":      storage.FormatFlagOverrides(f.Overrides),
+			"Rollout":        rollout,
+			"RolloutPercent": strconv.Itoa(f.RolloutPercent),
+			"RolloutValue":   f.RolloutValue,
+			"Author":         f.Author,
+			"Updated":        formatDateTime(f.Updated),
+		}
+		if editName == f.Name {
+			editFlag = flagMap
+		}
+		flagList = append(flagList, flagMap)
+	}
+	slices.SortFunc(studies, func(a, b *storage.Study) int { return strings.Compare(a.Name, b.Name) })
+	studyList := make([]map[string]string, 0, len(studies))
+	for _, s := range studies {
+		studyList = append(studyList, map[string]string{"Id": s.Id, "Name": s.Name})
+	}
+	c.HTML(http.StatusOK, "admin/flags.tmpl.html", gin.H{
+		"Flags":   flagList,
+		"Edit":    editFlag,
+		"Types":   storage.FlagTypes,
+		"Studies": studyList,
+		"Message": c.Query("msg"),
+	})
+}
+
+func PostFlagsHandler(c *gin.Context) {
+	u := getAuthenticatedUser(c)
+	if u == nil || !u.HasRole(storage.AdminRoleSuperAdmin) {
+		// should never happen
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	target := "./flags?"
+	if c.PostForm("op") == "edit" {
+		target = fmt.Sprintf("./flags?edit=%s&", url.QueryEscape(name))
+	} else if existing, err := storage.GetFeatureFlag(name); err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	} else if existing != nil {
+		msg := url.QueryEscape(fmt.Sprintf("There is already a flag named %s.", name))
+		c.Redirect(http.StatusSeeOther, target+"msg="+msg)
+		return
+	}
+	overrides, err := storage.ParseFlagOverrides(c.PostForm("overrides"))
+	if err != nil {
+		msg := url.QueryEscape(fmt.Sprintf("Overrides must be one per line, like \"platform Tablet = true\": %v.", err))
+		c.Redirect(http.StatusSeeOther, target+"msg="+msg)
+		return
+	}
+	percent := 0
+	if p := strings.TrimSpace(c.PostForm("rolloutPercent")); p != "" {
+		if percent, err = strconv.Atoi(p); err != nil {
+			percent = -1
+		}
+	}
+	f := &storage.FeatureFlag{
+		Name:           name,
+		Type:           c.PostForm("type"),
+		Description:    strings.TrimSpace(c.PostForm("description")),
+		Default:        strings.TrimSpace(c.PostForm("default")),
+		Overrides:      overrides,
+		RolloutPercent: percent,
+		RolloutValue:   strings.TrimSpace(c.PostForm("rolloutValue")),
+	}
+	if err := storage.SaveFeatureFlag(f, u.Email); errors.Is(err, storage.InvalidFlagNameError) {
+		msg := url.QueryEscape("Flag names must start with a letter and contain only letters, digits, periods, dashes, and underscores.")
+		c.Redirect(http.StatusSeeOther, target+"msg="+msg)
+		return
+	} else if errors.Is(err, storage.InvalidFlagTypeError) || errors.Is(err, storage.InvalidFlagValueError) ||
+		errors.Is(err, storage.InvalidFlagOverrideError) || errors.Is(err, storage.InvalidFlagRolloutError) {
+		msg := url.QueryEscape(fmt.Sprintf("Invalid flag: %v.", err))
+		c.Redirect(http.StatusSeeOther, target+"msg="+msg)
+		return
+	} else if err != nil {
+		c.HTML(http.StatusInternalServerError, "admin/error.tmpl.html", gin.H{"logout": "./logout"})
+		return
+	}
+	msg := url.QueryEscape(fmt.Sprintf("Flag %s saved.", name))
+	c.Redirect(http.StatusSeeOther, "./flags?msg="+msg)
+}
+
 func getAuthenticatedUser(c *gin.Context) *storage.AdminUser {
 	val, ok := c.Get("authenticatedUser")
 	if !ok || val == nil {
@@ -935,6 +2021,11 @@ func CompareParticipantsFunc(sort string) func(a, b *storage.StudyParticipant) i
 			return timeCompare(a.Assigned, b.Assigned, upnCompare)
 		case "configured":
 			return configuredCompare(a, b)
+		case "arm":
+			if armCompare := strings.Compare(a.Arm, b.Arm); armCompare != 0 {
+				return armCompare
+			}
+			return upnCompare
 		case "start":
 			return timeCompare(a.Started, b.Started, upnCompare)
 		case "end":
@@ -981,8 +2072,10 @@ func CompareReportsFunc(sort string) func(a, b *storage.StudyReport) int {
 	}
 }
 
-func MakeParticipantMap(p *storage.StudyParticipant) map[string]string {
-	pMap := map[string]string{"UPN": p.Upn}
+// MakeParticipantMap formats a participant for listing. The consentVersion is the
+// current version of the study's consent document, or 0 if the study doesn't have one.
+func MakeParticipantMap(p *storage.StudyParticipant, consentVersion int64) map[string]string {
+	pMap := map[string]string{"UPN": p.Upn, "Arm": p.Arm}
 	if p.Assigned > 0 {
 		memo := p.Memo
 		if len(memo) > 20 {
@@ -1005,6 +2098,19 @@ func MakeParticipantMap(p *storage.StudyParticipant) map[string]string {
 	if p.Finished > 0 {
 		pMap["Finished"] = formatDate(p.Finished)
 	}
+	if p.Withdrawn > 0 {
+		pMap["Finished"] = formatDate(p.Withdrawn) + " (withdrawn)"
+	}
+	switch {
+	case consentVersion == 0:
+		pMap["Consent"] = "N/A"
+	case p.ConsentVersion == 0:
+		pMap["Consent"] = "None"
+	case p.ConsentVersion == consentVersion:
+		pMap["Consent"] = fmt.Sprintf("v%d", p.ConsentVersion)
+	default:
+		pMap["Consent"] = fmt.Sprintf("v%d (outdated)", p.ConsentVersion)
+	}
 	return pMap
 }
 
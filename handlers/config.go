@@ -0,0 +1,45 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/whisper-project/in-my-voice.server.golang/middleware"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+	"go.uber.org/zap"
+)
+
+// ConfigGetHandler returns the values of all the feature flags for the client.
+func ConfigGetHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	config, etag, err := storage.GetClientConfig(profileId, clientId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	// make sure any update annotation has been removed
+	c.Header("X-Config-Update", "")
+	_ = storage.ProfileClientConfigWasNotified(profileId, clientId, etag)
+	if etag != "" {
+		c.Header("ETag", quoteETag(etag))
+		if etags := parseETags(c.GetHeader("If-None-Match")); slices.Contains(etags, etag) {
+			middleware.CtxLog(c).Info("config not modified",
+				zap.String("clientId", clientId), zap.String("profileId", profileId))
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	middleware.CtxLog(c).Info("successful config retrieval", zap.Int("flags", len(config)),
+		zap.String("clientId", clientId), zap.String("profileId", profileId))
+	c.JSON(http.StatusOK, config)
+}
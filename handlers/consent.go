@@ -0,0 +1,128 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/whisper-project/in-my-voice.server.golang/middleware"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+	"go.uber.org/zap"
+)
+
+func consentDocumentJSON(d *storage.ConsentDocument) gin.H {
+	return gin.H{"version": d.Version, "title": d.Title, "text": d.Text, "published": d.Published}
+}
+
+// JoinStudyConsentHandler returns the consent document a client must display
+// (and get accepted) before joining the given study.
+func JoinStudyConsentHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	studyId := c.Query("studyId")
+	study, err := storage.GetStudy(studyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if study == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "study ID invalid or not available"})
+		return
+	}
+	d, err := storage.GetCurrentConsentDocument(studyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if d == nil {
+		// no consent is needed to join this study
+		c.Status(http.StatusNoContent)
+		return
+	}
+	middleware.CtxLog(c).Info("join study consent retrieval",
+		zap.String("clientId", clientId), zap.String("profileId", profileId),
+		zap.String("studyId", studyId), zap.Int64("version", d.Version))
+	c.JSON(http.StatusOK, consentDocumentJSON(d))
+}
+
+// ConsentGetHandler returns the current consent document for the participant's study,
+// along with the version the participant last accepted.
+func ConsentGetHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	studyId, upn, err := storage.GetProfileStudyMembership(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if studyId == "" || upn == "" {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	d, err := storage.GetCurrentConsentDocument(studyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if d == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	p, err := storage.GetStudyParticipant(studyId, upn)
+	if err != nil || p == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	middleware.CtxLog(c).Info("consent retrieval",
+		zap.String("clientId", clientId), zap.String("profileId", profileId),
+		zap.String("studyId", studyId), zap.Int64("version", d.Version))
+	result := consentDocumentJSON(d)
+	result["acceptedVersion"] = p.ConsentVersion
+	c.JSON(http.StatusOK, result)
+}
+
+// ConsentPostHandler records the participant's acceptance of the current consent document.
+func ConsentPostHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	studyId, upn, err := storage.GetProfileStudyMembership(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if studyId == "" || upn == "" {
+		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "not enrolled in a study"})
+		return
+	}
+	var body struct {
+		Version int64 `json:"version"`
+	}
+	if err := c.ShouldBind(&body); err != nil || body.Version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "invalid request body"})
+		return
+	}
+	err = storage.RecordParticipantConsent(studyId, upn, profileId, clientId, body.Version)
+	if errors.Is(err, storage.ConsentVersionNotCurrentError) {
+		c.JSON(http.StatusConflict, gin.H{"status": "error", "error": "consent version is not current"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	middleware.CtxLog(c).Info("consent accepted",
+		zap.String("clientId", clientId), zap.String("profileId", profileId),
+		zap.String("studyId", studyId), zap.Int64("version", body.Version))
+	c.Status(http.StatusNoContent)
+}
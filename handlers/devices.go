@@ -0,0 +1,71 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/whisper-project/in-my-voice.server.golang/middleware"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+	"go.uber.org/zap"
+)
+
+func DevicesGetHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	devices, err := storage.GetProfileDevices(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	results := make([]gin.H, 0, len(devices))
+	for _, d := range devices {
+		results = append(results, gin.H{
+			"clientId":   d.ClientId,
+			"clientType": d.ClientType,
+			"platform":   d.Platform,
+			"appVersion": d.AppVersion,
+			"firstSeen":  d.FirstSeen,
+			"lastSeen":   d.LastSeen,
+			"revoked":    d.Revoked,
+			"current":    d.ClientId == clientId,
+		})
+	}
+	middleware.CtxLog(c).Info("devices retrieval", zap.Int("count", len(results)),
+		zap.String("clientId", clientId), zap.String("profileId", profileId))
+	c.JSON(http.StatusOK, results)
+}
+
+func DeviceRevokeHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	target := c.Param("clientId")
+	if target == clientId {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "can't revoke the requesting device"})
+		return
+	}
+	err := storage.RevokeDevice(profileId, target)
+	if errors.Is(err, storage.DeviceNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "no such device"})
+		return
+	} else if errors.Is(err, storage.DeviceAlreadyRevokedError) {
+		c.JSON(http.StatusConflict, gin.H{"status": "error", "error": "device already revoked"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	middleware.CtxLog(c).Info("device revoked by client",
+		zap.String("clientId", clientId), zap.String("profileId", profileId), zap.String("revoked", target))
+	c.Status(http.StatusNoContent)
+}
@@ -8,12 +8,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/whisper-project/in-my-voice.server.golang/middleware"
 	"github.com/whisper-project/in-my-voice.server.golang/storage"
 	"go.uber.org/zap"
 	"io"
 	"net/http"
+	"slices"
+	"strings"
 )
 
 func FavoritesGetHandler(c *gin.Context) {
@@ -29,6 +32,13 @@ func FavoritesGetHandler(c *gin.Context) {
 	// make sure any update annotation has been removed
 	c.Header("X-Favorites-Update", "")
 	if f != nil {
+		c.Header("ETag", quoteETag(f.ETag))
+		if etags := parseETags(c.GetHeader("If-None-Match")); slices.Contains(etags, "*") || slices.Contains(etags, f.ETag) {
+			middleware.CtxLog(c).Info("favorites not modified",
+				zap.String("clientId", clientId), zap.String("profileId", profileId))
+			c.Status(http.StatusNotModified)
+			return
+		}
 		middleware.CtxLog(c).Info("successful favorites retrieval",
 			zap.String("clientId", clientId), zap.String("profileId", profileId))
 		c.JSON(http.StatusOK, json.RawMessage(f.Settings))
@@ -52,7 +62,20 @@ func FavoritesPutHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "failed to read the request body"})
 		return
 	}
-	changed, err := storage.UpdateFavoritesSettings(profileId, string(body))
+	ifMatch := parseETags(c.GetHeader("If-Match"))
+	changed, f, err := storage.UpdateMatchingFavoritesSettings(profileId, clientId, string(body), ifMatch)
+	if errors.Is(err, storage.FavoritesConflictError) {
+		middleware.CtxLog(c).Info("favorites update conflict",
+			zap.String("clientId", clientId), zap.String("profileId", profileId),
+			zap.String("If-Match", c.GetHeader("If-Match")))
+		if f == nil {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"status": "error", "error": "no favorites are stored"})
+			return
+		}
+		c.Header("ETag", quoteETag(f.ETag))
+		c.JSON(http.StatusPreconditionFailed, json.RawMessage(f.Settings))
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
 		return
@@ -62,5 +85,131 @@ func FavoritesPutHandler(c *gin.Context) {
 			_ = storage.ProfileClientFavoritesDidUpdate(profileId, clientId)
 		}()
 	}
+	c.Header("ETag", quoteETag(f.ETag))
 	c.Status(http.StatusNoContent)
 }
+
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+// parseETags unquotes the ETags in an If-Match or If-None-Match header value,
+// which is either "*" or a comma-separated list of (possibly weak) ETags.
+func parseETags(header string) []string {
+	var etags []string
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), `"`)
+		if etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return etags
+}
+
+func FavoritesHistoryHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	versions, err := storage.GetFavoritesHistory(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	results := make([]gin.H, 0, len(versions))
+	for i, v := range versions {
+		results = append(results, gin.H{"etag": v.ETag, "clientId": v.ClientId, "saved": v.Saved, "current": i == 0})
+	}
+	middleware.CtxLog(c).Info("favorites history retrieval", zap.Int("count", len(results)),
+		zap.String("clientId", clientId), zap.String("profileId", profileId))
+	c.JSON(http.StatusOK, results)
+}
+
+func FavoritesVersionGetHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	etag := c.Param("etag")
+	versions, err := storage.GetFavoritesHistory(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	for _, v := range versions {
+		if v.ETag == etag {
+			middleware.CtxLog(c).Info("favorites version retrieval", zap.String("etag", etag),
+				zap.String("clientId", clientId), zap.String("profileId", profileId))
+			c.Header("ETag", quoteETag(v.ETag))
+			c.JSON(http.StatusOK, json.RawMessage(v.Settings))
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "no such favorites version"})
+}
+
+func FavoritesRestoreHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	// make sure any update annotation has been removed
+	c.Header("X-Favorites-Update", "")
+	f, changed, err := storage.RestoreFavoritesVersion(profileId, clientId, c.Param("etag"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if f == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "no such favorites version"})
+		return
+	}
+	if changed {
+		defer func() {
+			_ = storage.ProfileClientFavoritesDidUpdate(profileId, clientId)
+		}()
+	}
+	c.Header("ETag", quoteETag(f.ETag))
+	c.JSON(http.StatusOK, json.RawMessage(f.Settings))
+}
+
+func PhraseLibraryHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	studyId, _, err := storage.GetProfileStudyMembership(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if studyId == "" {
+		middleware.CtxLog(c).Info("no phrase library for non-study profile",
+			zap.String("clientId", clientId), zap.String("profileId", profileId))
+		c.Status(http.StatusNoContent)
+		return
+	}
+	libraries, err := storage.GetAllPhraseLibraries(studyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	slices.SortFunc(libraries, func(a, b *storage.PhraseLibrary) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	etag := storage.PhraseLibrariesETag(libraries)
+	c.Header("ETag", quoteETag(etag))
+	if etags := parseETags(c.GetHeader("If-None-Match")); slices.Contains(etags, "*") || slices.Contains(etags, etag) {
+		middleware.CtxLog(c).Info("phrase library not modified",
+			zap.String("clientId", clientId), zap.String("profileId", profileId))
+		c.Status(http.StatusNotModified)
+		return
+	}
+	results := make([]gin.H, 0, len(libraries))
+	for _, l := range libraries {
+		results = append(results, gin.H{"id": l.Id, "name": l.Name, "phrases": l.Phrases, "updated": l.Updated})
+	}
+	middleware.CtxLog(c).Info("phrase library retrieval", zap.Int("count", len(results)),
+		zap.String("clientId", clientId), zap.String("profileId", profileId), zap.String("studyId", studyId))
+	c.JSON(http.StatusOK, results)
+}
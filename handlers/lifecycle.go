@@ -7,6 +7,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 
 	"go.uber.org/zap"
@@ -25,6 +26,12 @@ func AnomalyHandler(c *gin.Context) {
 	middleware.CtxLog(c).Info("Anomaly reported",
 		zap.String("clientId", clientId), zap.String("clientType", clientType),
 		zap.String("profileId", profileId), zap.String("message", message))
+	// this endpoint isn't authenticated, so only store reports from plausible clients
+	if uuid.Validate(clientId) != nil || uuid.Validate(profileId) != nil {
+		c.AbortWithStatusJSON(400, gin.H{"status": "error", "error": "invalid client or profile id"})
+		return
+	}
+	_, _ = storage.RecordProblemReport(storage.ProblemKindAnomaly, clientType, clientId, profileId, message, "")
 	c.Status(http.StatusNoContent)
 }
 
@@ -58,6 +65,17 @@ func LaunchHandler(c *gin.Context) {
 	// because clients update everything at launch
 	c.Header("X-Speech-Settings-Update", "")
 	c.Header("X-Favorites-Update", "")
+	// clients keep their configuration across launches, so they are told the ETag of the
+	// current one, and are left with any update annotation, to know whether to fetch it
+	if etag, err := storage.GetClientConfigETag(profileId, clientId); err == nil && etag != "" {
+		c.Header("X-Config-ETag", quoteETag(etag))
+	}
+	// clients that should upgrade are prompted at launch, unless there's a message already
+	if version := c.Writer.Header().Get("X-Upgrade-Recommended"); version != "" && c.Writer.Header().Get("X-Message") == "" {
+		c.Header("X-Message", fmt.Sprintf("**A new version of the app is available.**\n"+
+			"Please update to version %s or later soon.", version))
+	}
+	deliverProfileMessage(c, profileId)
 	middleware.CtxLog(c).Info("Launch received",
 		zap.String("clientType", clientType), zap.String("clientId", clientId),
 		zap.String("profileId", profileId))
@@ -70,7 +88,8 @@ func ForegroundHandler(c *gin.Context) {
 	if !ok {
 		return
 	}
-	storage.ObserveClientActive(clientId, profileId)
+	storage.ObserveClientActive(clientId, profileId, storage.SessionEventForeground)
+	deliverProfileMessage(c, profileId)
 	middleware.CtxLog(c).Info("Foreground received",
 		zap.String("clientId", clientId), zap.String("profileId", profileId))
 	c.Status(http.StatusNoContent)
@@ -81,7 +100,7 @@ func BackgroundHandler(c *gin.Context) {
 	if !ok {
 		return
 	}
-	storage.ObserveClientActive(clientId, profileId)
+	storage.ObserveClientActive(clientId, profileId, storage.SessionEventBackground)
 	middleware.CtxLog(c).Info("Background received",
 		zap.String("clientId", clientId), zap.String("profileId", profileId))
 	c.Status(http.StatusNoContent)
@@ -108,10 +127,52 @@ func ValidateRequest(c *gin.Context) (clientId, profileId string, ok bool) {
 		c.AbortWithStatusJSON(400, gin.H{"status": "error", "error": "invalid client or profile id"})
 		return "", "", false
 	}
+	d := storage.ObserveDevice(clientId, profileId, c.GetHeader("X-Client-Type"),
+		storage.PlatformName(c.GetHeader("X-Platform-Info")), c.GetHeader("X-Client-Version"))
+	if d == nil {
+		c.AbortWithStatusJSON(500, gin.H{"status": "error", "error": "database failure"})
+		return "", "", false
+	} else if d.Revoked > 0 {
+		middleware.CtxLog(c).Info("Revoked client rejected",
+			zap.String("clientId", clientId), zap.String("profileId", profileId))
+		c.Header("X-Message", "**This device has been signed out.**\nPlease reinstall the app to use it again.")
+		c.AbortWithStatusJSON(401, gin.H{"status": "error", "error": "client has been revoked"})
+		return "", "", false
+	}
+	if !checkClientVersion(c, d) {
+		return "", "", false
+	}
 	AnnotateResponse(c, clientId, profileId)
 	return clientId, profileId, true
 }
 
+// checkClientVersion rejects clients below the minimum version for their type,
+// and marks the response of clients below the recommended version.
+func checkClientVersion(c *gin.Context, d *storage.Device) bool {
+	if d == nil || d.ClientType == "" {
+		return true
+	}
+	policy, err := storage.GetClientVersionPolicy(d.ClientType)
+	if err != nil {
+		c.AbortWithStatusJSON(500, gin.H{"status": "error", "error": "database failure"})
+		return false
+	}
+	switch policy.Status(d.AppVersion) {
+	case storage.ClientVersionRetired:
+		middleware.CtxLog(c).Info("Retired client version rejected",
+			zap.String("clientId", d.ClientId), zap.String("clientType", d.ClientType),
+			zap.String("appVersion", d.AppVersion), zap.String("minVersion", policy.MinVersion))
+		c.Header("X-Message", fmt.Sprintf("**This version of the app is no longer supported.**\n"+
+			"Please update to version %s or later.", policy.MinVersion))
+		c.AbortWithStatusJSON(http.StatusUpgradeRequired,
+			gin.H{"status": "error", "error": "client version no longer supported"})
+		return false
+	case storage.ClientVersionOutdated:
+		c.Header("X-Upgrade-Recommended", policy.WarnVersion)
+	}
+	return true
+}
+
 func AnnotateResponse(c *gin.Context, clientId, profileId string) {
 	needsNotification, _ := storage.ProfileClientSpeechNeedsNotification(profileId, clientId)
 	if needsNotification {
@@ -128,4 +189,26 @@ func AnnotateResponse(c *gin.Context, clientId, profileId string) {
 		c.Header("X-Usage-Update", "YES")
 		_ = storage.ProfileClientUsageWasNotified(profileId, clientId)
 	}
+	if needed, etag, err := storage.ProfileClientConfigNeedsNotification(profileId, clientId); err == nil && needed {
+		c.Header("X-Config-Update", "YES")
+		_ = storage.ProfileClientConfigWasNotified(profileId, clientId, etag)
+	}
+	if needed, _ := storage.ProfileNeedsConsent(profileId); needed {
+		c.Header("X-Consent-Required", "YES")
+	}
+	if pending, _ := storage.ProfileHasPendingMessages(profileId); pending {
+		c.Header("X-Messages-Pending", "YES")
+	}
+}
+
+// deliverProfileMessage shows the profile's oldest queued message, unless the response
+// already has one. Queued messages are removed when delivered, so this is only called
+// by the handlers whose messages the client always shows, once they have succeeded.
+func deliverProfileMessage(c *gin.Context, profileId string) {
+	if c.Writer.Header().Get("X-Message") != "" {
+		return
+	}
+	if message, _ := storage.NextProfileMessage(profileId); message != "" {
+		c.Header("X-Message", message)
+	}
 }
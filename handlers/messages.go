@@ -0,0 +1,58 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/whisper-project/in-my-voice.server.golang/middleware"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+	"go.uber.org/zap"
+)
+
+func MessagesGetHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	// this fetch delivers all the pending messages
+	c.Header("X-Messages-Pending", "")
+	messages, err := storage.FetchProfileMessages(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	results := make([]gin.H, 0, len(messages))
+	for _, m := range messages {
+		results = append(results, gin.H{
+			"id": m.Id, "title": m.Title, "body": m.Body, "sent": m.Sent, "read": m.Read != 0,
+		})
+	}
+	middleware.CtxLog(c).Info("messages retrieval", zap.Int("count", len(results)),
+		zap.String("clientId", clientId), zap.String("profileId", profileId))
+	c.JSON(http.StatusOK, results)
+}
+
+func MessageReadHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	m, err := storage.MarkProfileMessageRead(profileId, c.Param("messageId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if m == nil {
+		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "no such message"})
+		return
+	}
+	middleware.CtxLog(c).Info("message read", zap.String("messageId", m.Id),
+		zap.String("clientId", clientId), zap.String("profileId", profileId))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/whisper-project/in-my-voice.server.golang/middleware"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+	"go.uber.org/zap"
+)
+
+// ProfileExportHandler returns a zip archive of all the data kept for the profile.
+func ProfileExportHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	d, err := storage.CollectProfileData(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	var b bytes.Buffer
+	if err := d.WriteArchive(&b); err != nil {
+		middleware.CtxLog(c).Error("profile archive failure",
+			zap.String("profileId", profileId), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "archive failure"})
+		return
+	}
+	middleware.CtxLog(c).Info("profile data exported",
+		zap.String("clientId", clientId), zap.String("profileId", profileId), zap.Int("size", b.Len()))
+	filename := fmt.Sprintf("in-my-voice-data-%s.zip", time.Now().In(storage.AdminTZ).Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/zip", b.Bytes())
+}
+
+// ProfileEraseHandler deletes all the data kept for the profile.
+func ProfileEraseHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	studyId, _, err := storage.GetProfileStudyMembership(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if err := storage.EraseProfileData(profileId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	middleware.CtxLog(c).Info("profile data erased",
+		zap.String("clientId", clientId), zap.String("profileId", profileId), zap.String("studyId", studyId))
+	if studyId != "" {
+		c.Header("X-Study-Membership-Update", "none")
+	}
+	c.Status(http.StatusNoContent)
+}
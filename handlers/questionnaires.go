@@ -0,0 +1,112 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/whisper-project/in-my-voice.server.golang/middleware"
+	"github.com/whisper-project/in-my-voice.server.golang/storage"
+	"go.uber.org/zap"
+)
+
+func QuestionnairesGetHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	studyId, upn, err := storage.GetProfileStudyMembership(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if studyId == "" || upn == "" {
+		middleware.CtxLog(c).Info("no questionnaires for non-study profile",
+			zap.String("clientId", clientId), zap.String("profileId", profileId))
+		c.Status(http.StatusNoContent)
+		return
+	}
+	now := time.Now().UnixMilli()
+	due, err := storage.DueQuestionnaires(studyId, upn, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	results := make([]gin.H, 0, len(due))
+	for _, q := range due {
+		items := make([]gin.H, 0, len(q.Items))
+		for _, item := range q.Items {
+			i := gin.H{"id": item.Id, "prompt": item.Prompt, "type": item.Type}
+			switch item.Type {
+			case storage.QuestionnaireItemScale:
+				i["min"], i["max"] = item.Min, item.Max
+			case storage.QuestionnaireItemChoice:
+				i["choices"] = item.Choices
+			}
+			items = append(items, i)
+		}
+		results = append(results, gin.H{
+			"id": q.Id, "name": q.Name, "schedule": q.Schedule, "period": q.Period(now), "items": items,
+		})
+	}
+	middleware.CtxLog(c).Info("questionnaires retrieval", zap.Int("count", len(results)),
+		zap.String("clientId", clientId), zap.String("profileId", profileId), zap.String("studyId", studyId))
+	c.JSON(http.StatusOK, results)
+}
+
+func QuestionnaireResponseHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	studyId, upn, err := storage.GetProfileStudyMembership(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if studyId == "" || upn == "" {
+		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "not enrolled in a study"})
+		return
+	}
+	q, err := storage.GetQuestionnaire(studyId, c.Param("questionnaireId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if q == nil || !q.Active {
+		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "no such questionnaire"})
+		return
+	}
+	var body struct {
+		Answers map[string]string `json:"answers"`
+	}
+	if err := c.ShouldBind(&body); err != nil {
+		middleware.CtxLog(c).Info("invalid questionnaire response body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "invalid request body"})
+		return
+	}
+	r, err := storage.SubmitQuestionnaireResponse(studyId, upn, q, body.Answers)
+	if errors.Is(err, storage.InvalidQuestionnaireAnswerError) {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+		return
+	}
+	if errors.Is(err, storage.QuestionnaireNotDueError) {
+		c.JSON(http.StatusConflict, gin.H{"status": "error", "error": "questionnaire already answered"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	middleware.CtxLog(c).Info("questionnaire response received",
+		zap.String("clientId", clientId), zap.String("profileId", profileId),
+		zap.String("questionnaireId", q.Id), zap.String("period", r.Period), zap.Int("answers", len(r.Answers)))
+	c.Status(http.StatusNoContent)
+}
@@ -8,6 +8,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 
@@ -160,6 +161,9 @@ func ElevenSpeechFailureHandler(c *gin.Context) {
 		zap.String("clientId", clientId), zap.String("profileId", profileId),
 		zap.String("action", action), zap.Int("responseCode", int(code)),
 		zap.Any("response", body["response"]))
+	detail := fmt.Sprintf("action: %s, code: %d", action, int(code))
+	_, _ = storage.RecordProblemReport(storage.ProblemKindSpeechFailure,
+		c.GetHeader("X-Client-Type"), clientId, profileId, reason, detail)
 	c.Status(http.StatusNoContent)
 	return
 }
@@ -196,6 +200,16 @@ func ParticipantElevenSpeechSettingsHandler(c *gin.Context) {
 		c.Status(http.StatusNoContent)
 		return
 	}
+	study, err := storage.GetStudy(studyId)
+	if err != nil || study == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if !study.UsesParticipantVoice(p) {
+		// the participant's study arm uses the default voice
+		c.Status(http.StatusNoContent)
+		return
+	}
 	if c.Query("update") != "true" {
 		// compare the settings
 		isMatch, err := storage.MatchesCurrentSpeechSettings(profileId, p.ApiKey, p.VoiceId)
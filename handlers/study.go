@@ -9,6 +9,7 @@ package handlers
 import (
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/whisper-project/in-my-voice.server.golang/middleware"
@@ -68,13 +69,41 @@ func JoinStudyHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "study ID invalid or not available"})
 		return
 	}
-	p, err := storage.EnrollStudyParticipant(profileId, studyId, upn)
+	// if the study has a consent document, the user must have accepted its current version
+	consent, err := storage.GetCurrentConsentDocument(studyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if consent != nil {
+		if accepted, _ := body["consentVersion"].(float64); int64(accepted) != consent.Version {
+			middleware.CtxLog(c).Info("consent not accepted for study",
+				zap.String("clientId", clientId), zap.String("profileId", profileId),
+				zap.String("studyId", studyId), zap.Int64("version", consent.Version))
+			c.JSON(http.StatusPreconditionRequired, gin.H{
+				"status": "error", "error": "consent required", "consentVersion": consent.Version,
+			})
+			return
+		}
+	}
+	var consentVersion int64
+	if consent != nil {
+		consentVersion = consent.Version
+	}
+	p, err := storage.EnrollStudyParticipant(profileId, clientId, studyId, upn, consentVersion)
 	if errors.Is(err, storage.ParticipantNotAvailableError) {
 		middleware.CtxLog(c).Info("UPN invalid or not available",
 			zap.String("clientId", clientId), zap.String("profileId", profileId),
 			zap.String("studyId", studyId))
 		c.JSON(http.StatusForbidden, gin.H{"status": "error", "error": "UPN invalid or not available"})
 		return
+	} else if errors.Is(err, storage.ConsentVersionNotCurrentError) {
+		// a new version of the consent document was published while the user was joining
+		middleware.CtxLog(c).Info("consent version changed during enrollment",
+			zap.String("clientId", clientId), zap.String("profileId", profileId),
+			zap.String("studyId", studyId))
+		c.JSON(http.StatusConflict, gin.H{"status": "error", "error": "consent version is not current"})
+		return
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
 		return
@@ -84,18 +113,17 @@ func JoinStudyHandler(c *gin.Context) {
 		zap.String("clientId", clientId), zap.String("profileId", profileId),
 		zap.String("studyId", studyId), zap.String("upn", upn))
 	c.Header("X-Study-Membership-Update", study.Name)
-	// if there are speech settings in the participant, apply them to the profile
-	if p != nil && p.ApiKey != "" {
-		updatedUser, err := storage.UpdateSpeechSettings(profileId, p.ApiKey, p.VoiceId, p.VoiceName, "")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
-			return
-		}
-		if updatedUser {
-			// ignore update errors
-			_ = storage.ProfileClientSpeechDidUpdate(profileId, clientId)
-			c.Header("X-Speech-Settings-Update", "true")
-		}
+	// make the profile's speech settings match the participant's arm, which
+	// either uses the participant's own voice (if they have one) or the default voice
+	updated, err := storage.ApplyArmVoice(study, p, clientId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	if updated {
+		c.Header("X-Speech-Settings-Update", "true")
+	}
+	if updated || (p.ApiKey != "" && study.UsesParticipantVoice(p)) {
 		c.JSON(http.StatusOK, gin.H{"elevenSettings": "updated"})
 		return
 	}
@@ -128,6 +156,45 @@ func LeaveStudyHandler(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// WithdrawStudyHandler withdraws the participant from their study, optionally
+// deleting the data collected from them. Unlike leaving, withdrawal is permanent.
+func WithdrawStudyHandler(c *gin.Context) {
+	clientId, profileId, ok := ValidateRequest(c)
+	if !ok {
+		return
+	}
+	studyId, upn, err := storage.GetProfileStudyMembership(profileId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	} else if upn == "" {
+		c.JSON(http.StatusForbidden, gin.H{"status": "error", "error": "no study ID assigned"})
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+		Purge  bool   `json:"purge"`
+	}
+	if err := c.ShouldBind(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "invalid request body"})
+		return
+	}
+	reason := strings.TrimSpace(body.Reason)
+	_, err = storage.WithdrawStudyParticipant(studyId, upn, storage.WithdrawnByParticipant, reason, body.Purge)
+	if errors.Is(err, storage.ParticipantNotWithdrawableError) {
+		c.JSON(http.StatusConflict, gin.H{"status": "error", "error": "participant can't be withdrawn"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "database failure"})
+		return
+	}
+	middleware.CtxLog(c).Info("participant withdrew from study",
+		zap.String("clientId", clientId), zap.String("profileId", profileId),
+		zap.String("studyId", studyId), zap.Bool("purge", body.Purge))
+	c.Header("X-Study-Membership-Update", "none")
+	c.Status(http.StatusNoContent)
+}
+
 func LineDataHandler(c *gin.Context) {
 	_, profileId, ok := ValidateRequest(c)
 	if !ok {
@@ -142,17 +209,7 @@ func LineDataHandler(c *gin.Context) {
 		// no data kept for non-study participants
 		return
 	}
-	var platform storage.Platform = storage.PlatformUnknown
-	switch header := c.GetHeader("X-Platform-Info"); header {
-	case "phone":
-		platform = storage.PlatformPhone
-	case "pad", "tablet":
-		platform = storage.PlatformTablet
-	case "mac", "windows", "linux", "android":
-		platform = storage.PlatformComputer
-	case "web":
-		platform = storage.PlatformBrowser
-	}
+	platform := storage.ParsePlatform(c.GetHeader("X-Platform-Info"))
 	var body []map[string]any
 	if err := c.ShouldBind(&body); err != nil {
 		middleware.CtxLog(c).Info("invalid line-data request body", zap.Error(err))
@@ -170,7 +227,8 @@ func processLineData(platform storage.Platform, studyId, upn string, body []map[
 	for _, data := range body {
 		if isFavorite, ok := data["isFavorite"].(bool); ok {
 			if text, ok := data["text"].(string); ok {
-				saveRepeatLine(studyId, text, isFavorite)
+				completed, _ := data["completed"].(float64)
+				saveRepeatLine(studyId, upn, text, isFavorite, int64(completed))
 			}
 		} else {
 			if ok := fillStat(&lines[j], platform, upn, data); ok {
@@ -202,15 +260,6 @@ func fillStat(stat *storage.TypedLineStat, platform storage.Platform, upn string
 	return false
 }
 
-func saveRepeatLine(studyId, text string, isFavorite bool) {
-	stat, err := storage.GetOrCreatePhraseStat(studyId, text)
-	if err != nil {
-		return
-	}
-	if isFavorite {
-		stat.FavoriteCount++
-	} else {
-		stat.RepeatCount++
-	}
-	_ = storage.SavePhraseStat(studyId, stat)
+func saveRepeatLine(studyId, upn, text string, isFavorite bool, completed int64) {
+	_ = storage.CountPhraseUse(studyId, upn, text, isFavorite, completed)
 }
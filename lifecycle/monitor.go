@@ -71,4 +71,6 @@ func updateMonitors(ctx context.Context) {
 			}
 		}
 	}
+	_ = storage.SendUsageAlertDigests(ctx)
+	_ = storage.CheckInactiveParticipants(ctx)
 }
@@ -103,6 +103,17 @@ func DeleteStorage[T RedisKey](ctx context.Context, obj T) error {
 	return nil
 }
 
+// StorageType returns the redis type of the stored value ("none" if there isn't one).
+func StorageType[T RedisKey](ctx context.Context, obj T) (string, error) {
+	db, prefix := GetDb()
+	key := prefix + obj.StoragePrefix() + obj.StorageId()
+	res := db.Type(ctx, key)
+	if err := res.Err(); err != nil {
+		return "", err
+	}
+	return res.Val(), nil
+}
+
 // String-valued keys
 
 func FetchString[T RedisKey](ctx context.Context, obj T) (string, error) {
@@ -151,6 +162,16 @@ func IsMember[T RedisKey](ctx context.Context, obj T, member string) (bool, erro
 	return res.Val(), nil
 }
 
+func SetSize[T RedisKey](ctx context.Context, obj T) (int64, error) {
+	db, prefix := GetDb()
+	key := prefix + obj.StoragePrefix() + obj.StorageId()
+	res := db.SCard(ctx, key)
+	if err := res.Err(); err != nil {
+		return 0, err
+	}
+	return res.Val(), nil
+}
+
 func AddMembers[T RedisKey](ctx context.Context, obj T, members ...string) error {
 	if len(members) == 0 {
 		// nothing to add
@@ -285,6 +306,26 @@ func MoveOne[T RedisKey](ctx context.Context, src T, dst T, srcLeft bool, dstLef
 	return res.Val(), nil
 }
 
+// PopOne removes and returns the element at one end of a list.
+// It returns the empty string if the list is empty.
+func PopOne[T RedisKey](ctx context.Context, obj T, onLeft bool) (string, error) {
+	db, prefix := GetDb()
+	key := prefix + obj.StoragePrefix() + obj.StorageId()
+	var res *redis.StringCmd
+	if onLeft {
+		res = db.LPop(ctx, key)
+	} else {
+		res = db.RPop(ctx, key)
+	}
+	if err := res.Err(); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", nil
+		}
+		return "", err
+	}
+	return res.Val(), nil
+}
+
 func PushRange[T RedisKey](ctx context.Context, obj T, onLeft bool, members ...string) error {
 	db, prefix := GetDb()
 	key := prefix + obj.StoragePrefix() + obj.StorageId()
@@ -304,6 +345,28 @@ func PushRange[T RedisKey](ctx context.Context, obj T, onLeft bool, members ...s
 	return nil
 }
 
+func SetElement[T RedisKey](ctx context.Context, obj T, index int64, element string) error {
+	db, prefix := GetDb()
+	key := prefix + obj.StoragePrefix() + obj.StorageId()
+	res := db.LSet(ctx, key, index, any(element))
+	if err := res.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TrimRange keeps only the list elements from start to end (inclusive).
+// Just as with FetchRange, negative indices count back from the end.
+func TrimRange[T RedisKey](ctx context.Context, obj T, start int64, end int64) error {
+	db, prefix := GetDb()
+	key := prefix + obj.StoragePrefix() + obj.StorageId()
+	res := db.LTrim(ctx, key, start, end)
+	if err := res.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func RemoveElement[T RedisKey](ctx context.Context, obj T, count int64, element string) error {
 	db, prefix := GetDb()
 	key := prefix + obj.StoragePrefix() + obj.StorageId()
@@ -445,6 +508,150 @@ func MapValuesAtKeys[K RedisKey, V RedisValue](ctx context.Context, f func() err
 	return nil
 }
 
+// Optimistic updates
+
+// TooMuchContentionError is returned when an update keeps conflicting with other updates.
+var TooMuchContentionError = errors.New("too many conflicting updates")
+
+const maxUpdateAttempts = 100
+
+// watchAndUpdate runs a WATCH/MULTI transaction on the key: it fetches the current value,
+// applies the update, and stores the result, retrying if the key changed in the meantime.
+func watchAndUpdate(
+	ctx context.Context, key string,
+	get func(tx *redis.Tx) (string, error),
+	set func(pipe redis.Pipeliner, val string),
+	update func(string) (string, bool, error),
+) (bool, error) {
+	db, _ := GetDb()
+	changed := false
+	txf := func(tx *redis.Tx) error {
+		changed = false
+		old, err := get(tx)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		val, ok, err := update(old)
+		if err != nil || !ok {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			set(pipe, val)
+			return nil
+		})
+		if err == nil {
+			changed = true
+		}
+		return err
+	}
+	for range maxUpdateAttempts {
+		err := db.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return changed, err
+	}
+	return false, fmt.Errorf("key %v: %w", key, TooMuchContentionError)
+}
+
+// UpdateString atomically replaces the string stored at a key with the result of
+// applying the update function to it ("" if nothing is stored). If the update returns
+// false, nothing is stored. If the key is changed by someone else during the update,
+// the update is retried. Returns whether the value was stored.
+func UpdateString[T RedisKey](ctx context.Context, obj T, update func(string) (string, bool, error)) (bool, error) {
+	_, prefix := GetDb()
+	key := prefix + obj.StoragePrefix() + obj.StorageId()
+	get := func(tx *redis.Tx) (string, error) {
+		return tx.Get(ctx, key).Result()
+	}
+	set := func(pipe redis.Pipeliner, val string) {
+		pipe.Set(ctx, key, val, redis.KeepTTL)
+	}
+	return watchAndUpdate(ctx, key, get, set, update)
+}
+
+// MapUpdate atomically replaces the value of a map field with the result of applying
+// the update function to it ("" if there's no such field). If the update returns false,
+// nothing is stored. If the map is changed by someone else during the update,
+// the update is retried. Returns whether the value was stored.
+func MapUpdate[T RedisKey](ctx context.Context, obj T, k string, update func(string) (string, bool, error)) (bool, error) {
+	_, prefix := GetDb()
+	key := prefix + obj.StoragePrefix() + obj.StorageId()
+	get := func(tx *redis.Tx) (string, error) {
+		return tx.HGet(ctx, key, k).Result()
+	}
+	set := func(pipe redis.Pipeliner, val string) {
+		pipe.HSet(ctx, key, k, val)
+	}
+	return watchAndUpdate(ctx, key, get, set, update)
+}
+
+// PushRangeIf atomically pushes the members onto the list, as PushRange does, but only
+// if the check of the list's current contents returns true. If the list is changed by
+// someone else during the check, the check is retried. Returns whether the members were pushed.
+func PushRangeIf[T RedisKey](ctx context.Context, obj T, onLeft bool, check func([]string) (bool, error), members ...string) (bool, error) {
+	_, prefix := GetDb()
+	key := prefix + obj.StoragePrefix() + obj.StorageId()
+	var current []string
+	get := func(tx *redis.Tx) (string, error) {
+		var err error
+		current, err = tx.LRange(ctx, key, 0, -1).Result()
+		return "", err
+	}
+	update := func(string) (string, bool, error) {
+		ok, err := check(current)
+		return "", ok, err
+	}
+	args := make([]interface{}, len(members))
+	for i, member := range members {
+		args[i] = any(member)
+	}
+	set := func(pipe redis.Pipeliner, _ string) {
+		if onLeft {
+			pipe.LPush(ctx, key, args...)
+		} else {
+			pipe.RPush(ctx, key, args...)
+		}
+	}
+	return watchAndUpdate(ctx, key, get, set, update)
+}
+
+// decodeAndUpdate adapts a value update to a string update. The value is loaded from the
+// string (or left as is, if the string is empty) and the update is told whether it was found.
+func decodeAndUpdate[V RedisValue](v V, update func(found bool) (bool, error)) func(string) (string, bool, error) {
+	return func(old string) (string, bool, error) {
+		found := old != ""
+		if found {
+			if err := v.FromRedis([]byte(old)); err != nil {
+				return "", false, err
+			}
+		}
+		ok, err := update(found)
+		if err != nil || !ok {
+			return "", false, err
+		}
+		b, err := v.ToRedis()
+		if err != nil {
+			return "", false, err
+		}
+		return string(b), true, nil
+	}
+}
+
+// UpdateValueAtKey atomically updates the value stored at a key. The value is
+// loaded (if found) and passed to the update function, which modifies it in place
+// and returns whether it should be stored. The update may be called more than once,
+// and if the value wasn't found it must initialize the value completely.
+func UpdateValueAtKey[K RedisKey, V RedisValue](ctx context.Context, k K, v V, update func(found bool) (bool, error)) (bool, error) {
+	return UpdateString(ctx, k, decodeAndUpdate(v, update))
+}
+
+// MapUpdateValue atomically updates the value of a map field, just as UpdateValueAtKey
+// updates the value stored at a key.
+func MapUpdateValue[K RedisKey, V RedisValue](ctx context.Context, k K, field string, v V, update func(found bool) (bool, error)) (bool, error) {
+	return MapUpdate(ctx, k, field, decodeAndUpdate(v, update))
+}
+
 type Object interface {
 	RedisKey
 	RedisValue
@@ -458,6 +665,11 @@ func SaveObject[T Object](ctx context.Context, obj T) error {
 	return SaveValueAtKey(ctx, obj, obj)
 }
 
+// UpdateObject atomically updates an object, just as UpdateValueAtKey updates the value stored at a key.
+func UpdateObject[T Object](ctx context.Context, obj T, update func(found bool) (bool, error)) (bool, error) {
+	return UpdateValueAtKey(ctx, obj, obj, update)
+}
+
 func MapObjects[T Object](ctx context.Context, f func() error, obj T) error {
 	return MapValuesAtKeys(ctx, f, obj, obj)
 }
@@ -506,3 +718,17 @@ func (s StorableMap) StoragePrefix() string {
 func (s StorableMap) StorageId() string {
 	return string(s)
 }
+
+// A PrefixedKey is a key with an arbitrary prefix and ID, for use
+// when the type stored at the key isn't known until runtime.
+type PrefixedKey struct {
+	Prefix string
+	Id     string
+}
+
+func (k PrefixedKey) StoragePrefix() string {
+	return k.Prefix
+}
+func (k PrefixedKey) StorageId() string {
+	return k.Id
+}
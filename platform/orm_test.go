@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -26,6 +27,10 @@ func TestStorableStringInterfaceDefinition(t *testing.T) {
 	RedisKeyTester(t, ormTestString, "string:", "ormTestString")
 }
 
+func TestPrefixedKeyInterfaceDefinition(t *testing.T) {
+	RedisKeyTester(t, PrefixedKey{Prefix: "prefix:", Id: "id"}, "prefix:", "id")
+}
+
 func TestFetchSetFetchString(t *testing.T) {
 	ctx := context.Background()
 	if val, err := FetchString(ctx, ormTestString); err != nil || val != "" {
@@ -102,6 +107,9 @@ func TestAddFetchIsRemoveMembers(t *testing.T) {
 	} else if len(found) != 3 {
 		t.Errorf("FetchMembers returned %d results, expected 3: %#v", len(found), found)
 	}
+	if size, err := SetSize(ctx, ormTestSet); err != nil || size != 3 {
+		t.Errorf("SetSize failed (%v), expected 3 but got %d", err, size)
+	}
 	if val, err := IsMember(ctx, ormTestSet, "b"); err != nil {
 		t.Errorf("IsMember failed: %v", err)
 	} else if !val {
@@ -285,8 +293,79 @@ func TestMoveRange(t *testing.T) {
 	}
 }
 
+func TestPopOne(t *testing.T) {
+	ctx := context.Background()
+	defer func() {
+		if err := DeleteStorage(ctx, ormTestList); err != nil {
+			t.Errorf("Failed to delete stored data for %q: %v", ormTestList, err)
+		}
+	}()
+	if val, err := PopOne(ctx, ormTestList, true); err != nil || val != "" {
+		t.Errorf("Pop one on an empty list returned %q, %v; expected empty success", val, err)
+	}
+	if err := PushRange(ctx, ormTestList, false, "a", "b", "c"); err != nil {
+		t.Fatalf("Failed to push right: %v", err)
+	}
+	if val, err := PopOne(ctx, ormTestList, true); err != nil || val != "a" {
+		t.Errorf("Pop one on the left returned %q, %v; expected \"a\"", val, err)
+	}
+	if val, err := PopOne(ctx, ormTestList, false); err != nil || val != "c" {
+		t.Errorf("Pop one on the right returned %q, %v; expected \"c\"", val, err)
+	}
+	if remaining, err := FetchRange(ctx, ormTestList, 0, -1); err != nil {
+		t.Errorf("FetchRange of the list failed, expected success")
+	} else if diff := deep.Equal(remaining, []string{"b"}); diff != nil {
+		t.Errorf("FetchRange of list is:\n%v\ndifferences are:\n%v", remaining, diff)
+	}
+}
+
 var ormTestMap StorableMap = "ormTestMap"
 
+func TestSetElement(t *testing.T) {
+	ctx := context.Background()
+	if err := PushRange(ctx, ormTestList, false, "a", "b", "c"); err != nil {
+		t.Errorf("Failed to push: %v", err)
+	}
+	if err := SetElement(ctx, ormTestList, 1, "B"); err != nil {
+		t.Errorf("Failed to set element 1: %v", err)
+	}
+	if err := SetElement(ctx, ormTestList, 3, "d"); err == nil {
+		t.Errorf("Set of element 3 succeeded, expected failure")
+	}
+	if after, err := FetchRange(ctx, ormTestList, 0, -1); err != nil {
+		t.Errorf("FetchRange of the after list failed, expected success")
+	} else if diff := deep.Equal(after, []string{"a", "B", "c"}); diff != nil {
+		t.Errorf("FetchRange of after list is:\n%v\nwith differences:\n%v", after, diff)
+	}
+	if kind, err := StorageType(ctx, ormTestList); err != nil || kind != "list" {
+		t.Errorf("StorageType of list failed (%v), expected \"list\" got %q", err, kind)
+	}
+	if err := DeleteStorage(ctx, ormTestList); err != nil {
+		t.Errorf("Failed to delete stored data for %q: %v", ormTestList, err)
+	}
+	if kind, err := StorageType(ctx, ormTestList); err != nil || kind != "none" {
+		t.Errorf("StorageType of deleted list failed (%v), expected \"none\" got %q", err, kind)
+	}
+}
+
+func TestTrimRange(t *testing.T) {
+	ctx := context.Background()
+	if err := PushRange(ctx, ormTestList, false, "a", "b", "c", "d"); err != nil {
+		t.Errorf("Failed to push: %v", err)
+	}
+	if err := TrimRange(ctx, ormTestList, 0, 1); err != nil {
+		t.Errorf("Failed to trim: %v", err)
+	}
+	if after, err := FetchRange(ctx, ormTestList, 0, -1); err != nil {
+		t.Errorf("FetchRange of the trimmed list failed, expected success")
+	} else if diff := deep.Equal(after, []string{"a", "b"}); diff != nil {
+		t.Errorf("FetchRange of trimmed list is:\n%v\nwith differences:\n%v", after, diff)
+	}
+	if err := DeleteStorage(ctx, ormTestList); err != nil {
+		t.Errorf("Failed to delete stored data for %q: %v", ormTestList, err)
+	}
+}
+
 func TestStorableMapInterfaceDefinition(t *testing.T) {
 	RedisKeyTester(t, ormTestMap, "map:", "ormTestMap")
 }
@@ -560,3 +639,143 @@ func TestMapStringsAtKeys(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestUpdateStringConcurrently(t *testing.T) {
+	ctx := context.Background()
+	_, _ = GetDb() // connect before going parallel
+	key := StorableString("ormTestCounter")
+	defer func() { _ = DeleteStorage(ctx, key) }()
+	increment := func(old string) (string, bool, error) {
+		n := 0
+		if old != "" {
+			if _, err := fmt.Sscan(old, &n); err != nil {
+				return "", false, err
+			}
+		}
+		return fmt.Sprint(n + 1), true, nil
+	}
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				if _, err := UpdateString(ctx, key, increment); err != nil {
+					t.Errorf("UpdateString failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if val, err := FetchString(ctx, key); err != nil || val != "200" {
+		t.Errorf("Counter after parallel updates is %q (%v), expected 200", val, err)
+	}
+	skip := func(string) (string, bool, error) { return "", false, nil }
+	if ok, err := UpdateString(ctx, key, skip); err != nil || ok {
+		t.Errorf("Skipped update returned (%v, %v), expected (false, nil)", ok, err)
+	}
+	if val, err := FetchString(ctx, key); err != nil || val != "200" {
+		t.Errorf("Counter after skipped update is %q (%v), expected 200", val, err)
+	}
+}
+
+func TestMapUpdateValueConcurrently(t *testing.T) {
+	ctx := context.Background()
+	_, _ = GetDb() // connect before going parallel
+	key := StorableMap("ormTestCounters")
+	defer func() { _ = DeleteStorage(ctx, key) }()
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			field := fmt.Sprint(i % 2)
+			for range 10 {
+				v := new(OrmTestStruct)
+				update := func(found bool) (bool, error) {
+					if !found {
+						*v = OrmTestStruct{IdField: field}
+					}
+					v.CreateDateMillis++
+					return true, nil
+				}
+				if _, err := MapUpdateValue(ctx, key, field, v, update); err != nil {
+					t.Errorf("MapUpdateValue failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, field := range []string{"0", "1"} {
+		val, err := MapGet(ctx, key, field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v OrmTestStruct
+		if err := v.FromRedis([]byte(val)); err != nil {
+			t.Fatal(err)
+		}
+		if v.IdField != field || v.CreateDateMillis != 100 {
+			t.Errorf("Field %s after parallel updates is %#v, expected count 100", field, v)
+		}
+	}
+}
+
+func TestPushRangeIfConcurrently(t *testing.T) {
+	ctx := context.Background()
+	_, _ = GetDb() // connect before going parallel
+	key := StorableList("ormTestPushIf")
+	defer func() { _ = DeleteStorage(ctx, key) }()
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// each pusher only pushes if there are fewer than 5 elements
+			check := func(vals []string) (bool, error) { return len(vals) < 5, nil }
+			if _, err := PushRangeIf(ctx, key, false, check, fmt.Sprint(i)); err != nil {
+				t.Errorf("PushRangeIf failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if vals, err := FetchRange(ctx, key, 0, -1); err != nil || len(vals) != 5 {
+		t.Errorf("List after parallel conditional pushes is %v (%v), expected 5 elements", vals, err)
+	}
+	failed := errors.New("check failed")
+	check := func([]string) (bool, error) { return true, failed }
+	if ok, err := PushRangeIf(ctx, key, true, check, "x"); ok || !errors.Is(err, failed) {
+		t.Errorf("PushRangeIf with failing check returned (%v, %v), expected (false, %v)", ok, err, failed)
+	}
+}
+
+func TestUpdateObjectConcurrently(t *testing.T) {
+	ctx := context.Background()
+	_, _ = GetDb() // connect before going parallel
+	obj := &OrmTestStruct{IdField: "ormTestConcurrent"}
+	defer func() { _ = DeleteStorage(ctx, obj) }()
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				v := &OrmTestStruct{IdField: obj.IdField}
+				update := func(found bool) (bool, error) {
+					if !found {
+						*v = OrmTestStruct{IdField: obj.IdField}
+					}
+					v.CreateDateMillis++
+					return true, nil
+				}
+				if _, err := UpdateObject(ctx, v, update); err != nil {
+					t.Errorf("UpdateObject failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := LoadObject(ctx, obj); err != nil || obj.CreateDateMillis != 200 {
+		t.Errorf("Object after parallel updates is %#v (%v), expected count 200", obj, err)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package platform
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// A Migration upgrades every stored value of a type to a new schema version.
+//
+// Run is given a progress function that it should call after each value
+// it examines. It returns the number of values it changed (or would have
+// changed, on a dry run). Migrations must be safe to run more than once.
+type Migration struct {
+	Type        string // the stored type being migrated
+	Version     int64  // the schema version this migration produces
+	Description string
+	Run         func(ctx context.Context, dryRun bool, progress func()) (int64, error)
+}
+
+var (
+	// the map from stored type name to its current schema version
+	schemaVersions = StorableMap("schema-versions")
+	migrations     []Migration
+)
+
+// RegisterMigration adds a migration to those known to the server.
+// Migrations for a type must be registered with increasing versions.
+func RegisterMigration(m Migration) {
+	for _, o := range migrations {
+		if o.Type == m.Type && o.Version >= m.Version {
+			panic(fmt.Sprintf("migration of %s to version %d registered after version %d", m.Type, m.Version, o.Version))
+		}
+	}
+	migrations = append(migrations, m)
+}
+
+// SchemaVersion returns the version of a stored type's schema that the server expects.
+func SchemaVersion(typeName string) int64 {
+	var version int64
+	for _, m := range migrations {
+		if m.Type == typeName {
+			version = max(version, m.Version)
+		}
+	}
+	return version
+}
+
+// StoredSchemaVersion returns the version of a type's schema that the database has.
+func StoredSchemaVersion(ctx context.Context, typeName string) (int64, error) {
+	val, err := MapGet(ctx, schemaVersions, typeName)
+	if err != nil || val == "" {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// PendingMigrations returns the registered migrations that the database hasn't had,
+// in the order they were registered.
+func PendingMigrations(ctx context.Context) ([]Migration, error) {
+	var pending []Migration
+	stored := make(map[string]int64)
+	for _, m := range migrations {
+		version, ok := stored[m.Type]
+		if !ok {
+			var err error
+			if version, err = StoredSchemaVersion(ctx, m.Type); err != nil {
+				return nil, err
+			}
+			stored[m.Type] = version
+		}
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// RunMigrations runs all the pending migrations, recording the new schema version of each type
+// as its migrations complete. The report function is called with each migration as it starts,
+// and then with a running count of the values examined. On a dry run, no versions are recorded.
+func RunMigrations(ctx context.Context, dryRun bool, report func(m Migration, examined int64)) (map[string]int64, error) {
+	pending, err := PendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string]int64)
+	for _, m := range pending {
+		var examined int64
+		report(m, examined)
+		progress := func() {
+			examined++
+			report(m, examined)
+		}
+		count, err := m.Run(ctx, dryRun, progress)
+		if err != nil {
+			return changed, fmt.Errorf("migrate %s to version %d: %w", m.Type, m.Version, err)
+		}
+		changed[m.Type] += count
+		if dryRun {
+			continue
+		}
+		if err := MapSet(ctx, schemaVersions, m.Type, strconv.FormatInt(m.Version, 10)); err != nil {
+			return changed, fmt.Errorf("record %s version %d: %w", m.Type, m.Version, err)
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package platform
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunMigrations(t *testing.T) {
+	ctx := context.Background()
+	typeName := "schemaTestType"
+	defer func() {
+		_ = MapRemove(ctx, schemaVersions, typeName)
+		migrations = migrations[:len(migrations)-2]
+	}()
+	runs := 0
+	run := func(ctx context.Context, dryRun bool, progress func()) (int64, error) {
+		runs++
+		progress()
+		progress()
+		return 1, nil
+	}
+	RegisterMigration(Migration{Type: typeName, Version: 1, Description: "first", Run: run})
+	RegisterMigration(Migration{Type: typeName, Version: 2, Description: "second", Run: run})
+	if v := SchemaVersion(typeName); v != 2 {
+		t.Errorf("SchemaVersion should be 2 but is %d", v)
+	}
+	if pending, err := PendingMigrations(ctx); err != nil || len(pending) != 2 {
+		t.Fatalf("PendingMigrations failed (%v), expected 2 but got %d", err, len(pending))
+	}
+	var reports []int64
+	report := func(m Migration, examined int64) {
+		reports = append(reports, examined)
+	}
+	if changed, err := RunMigrations(ctx, true, report); err != nil || changed[typeName] != 2 {
+		t.Errorf("Dry run failed (%v), expected 2 changed but got %d", err, changed[typeName])
+	}
+	if v, err := StoredSchemaVersion(ctx, typeName); err != nil || v != 0 {
+		t.Errorf("Dry run recorded version %d (%v), expected 0", v, err)
+	}
+	if len(reports) != 6 || reports[5] != 2 {
+		t.Errorf("Dry run progress reports were %v, expected 0, 1, 2 twice", reports)
+	}
+	if _, err := RunMigrations(ctx, false, report); err != nil || runs != 4 {
+		t.Errorf("Run failed (%v), expected 4 total runs but got %d", err, runs)
+	}
+	if v, err := StoredSchemaVersion(ctx, typeName); err != nil || v != 2 {
+		t.Errorf("Run recorded version %d (%v), expected 2", v, err)
+	}
+	if pending, err := PendingMigrations(ctx); err != nil || len(pending) != 0 {
+		t.Errorf("PendingMigrations failed (%v), expected none but got %d", err, len(pending))
+	}
+}
+
+func TestRegisterMigrationOutOfOrder(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Registering an out-of-order migration should panic")
+		}
+		migrations = migrations[:len(migrations)-1]
+	}()
+	run := func(ctx context.Context, dryRun bool, progress func()) (int64, error) { return 0, nil }
+	RegisterMigration(Migration{Type: "schemaTestOrder", Version: 2, Run: run})
+	RegisterMigration(Migration{Type: "schemaTestOrder", Version: 1, Run: run})
+}
@@ -9,6 +9,12 @@ package platform
 import (
 	"crypto/sha1"
 	"encoding/base64"
+	"encoding/binary"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
 )
 
 func SetIfMissing[T int64 | float64 | string | bool](loc *T, val T) {
@@ -23,3 +29,103 @@ func MakeSha1(s string) string {
 	hashFn.Write([]byte(s))
 	return base64.URLEncoding.EncodeToString(hashFn.Sum(nil))
 }
+
+// ScrambleText replaces every letter and digit in the text with a random one
+// of the same kind and case, leaving whitespace and punctuation alone, so the
+// result has the same length and shape as the original.
+// The same text and salt always produce the same result.
+func ScrambleText(s, salt string) string {
+	sum := sha1.Sum([]byte(salt + s))
+	r := rand.New(rand.NewPCG(binary.BigEndian.Uint64(sum[0:8]), binary.BigEndian.Uint64(sum[8:16])))
+	runes := []rune(s)
+	for i, c := range runes {
+		switch {
+		case unicode.IsUpper(c):
+			runes[i] = 'A' + rune(r.IntN(26))
+		case unicode.IsLetter(c):
+			runes[i] = 'a' + rune(r.IntN(26))
+		case unicode.IsDigit(c):
+			runes[i] = '0' + rune(r.IntN(10))
+		}
+	}
+	return string(runes)
+}
+
+var (
+	emailPattern  = regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`)
+	phonePattern  = regexp.MustCompile(`\+?\(?\d{1,4}\)?([\s.-]?\(?\d{2,4}\)?){2,4}`)
+	numberPattern = regexp.MustCompile(`\d+`)
+)
+
+// ScrubPII replaces email addresses, phone numbers, and any other
+// numbers in the text with placeholders.
+func ScrubPII(s string) string {
+	s = emailPattern.ReplaceAllLiteralString(s, "[email]")
+	s = phonePattern.ReplaceAllStringFunc(s, func(m string) string {
+		digits := 0
+		for _, c := range m {
+			if unicode.IsDigit(c) {
+				digits++
+			}
+		}
+		if digits < 7 {
+			return m
+		}
+		return "[phone]"
+	})
+	return numberPattern.ReplaceAllLiteralString(s, "[number]")
+}
+
+var versionPattern = regexp.MustCompile(`^v?(\d+(?:\.\d+)*)`)
+
+// ParseVersion parses the leading dotted numbers of a version string such
+// as "1.4.2" or "v2.0 (381)", ignoring anything after them. It returns false
+// if the string doesn't start with a version number.
+func ParseVersion(s string) ([]int64, bool) {
+	m := versionPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, false
+	}
+	parts := strings.Split(m[1], ".")
+	result := make([]int64, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		result[i] = n
+	}
+	return result, true
+}
+
+// CompareVersions compares two version strings numerically, part by part,
+// treating missing parts as 0 (so "1.2" and "1.2.0" are equal). Strings that
+// aren't versions compare equal to each other and less than any version.
+func CompareVersions(a, b string) int {
+	va, okA := ParseVersion(a)
+	vb, okB := ParseVersion(b)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	for i := 0; i < max(len(va), len(vb)); i++ {
+		var pa, pb int64
+		if i < len(va) {
+			pa = va[i]
+		}
+		if i < len(vb) {
+			pb = vb[i]
+		}
+		if pa != pb {
+			if pa < pb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
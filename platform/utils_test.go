@@ -10,6 +10,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"testing"
+	"unicode"
 )
 
 func TestSetIfMissing(t *testing.T) {
@@ -46,3 +47,67 @@ func TestMakeSha1(t *testing.T) {
 		t.Errorf("computedSha1Base64 should be %q but is %q", emptySha1Base64, computedSha1Base64)
 	}
 }
+
+func TestScrambleText(t *testing.T) {
+	original := "Hello, World 42!"
+	scrambled := ScrambleText(original, "salt")
+	if scrambled == original {
+		t.Errorf("scrambled text should differ from %q", original)
+	}
+	if len([]rune(scrambled)) != len([]rune(original)) {
+		t.Errorf("scrambled text %q should have the same length as %q", scrambled, original)
+	}
+	for i, c := range []rune(scrambled) {
+		o := []rune(original)[i]
+		if unicode.IsUpper(o) != unicode.IsUpper(c) || unicode.IsLower(o) != unicode.IsLower(c) ||
+			unicode.IsDigit(o) != unicode.IsDigit(c) || (!unicode.IsLetter(o) && !unicode.IsDigit(o) && o != c) {
+			t.Errorf("scrambled text %q doesn't have the shape of %q at %d", scrambled, original, i)
+		}
+	}
+	if again := ScrambleText(original, "salt"); again != scrambled {
+		t.Errorf("scrambling should be repeatable but got %q and %q", scrambled, again)
+	}
+	if other := ScrambleText(original, "pepper"); other == scrambled {
+		t.Errorf("scrambling with a different salt should differ but got %q", other)
+	}
+}
+
+func TestScrubPII(t *testing.T) {
+	cases := map[string]string{
+		"email me at jo.smith+imv@example.co.uk please": "email me at [email] please",
+		"call (555) 123-4567 now":                       "call [phone] now",
+		"my number is +1 415.555.0100":                  "my number is [phone]",
+		"I live at 42 Main St":                          "I live at [number] Main St",
+		"nothing to see here":                           "nothing to see here",
+	}
+	for in, want := range cases {
+		if got := ScrubPII(in); got != want {
+			t.Errorf("ScrubPII(%q) should be %q but is %q", in, want, got)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.2.3", "1.10", -1},
+		{"2.0", "1.99.99", 1},
+		{"v1.4 (381)", "1.4", 0},
+		{"1.4.1-beta", "1.4", 1},
+		{"", "0.1", -1},
+		{"unknown", "", 0},
+		{"0.1", "garbage", 1},
+	}
+	for _, c := range cases {
+		if got := CompareVersions(c.a, c.b); got != c.want {
+			t.Errorf("CompareVersions(%q, %q) should be %d but is %d", c.a, c.b, c.want, got)
+		}
+	}
+	if v, ok := ParseVersion("3.12.1"); !ok || len(v) != 3 || v[1] != 12 {
+		t.Errorf("ParseVersion(%q) should be [3 12 1] but is %v (%v)", "3.12.1", v, ok)
+	}
+}
@@ -13,15 +13,32 @@ import (
 )
 
 func SendLinkViaEmail(address, link string) error {
-	m := gomail.NewMessage()
-	m.SetHeader("From", "noreply@whisper-project.org")
-	m.SetHeader("To", address)
-	m.SetHeader("Subject", "In My Voice login link")
+	m := newMessage("In My Voice login link", address)
 	msg := `To log into the administration console, please copy/paste this link into your browser:`
 	m.SetBody("text/plain", fmt.Sprintf("%s\n\n%s", msg, link))
 	msg = `<p>To log into the administration console, please click <a href="%s">this link</a>.</p>`
 	m.AddAlternative("text/html", fmt.Sprintf(msg, link))
+	return send(m)
+}
+
+func SendAlertViaEmail(addresses []string, subject, body string) error {
+	if len(addresses) == 0 {
+		return nil
+	}
+	m := newMessage(subject, addresses...)
+	m.SetBody("text/plain", body)
+	return send(m)
+}
+
+func newMessage(subject string, addresses ...string) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", "noreply@whisper-project.org")
+	m.SetHeader("To", addresses...)
+	m.SetHeader("Subject", subject)
+	return m
+}
 
+func send(m *gomail.Message) error {
 	env := platform.GetConfig()
 	d := gomail.NewDialer(env.SmtpHost, env.SmtpPort, env.SmtpCredId, env.SmtpCredSecret)
 	return d.DialAndSend(m)
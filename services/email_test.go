@@ -21,3 +21,9 @@ func TestSendLinkViaEmail(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSendAlertViaEmailNoAddresses(t *testing.T) {
+	if err := SendAlertViaEmail(nil, "test alert", "no one should receive this"); err != nil {
+		t.Fatal(err)
+	}
+}
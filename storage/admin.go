@@ -31,7 +31,10 @@ var AdminTZ = func() *time.Location {
 type AdminRole = string
 
 const (
-	AdminRoleResearcher         AdminRole = "Researcher"
+	AdminRoleResearcher AdminRole = "Researcher"
+	// AdminRoleDataSteward has all the access of a researcher, and can also see the
+	// raw content of participant phrases that is hidden by the study's privacy settings.
+	AdminRoleDataSteward        AdminRole = "Data Steward"
 	AdminRoleParticipantManager AdminRole = "Participant Manager"
 	AdminRoleUserManager        AdminRole = "User Manager"
 	AdminRoleSuperAdmin         AdminRole = "Developer"
@@ -40,11 +43,12 @@ const (
 var (
 	RoleLabels = map[AdminRole]string{
 		AdminRoleResearcher:         "researcher",
+		AdminRoleDataSteward:        "steward",
 		AdminRoleParticipantManager: "participant",
 		AdminRoleUserManager:        "user",
 		AdminRoleSuperAdmin:         "developer",
 	}
-	AllRoles = []AdminRole{AdminRoleResearcher, AdminRoleParticipantManager, AdminRoleUserManager}
+	AllRoles = []AdminRole{AdminRoleResearcher, AdminRoleDataSteward, AdminRoleParticipantManager, AdminRoleUserManager}
 )
 
 type AdminUser struct {
@@ -83,6 +87,9 @@ func (u *AdminUser) HasRole(role AdminRole) bool {
 	if u.RoleStorage == AdminRoleSuperAdmin {
 		return true
 	}
+	if role == AdminRoleResearcher && strings.Contains(u.RoleStorage, AdminRoleDataSteward) {
+		return true
+	}
 	return strings.Contains(u.RoleStorage, role)
 }
 
@@ -167,6 +174,38 @@ func LookupAdminUser(email string) (*AdminUser, error) {
 	return nil, nil
 }
 
+// GetStudyAdminEmails returns the addresses of the study's administrator
+// and of every study user who has the given role.
+func GetStudyAdminEmails(studyId string, role AdminRole) ([]string, error) {
+	study, err := GetStudy(studyId)
+	if err != nil {
+		return nil, err
+	}
+	if study == nil {
+		return nil, nil
+	}
+	users, err := GetAllAdminUsers()
+	if err != nil {
+		return nil, err
+	}
+	var emails []string
+	seen := make(map[string]bool)
+	add := func(email string) {
+		if email != "" && !seen[strings.ToLower(email)] {
+			seen[strings.ToLower(email)] = true
+			emails = append(emails, email)
+		}
+	}
+	add(study.AdminEmail)
+	for _, u := range users {
+		if u.StudyId != studyId || u.HasRole(AdminRoleSuperAdmin) || !u.HasRole(role) {
+			continue
+		}
+		add(u.Email)
+	}
+	return emails, nil
+}
+
 func EnsureSuperAdmin(email string) error {
 	u, err := LookupAdminUser(email)
 	if err != nil {
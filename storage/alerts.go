@@ -0,0 +1,258 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"github.com/whisper-project/in-my-voice.server.golang/services"
+	"go.uber.org/zap"
+)
+
+// A UsageAlert records a study participant crossing one of their study's usage thresholds.
+type UsageAlert struct {
+	StudyId    string
+	Upn        string
+	Threshold  int64
+	PctUsed    int64
+	UsedChars  int64
+	LimitChars int64
+	NextRenew  int64 // epoch seconds
+	When       int64 // Unix time in milliseconds
+}
+
+func (a *UsageAlert) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(a); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (a *UsageAlert) FromRedis(b []byte) error {
+	*a = UsageAlert{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(a)
+}
+
+func (a *UsageAlert) describe() string {
+	renew := time.Unix(a.NextRenew, 0).In(AdminTZ).Format("01/02/2006")
+	return fmt.Sprintf("Participant %s has used %d%% of their ElevenLabs character quota (%d of %d), "+
+		"which reaches the %d%% alert threshold. Their quota renews on %s.",
+		a.Upn, a.PctUsed, a.UsedChars, a.LimitChars, a.Threshold, renew)
+}
+
+// SentUsageAlerts is the set of thresholds already alerted for a profile in a billing period.
+//
+// The key is <profileId>:<period>, where the period is the epoch seconds of the next renewal.
+type SentUsageAlerts string
+
+func (s SentUsageAlerts) StoragePrefix() string {
+	return "sent-usage-alerts:"
+}
+func (s SentUsageAlerts) StorageId() string {
+	return string(s)
+}
+
+// PendingUsageAlerts is the list of alerts for a study that are waiting for its daily digest.
+type PendingUsageAlerts string
+
+func (p PendingUsageAlerts) StoragePrefix() string {
+	return "pending-usage-alerts:"
+}
+func (p PendingUsageAlerts) StorageId() string {
+	return string(p)
+}
+
+// usageDigestSent is an expiring key that is present if a study's digest was sent in the last day.
+type usageDigestSent string
+
+func (u usageDigestSent) StoragePrefix() string {
+	return "usage-digest-sent:"
+}
+func (u usageDigestSent) StorageId() string {
+	return string(u)
+}
+
+// ParseAlertThresholds parses a comma-separated list of percentages.
+func ParseAlertThresholds(s string) ([]int64, error) {
+	var thresholds []int64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(part), "%"))
+		if part == "" {
+			continue
+		}
+		t, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || t < 1 || t > 100 {
+			return nil, fmt.Errorf("invalid threshold: %q", part)
+		}
+		if !slices.Contains(thresholds, t) {
+			thresholds = append(thresholds, t)
+		}
+	}
+	slices.Sort(thresholds)
+	return thresholds, nil
+}
+
+// checkUsageAlerts lets the study admins know when a participant's usage reaches
+// one of the study's alert thresholds. Each threshold is alerted at most once
+// per billing period, and only the highest newly-reached threshold is reported.
+func checkUsageAlerts(ctx context.Context, s *SpeechMonitor, curPct int64) {
+	studyId, upn, err := GetProfileStudyMembership(s.ProfileId)
+	if err != nil || studyId == "" {
+		return
+	}
+	study, err := GetStudy(studyId)
+	if err != nil || study == nil || len(study.AlertThresholds) == 0 {
+		return
+	}
+	sent := SentUsageAlerts(s.ProfileId + ":" + strconv.FormatInt(s.NextRenew, 10))
+	var reached []string
+	var highest int64
+	for _, t := range study.AlertThresholds {
+		if curPct < t {
+			continue
+		}
+		done, err := platform.IsMember(ctx, sent, strconv.FormatInt(t, 10))
+		if err != nil {
+			sLog().Error("db failure on usage alert lookup",
+				zap.String("profileId", s.ProfileId), zap.Error(err))
+			return
+		}
+		if !done {
+			reached = append(reached, strconv.FormatInt(t, 10))
+			highest = t
+		}
+	}
+	if len(reached) == 0 {
+		return
+	}
+	alert := &UsageAlert{
+		StudyId:    studyId,
+		Upn:        upn,
+		Threshold:  highest,
+		PctUsed:    curPct,
+		UsedChars:  s.UsedChars,
+		LimitChars: s.LimitChars,
+		NextRenew:  s.NextRenew,
+		When:       time.Now().UnixMilli(),
+	}
+	if err := platform.AddMembers(ctx, sent, reached...); err != nil {
+		sLog().Error("db failure on usage alert record",
+			zap.String("profileId", s.ProfileId), zap.Error(err))
+		return
+	}
+	if s.NextRenew > 0 {
+		_ = platform.SetExpirationAt(ctx, sent, time.Unix(s.NextRenew, 0).Add(24*time.Hour))
+	}
+	if study.AlertDigest {
+		b, err := alert.ToRedis()
+		if err != nil {
+			sLog().Error("serialization failure on usage alert", zap.Any("alert", alert), zap.Error(err))
+			return
+		}
+		if err := platform.PushRange(ctx, PendingUsageAlerts(studyId), false, string(b)); err != nil {
+			sLog().Error("db failure on usage alert queue", zap.Any("alert", alert), zap.Error(err))
+			_ = platform.RemoveMembers(ctx, sent, reached...)
+			return
+		}
+	} else {
+		// the mail server can be slow, so don't hold up the monitor loop waiting for it.
+		// If the send fails, forget the thresholds so the next check alerts them again.
+		go func() {
+			if err := sendUsageAlerts(study, []*UsageAlert{alert}); err != nil {
+				_ = platform.RemoveMembers(sCtx(), sent, reached...)
+			}
+		}()
+	}
+	sLog().Info("usage alert generated",
+		zap.String("studyId", studyId), zap.String("upn", upn),
+		zap.Int64("threshold", highest), zap.Bool("digest", study.AlertDigest))
+}
+
+func sendUsageAlerts(study *Study, alerts []*UsageAlert) error {
+	emails, err := GetStudyAdminEmails(study.Id, AdminRoleParticipantManager)
+	if err != nil {
+		return err
+	}
+	lines := make([]string, 0, len(alerts))
+	for _, a := range alerts {
+		lines = append(lines, a.describe())
+	}
+	subject := fmt.Sprintf("In My Voice usage alert for %s", study.Name)
+	if len(alerts) > 1 {
+		subject = fmt.Sprintf("In My Voice daily usage alerts for %s", study.Name)
+	}
+	body := strings.Join(lines, "\n\n") + "\n"
+	if err := services.SendAlertViaEmail(emails, subject, body); err != nil {
+		sLog().Error("failed to send usage alert email",
+			zap.String("studyId", study.Id), zap.Strings("emails", emails), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// SendUsageAlertDigests sends each study with pending alerts its digest,
+// as long as it hasn't already been sent one in the last day.
+func SendUsageAlertDigests(ctx context.Context) error {
+	var studyIds []string
+	collect := func(id string) error {
+		studyIds = append(studyIds, id)
+		return nil
+	}
+	if err := platform.MapKeys(ctx, collect, PendingUsageAlerts("")); err != nil {
+		sLog().Error("db failure on pending usage alert scan", zap.Error(err))
+		return err
+	}
+	for _, studyId := range studyIds {
+		if sent, err := platform.FetchString(ctx, usageDigestSent(studyId)); err != nil || sent != "" {
+			continue
+		}
+		study, err := GetStudy(studyId)
+		if err != nil {
+			continue
+		}
+		if study == nil {
+			_ = platform.DeleteStorage(ctx, PendingUsageAlerts(studyId))
+			continue
+		}
+		vals, err := platform.FetchRange(ctx, PendingUsageAlerts(studyId), 0, -1)
+		if err != nil {
+			sLog().Error("db failure on pending usage alert fetch", zap.String("studyId", studyId), zap.Error(err))
+			continue
+		}
+		alerts := make([]*UsageAlert, 0, len(vals))
+		for _, v := range vals {
+			a := new(UsageAlert)
+			if err := a.FromRedis([]byte(v)); err != nil {
+				sLog().Error("deserialization failure on usage alert", zap.String("studyId", studyId), zap.Error(err))
+				continue
+			}
+			alerts = append(alerts, a)
+		}
+		if len(alerts) > 0 {
+			if err := sendUsageAlerts(study, alerts); err != nil {
+				continue
+			}
+		}
+		// alerts are only ever pushed on the right, so trimming the fetched ones from
+		// the left keeps any that were pushed while the digest was being sent
+		if err := platform.TrimRange(ctx, PendingUsageAlerts(studyId), int64(len(vals)), -1); err != nil {
+			sLog().Error("db failure on pending usage alert trim", zap.String("studyId", studyId), zap.Error(err))
+		}
+		if err := platform.StoreString(ctx, usageDigestSent(studyId), time.Now().Format(time.RFC3339)); err == nil {
+			_ = platform.SetExpiration(ctx, usageDigestSent(studyId), 23*60*60)
+		}
+	}
+	return nil
+}
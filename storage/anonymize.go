@@ -0,0 +1,201 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+// An anonymizer rewrites identifying values consistently: the same
+// value with the same salt is always rewritten the same way.
+type anonymizer struct {
+	salt string
+}
+
+func (a anonymizer) hash(s string) string {
+	return platform.MakeSha1(a.salt + s)[:12]
+}
+
+func (a anonymizer) upn(upn string) string {
+	if upn == "" {
+		return ""
+	}
+	return "upn-" + a.hash("upn:"+upn)
+}
+
+func (a anonymizer) upns(upns []string) []string {
+	result := make([]string, 0, len(upns))
+	for _, upn := range upns {
+		result = append(result, a.upn(upn))
+	}
+	return result
+}
+
+func (a anonymizer) apiKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	return "anonymized-" + a.hash("key:"+key)
+}
+
+func (a anonymizer) email(email string) string {
+	if email == "" {
+		return ""
+	}
+	return "admin-" + a.hash("email:"+email) + "@example.com"
+}
+
+// answer scrambles a questionnaire answer, unless it's a number or yes/no,
+// which can't identify anyone.
+func (a anonymizer) answer(answer string) string {
+	if _, err := strconv.ParseInt(answer, 10, 64); err == nil || answer == "yes" || answer == "no" {
+		return answer
+	}
+	return platform.ScrambleText(answer, a.salt)
+}
+
+// jsonStrings scrambles every string value (but not object key) in a JSON document.
+// If the document isn't valid JSON, it's scrambled as text.
+func (a anonymizer) jsonStrings(doc string) string {
+	var v any
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		return platform.ScrambleText(doc, a.salt)
+	}
+	var scramble func(v any) any
+	scramble = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			return platform.ScrambleText(v, a.salt)
+		case []any:
+			for i, e := range v {
+				v[i] = scramble(e)
+			}
+		case map[string]any:
+			for k, e := range v {
+				v[k] = scramble(e)
+			}
+		}
+		return v
+	}
+	b, err := json.Marshal(scramble(v))
+	if err != nil {
+		return platform.ScrambleText(doc, a.salt)
+	}
+	return string(b)
+}
+
+// AnonymizeObjects rewrites dumped objects in place so they can be loaded into
+// another environment without exposing participants. API keys are replaced,
+// UPNs and admin emails are consistently hashed, and phrases, free-text
+// questionnaire answers, participant memos, withdrawal reasons, study messages,
+// and problem report text are scrambled (preserving their length). The salt determines the hashing and scrambling.
+func AnonymizeObjects(m platform.ObjectMap, salt string) {
+	a := anonymizer{salt: salt}
+	for _, objs := range m {
+		for _, obj := range objs {
+			switch o := obj.(type) {
+			case *Study:
+				o.AdminEmail = a.email(o.AdminEmail)
+				if o.PhraseHashKey != "" {
+					o.PhraseHashKey = a.hash(o.PhraseHashKey)
+				}
+			case *StudyParticipant:
+				o.Upn = a.upn(o.Upn)
+				o.ApiKey = a.apiKey(o.ApiKey)
+				o.Memo = platform.ScrambleText(o.Memo, salt)
+				// the same stratum always scrambles the same way, so strata are preserved
+				o.Stratum = platform.ScrambleText(o.Stratum, salt)
+			case *StudyLineStats:
+				o.Upn = a.upn(o.Upn)
+				for i := range o.Stats {
+					o.Stats[i].Upn = a.upn(o.Stats[i].Upn)
+				}
+			case *StudyPhraseStat:
+				o.Stat.Content = platform.ScrambleText(o.Stat.Content, salt)
+				o.Stat.Hash = phraseHash(o.Stat.Content)
+			case *StudyParticipantPhraseStats:
+				o.Upn = a.upn(o.Upn)
+				stats := make(map[string]PhraseStat, len(o.Stats))
+				for field, stat := range o.Stats {
+					day, _, _ := strings.Cut(field, "+")
+					stat.Content = platform.ScrambleText(stat.Content, salt)
+					stat.Hash = phraseHash(stat.Content)
+					stats[phraseBucketField(day, stat.Hash)] = stat
+				}
+				o.Stats = stats
+			case *StudyQuestionnaireResponses:
+				o.Upn = a.upn(o.Upn)
+				for i := range o.Responses {
+					o.Responses[i].Upn = o.Upn
+					for id, answer := range o.Responses[i].Answers {
+						o.Responses[i].Answers[id] = a.answer(answer)
+					}
+				}
+			case *ConsentDocument:
+				o.Author = a.email(o.Author)
+			case *StudyConsentRecords:
+				o.Upn = a.upn(o.Upn)
+				for i := range o.Records {
+					o.Records[i].Upn = o.Upn
+				}
+			case *StudyWithdrawals:
+				for _, w := range o.Withdrawals {
+					w.Upn = a.upn(w.Upn)
+					if w.By != WithdrawnByParticipant {
+						w.By = a.email(w.By)
+					}
+					w.Reason = platform.ScrambleText(w.Reason, salt)
+				}
+			case *StudyArmBlocks:
+				// strata are scrambled just as they are on participants
+				blocks := make(map[string]string, len(o.Blocks))
+				for stratum, block := range o.Blocks {
+					blocks[platform.ScrambleText(stratum, salt)] = block
+				}
+				o.Blocks = blocks
+			case *StudyMessage:
+				o.Title = platform.ScrambleText(o.Title, salt)
+				o.Body = platform.ScrambleText(o.Body, salt)
+				o.Author = a.email(o.Author)
+				recipients := make(map[string]string, len(o.Recipients))
+				for upn, profileId := range o.Recipients {
+					recipients[a.upn(upn)] = profileId
+				}
+				o.Recipients = recipients
+			case *ProfileMessages:
+				// scrambled just as the study messages they copy are
+				for _, m := range o.Messages {
+					m.Title = platform.ScrambleText(m.Title, salt)
+					m.Body = platform.ScrambleText(m.Body, salt)
+				}
+			case *StudyReport:
+				o.Upns = a.upns(o.Upns)
+			case *AdminUser:
+				o.Email = a.email(o.Email)
+			case *SpeechSettings:
+				o.ApiKey = a.apiKey(o.ApiKey)
+			case *FavoritesSettings:
+				*o = *NewFavoritesSettings(o.ProfileId, a.jsonStrings(o.Settings))
+			case *ProfileFavoritesHistory:
+				for _, v := range o.Versions {
+					v.Settings = a.jsonStrings(v.Settings)
+					v.ETag = NewFavoritesSettings(o.ProfileId, v.Settings).ETag
+				}
+			case *MonitorRecord:
+				o.Monitor.ApiKey = a.apiKey(o.Monitor.ApiKey)
+			case *ProblemReport:
+				o.Upn = a.upn(o.Upn)
+				o.Message = platform.ScrambleText(o.Message, salt)
+				o.Detail = platform.ScrambleText(o.Detail, salt)
+			}
+		}
+	}
+}
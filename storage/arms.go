@@ -0,0 +1,349 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+type ArmVoice = string
+
+const (
+	ArmVoiceParticipant ArmVoice = "participant" // the participant's own ElevenLabs voice, if they have one
+	ArmVoiceDefault     ArmVoice = "default"     // the app's default voice
+)
+
+type ArmRandomization = string
+
+const (
+	ArmRandomizationBlock      ArmRandomization = "block"      // one sequence of blocks for the whole study
+	ArmRandomizationStratified ArmRandomization = "stratified" // a separate sequence of blocks for each stratum
+)
+
+// A StudyArm is one of the groups a study's participants are randomized into.
+// Participants in the arm use its voice and get its feature flag values.
+type StudyArm struct {
+	Name  string
+	Ratio int64 // the arm's share of each block, relative to the other arms
+	Voice ArmVoice
+	Flags map[string]string // feature flag values, which take precedence over all but profile overrides
+}
+
+// The ArmBlocks of a study ID maps from stratum to the arm names left in its current block,
+// separated by commas. Studies that aren't stratified use the empty stratum.
+type ArmBlocks string
+
+func (b ArmBlocks) StoragePrefix() string {
+	return "arm-blocks:"
+}
+func (b ArmBlocks) StorageId() string {
+	return string(b)
+}
+
+var (
+	InvalidStudyArmsError = errors.New("invalid study arms")
+	StudyArmInUseError    = errors.New("study arm has assigned participants")
+)
+
+// ParseStudyArms reads arms written one per line as "name ratio voice flag=value ...",
+// such as "cloned 1 participant newVoiceUI=true". Blank lines are ignored.
+func ParseStudyArms(text string) ([]StudyArm, error) {
+	var arms []StudyArm
+	for i, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%w: line %d needs a name, ratio, and voice", InvalidStudyArmsError, i+1)
+		}
+		ratio, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || ratio < 1 {
+			return nil, fmt.Errorf("%w: line %d ratio must be a positive number", InvalidStudyArmsError, i+1)
+		}
+		arm := StudyArm{Name: fields[0], Ratio: ratio, Voice: fields[2]}
+		for _, field := range fields[3:] {
+			name, value, found := strings.Cut(field, "=")
+			if !found || name == "" {
+				return nil, fmt.Errorf("%w: line %d flags must be written as name=value", InvalidStudyArmsError, i+1)
+			}
+			if arm.Flags == nil {
+				arm.Flags = make(map[string]string)
+			}
+			arm.Flags[name] = value
+		}
+		arms = append(arms, arm)
+	}
+	return arms, nil
+}
+
+// FormatStudyArms writes arms in the form read by ParseStudyArms.
+func FormatStudyArms(arms []StudyArm) string {
+	lines := make([]string, 0, len(arms))
+	for _, arm := range arms {
+		fields := []string{arm.Name, strconv.FormatInt(arm.Ratio, 10), arm.Voice}
+		names := make([]string, 0, len(arm.Flags))
+		for name := range arm.Flags {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			fields = append(fields, name+"="+arm.Flags[name])
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SetArms checks and changes the study's arms and how participants are randomized
+// into them. Arms that participants have been assigned to can't be removed. Any
+// partly used blocks are discarded, so the new ratios take effect immediately.
+// The study must be saved for the change to take effect.
+func (s *Study) SetArms(arms []StudyArm, randomization ArmRandomization, blockSize int64, assignOnCreate bool) error {
+	var total int64
+	for i, arm := range arms {
+		if strings.Contains(arm.Name, ",") {
+			return fmt.Errorf("%w: arm names can't contain commas", InvalidStudyArmsError)
+		}
+		if slices.ContainsFunc(arms[:i], func(a StudyArm) bool { return a.Name == arm.Name }) {
+			return fmt.Errorf("%w: there are two arms named %q", InvalidStudyArmsError, arm.Name)
+		}
+		if arm.Voice != ArmVoiceParticipant && arm.Voice != ArmVoiceDefault {
+			return fmt.Errorf("%w: arm %q voice must be %q or %q",
+				InvalidStudyArmsError, arm.Name, ArmVoiceParticipant, ArmVoiceDefault)
+		}
+		for name, value := range arm.Flags {
+			f, err := GetFeatureFlag(name)
+			if err != nil {
+				return err
+			}
+			if f == nil {
+				return fmt.Errorf("%w: arm %q sets unknown flag %q", InvalidStudyArmsError, arm.Name, name)
+			}
+			if _, err := f.ParseValue(value); err != nil {
+				return fmt.Errorf("%w: arm %q flag %q: %v", InvalidStudyArmsError, arm.Name, name, err)
+			}
+		}
+		total += arm.Ratio
+	}
+	if randomization != ArmRandomizationBlock && randomization != ArmRandomizationStratified {
+		return fmt.Errorf("%w: unknown randomization %q", InvalidStudyArmsError, randomization)
+	}
+	if blockSize < 0 || (blockSize > 0 && total > 0 && blockSize%total != 0) {
+		return fmt.Errorf("%w: the block size must be a multiple of %d, the sum of the ratios", InvalidStudyArmsError, total)
+	}
+	participants, err := GetAllStudyParticipants(s.Id)
+	if err != nil {
+		return err
+	}
+	for _, p := range participants {
+		if p.Arm != "" && !slices.ContainsFunc(arms, func(a StudyArm) bool { return a.Name == p.Arm }) {
+			return fmt.Errorf("%w: %q", StudyArmInUseError, p.Arm)
+		}
+	}
+	if err := platform.DeleteStorage(sCtx(), ArmBlocks(s.Id)); err != nil {
+		sLog().Error("db failure on arm blocks delete", zap.String("studyId", s.Id), zap.Error(err))
+		return err
+	}
+	s.Arms = arms
+	s.ArmRandomization = randomization
+	s.ArmBlockSize = blockSize
+	s.ArmAssignOnCreate = assignOnCreate
+	return nil
+}
+
+// GetArm returns the study arm with the given name, or nil if there isn't one.
+func (s *Study) GetArm(name string) *StudyArm {
+	if i := slices.IndexFunc(s.Arms, func(a StudyArm) bool { return a.Name == name }); i >= 0 {
+		return &s.Arms[i]
+	}
+	return nil
+}
+
+// UsesParticipantVoice returns whether the participant's own ElevenLabs
+// settings should be applied, which depends on the participant's arm.
+func (s *Study) UsesParticipantVoice(p *StudyParticipant) bool {
+	if arm := s.GetArm(p.Arm); arm != nil {
+		return arm.Voice == ArmVoiceParticipant
+	}
+	return true
+}
+
+// newArmBlock returns a shuffled block of arm names with each arm's share of the block size.
+func (s *Study) newArmBlock() []string {
+	var total int64
+	for _, arm := range s.Arms {
+		total += arm.Ratio
+	}
+	repeats := int64(1)
+	if s.ArmBlockSize > 0 {
+		repeats = s.ArmBlockSize / total
+	}
+	var block []string
+	for _, arm := range s.Arms {
+		for range arm.Ratio * repeats {
+			block = append(block, arm.Name)
+		}
+	}
+	rand.Shuffle(len(block), func(i, j int) { block[i], block[j] = block[j], block[i] })
+	return block
+}
+
+// nextArm takes the next arm from the current block of the stratum, starting a new block if needed.
+func (s *Study) nextArm(stratum string) (string, error) {
+	if s.ArmRandomization != ArmRandomizationStratified {
+		stratum = ""
+	}
+	var arm string
+	update := func(old string) (string, bool, error) {
+		var block []string
+		if old != "" {
+			block = strings.Split(old, ",")
+		}
+		if len(block) == 0 {
+			block = s.newArmBlock()
+		}
+		arm = block[0]
+		return strings.Join(block[1:], ","), true, nil
+	}
+	if _, err := platform.MapUpdate(sCtx(), ArmBlocks(s.Id), stratum, update); err != nil {
+		sLog().Error("db failure on arm block update",
+			zap.String("studyId", s.Id), zap.String("stratum", stratum), zap.Error(err))
+		return "", err
+	}
+	return arm, nil
+}
+
+// returnArm puts an arm taken by nextArm back at the front of the stratum's block,
+// so it goes to the next participant instead.
+func (s *Study) returnArm(stratum, arm string) error {
+	if s.ArmRandomization != ArmRandomizationStratified {
+		stratum = ""
+	}
+	update := func(old string) (string, bool, error) {
+		if old == "" {
+			return arm, true, nil
+		}
+		return arm + "," + old, true, nil
+	}
+	if _, err := platform.MapUpdate(sCtx(), ArmBlocks(s.Id), stratum, update); err != nil {
+		sLog().Error("db failure on arm block return",
+			zap.String("studyId", s.Id), zap.String("stratum", stratum), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// AssignStudyArm randomizes the participant into one of the study's arms, if the
+// study has arms and the participant doesn't already have one. The participant's
+// stratum should be set before this is called. If the participant is enrolled,
+// the arm's voice is applied to their profile and their clients are told about it.
+func AssignStudyArm(study *Study, p *StudyParticipant) error {
+	assigned, err := assignStudyArm(study, p)
+	if err != nil || !assigned || p.ProfileId == "" || p.Finished > 0 {
+		return err
+	}
+	_, err = ApplyArmVoice(study, p, "none")
+	return err
+}
+
+// assignStudyArm does the work of AssignStudyArm, except for applying the arm's voice,
+// and returns whether the participant was assigned an arm.
+func assignStudyArm(study *Study, p *StudyParticipant) (bool, error) {
+	if len(study.Arms) == 0 || p.Arm != "" {
+		return false, nil
+	}
+	arm, err := study.nextArm(p.Stratum)
+	if err != nil {
+		return false, err
+	}
+	assigned := false
+	err = p.update(func(p *StudyParticipant) {
+		// the update may be retried, so only the committed attempt decides
+		assigned = false
+		// someone else may have assigned the participant first
+		if p.Arm == "" {
+			p.Arm = arm
+			p.ArmAssigned = time.Now().UnixMilli()
+			assigned = true
+		}
+	})
+	if !assigned {
+		// the arm wasn't used, so it must go to someone else to keep the block balanced
+		if returnErr := study.returnArm(p.Stratum, arm); returnErr != nil && err == nil {
+			err = returnErr
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	if assigned {
+		sLog().Info("participant assigned to arm", zap.String("studyId", study.Id),
+			zap.String("upn", p.Upn), zap.String("stratum", p.Stratum), zap.String("arm", arm))
+		// the arm may set flag values
+		if p.ProfileId != "" {
+			if err := profileClientConfigDidChange(p.ProfileId); err != nil {
+				return true, err
+			}
+		}
+	}
+	return assigned, nil
+}
+
+// ApplyArmVoice makes the enrolled participant's speech settings match their arm.
+// Participants in arms that use their own voice get their ElevenLabs settings, if they
+// have any, and participants in arms that use the default voice have their ElevenLabs
+// settings removed. If the settings change, the profile's clients other than the given
+// one are told to update, and it returns true.
+func ApplyArmVoice(study *Study, p *StudyParticipant, clientId string) (bool, error) {
+	var updated bool
+	if study.UsesParticipantVoice(p) {
+		if p.ApiKey == "" {
+			return false, nil
+		}
+		var err error
+		if updated, err = UpdateSpeechSettings(p.ProfileId, p.ApiKey, p.VoiceId, p.VoiceName, ""); err != nil {
+			return false, err
+		}
+	} else {
+		settings, err := GetSpeechSettings(p.ProfileId)
+		if err != nil {
+			return false, err
+		}
+		if settings != nil {
+			if err := DeleteSpeechSettings(p.ProfileId); err != nil {
+				return false, err
+			}
+			updated = true
+		}
+	}
+	if updated {
+		sLog().Info("arm voice applied to profile", zap.String("studyId", study.Id),
+			zap.String("upn", p.Upn), zap.String("arm", p.Arm), zap.String("profileId", p.ProfileId))
+		// ignore notification errors, as clients also update at launch
+		_ = ProfileClientSpeechDidUpdate(p.ProfileId, clientId)
+	}
+	return updated, nil
+}
+
+// UpdateStratum changes the participant's stratum, which is only allowed before they're assigned an arm.
+func (s *StudyParticipant) UpdateStratum(stratum string) error {
+	return s.update(func(p *StudyParticipant) {
+		if p.Arm == "" {
+			p.Stratum = stratum
+		}
+	})
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+func TestParseStudyArms(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []StudyArm
+		err  bool
+	}{
+		{"empty", "", nil, false},
+		{"blank lines", "\n \n", nil, false},
+		{"one", "control 1 default", []StudyArm{{Name: "control", Ratio: 1, Voice: ArmVoiceDefault}}, false},
+		{"flags", "cloned 2 participant newVoiceUI=true level=", []StudyArm{{
+			Name: "cloned", Ratio: 2, Voice: ArmVoiceParticipant,
+			Flags: map[string]string{"newVoiceUI": "true", "level": ""},
+		}}, false},
+		{"several", "a 1 default\n\n  b   3 participant  ", []StudyArm{
+			{Name: "a", Ratio: 1, Voice: ArmVoiceDefault}, {Name: "b", Ratio: 3, Voice: ArmVoiceParticipant},
+		}, false},
+		{"missing voice", "control 1", nil, true},
+		{"bad ratio", "control one default", nil, true},
+		{"zero ratio", "control 0 default", nil, true},
+		{"negative ratio", "control -1 default", nil, true},
+		{"bad flag", "control 1 default newVoiceUI", nil, true},
+		{"unnamed flag", "control 1 default =true", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStudyArms(tt.text)
+			if tt.err {
+				if !errors.Is(err, InvalidStudyArmsError) {
+					t.Errorf("ParseStudyArms(%q) returned %v, expected InvalidStudyArmsError", tt.text, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseStudyArms(%q) = %#v (%v), expected %#v", tt.text, got, err, tt.want)
+			}
+			if again, _ := ParseStudyArms(FormatStudyArms(got)); !reflect.DeepEqual(again, got) {
+				t.Errorf("Formatted arms parse as %#v, expected %#v", again, got)
+			}
+		})
+	}
+}
+
+func TestSetArmsValidation(t *testing.T) {
+	flag := "test-" + uuid.NewString()
+	study := NewStudy("test", "admin@example.com")
+	defer func() {
+		_ = DeleteFeatureFlag(flag)
+		_ = platform.DeleteStorage(sCtx(), ParticipantIndex(study.Id))
+	}()
+	if err := SaveFeatureFlag(&FeatureFlag{Name: flag, Type: FlagTypeBool, Default: "false"}, "admin@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	control := StudyArm{Name: "control", Ratio: 1, Voice: ArmVoiceDefault}
+	cloned := StudyArm{Name: "cloned", Ratio: 2, Voice: ArmVoiceParticipant, Flags: map[string]string{flag: "true"}}
+	tests := []struct {
+		name          string
+		arms          []StudyArm
+		randomization ArmRandomization
+		blockSize     int64
+		err           error
+	}{
+		{"no arms", nil, ArmRandomizationBlock, 0, nil},
+		{"valid", []StudyArm{control, cloned}, ArmRandomizationStratified, 6, nil},
+		{"comma in name", []StudyArm{{Name: "a,b", Ratio: 1, Voice: ArmVoiceDefault}}, ArmRandomizationBlock, 0, InvalidStudyArmsError},
+		{"duplicate name", []StudyArm{control, control}, ArmRandomizationBlock, 0, InvalidStudyArmsError},
+		{"unknown voice", []StudyArm{{Name: "a", Ratio: 1, Voice: "robot"}}, ArmRandomizationBlock, 0, InvalidStudyArmsError},
+		{"unknown flag", []StudyArm{{Name: "a", Ratio: 1, Voice: ArmVoiceDefault,
+			Flags: map[string]string{"no-such-" + flag: "true"}}}, ArmRandomizationBlock, 0, InvalidStudyArmsError},
+		{"bad flag value", []StudyArm{{Name: "a", Ratio: 1, Voice: ArmVoiceDefault,
+			Flags: map[string]string{flag: "maybe"}}}, ArmRandomizationBlock, 0, InvalidStudyArmsError},
+		{"unknown randomization", []StudyArm{control}, "coin", 0, InvalidStudyArmsError},
+		{"negative block size", []StudyArm{control, cloned}, ArmRandomizationBlock, -3, InvalidStudyArmsError},
+		{"uneven block size", []StudyArm{control, cloned}, ArmRandomizationBlock, 4, InvalidStudyArmsError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := study.SetArms(tt.arms, tt.randomization, tt.blockSize, false)
+			if tt.err == nil && err != nil {
+				t.Errorf("SetArms failed: %v", err)
+			} else if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("SetArms returned %v, expected %v", err, tt.err)
+			}
+		})
+	}
+	// arms that participants have been assigned to can't be removed
+	p, err := CreateStudyParticipant(study.Id, "upn-"+uuid.NewString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := study.SetArms([]StudyArm{control, cloned}, ArmRandomizationBlock, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.update(func(p *StudyParticipant) { p.Arm = "cloned" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := study.SetArms([]StudyArm{control}, ArmRandomizationBlock, 0, false); !errors.Is(err, StudyArmInUseError) {
+		t.Errorf("Removing an assigned arm returned %v, expected StudyArmInUseError", err)
+	}
+}
+
+func TestNewArmBlock(t *testing.T) {
+	tests := []struct {
+		name      string
+		ratios    []int64
+		blockSize int64
+		want      []int
+	}{
+		{"one of each", []int64{1, 1}, 0, []int{1, 1}},
+		{"ratios", []int64{1, 2, 3}, 0, []int{1, 2, 3}},
+		{"block of ratios", []int64{1, 2}, 3, []int{1, 2}},
+		{"multiple blocks", []int64{1, 2}, 9, []int{3, 6}},
+		{"even multiple", []int64{1, 1}, 8, []int{4, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Study{ArmBlockSize: tt.blockSize}
+			for i, ratio := range tt.ratios {
+				s.Arms = append(s.Arms, StudyArm{Name: string(rune('a' + i)), Ratio: ratio, Voice: ArmVoiceDefault})
+			}
+			block := s.newArmBlock()
+			got := make([]int, len(s.Arms))
+			for _, name := range block {
+				got[slices.IndexFunc(s.Arms, func(a StudyArm) bool { return a.Name == name })]++
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Block %v has arm counts %v, expected %v", block, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssignStudyArmConcurrently(t *testing.T) {
+	_, _ = platform.GetDb() // connect before going parallel
+	study := NewStudy("test", "admin@example.com")
+	study.Arms = []StudyArm{{Name: "a", Ratio: 1, Voice: ArmVoiceDefault}, {Name: "b", Ratio: 1, Voice: ArmVoiceDefault}}
+	study.ArmRandomization = ArmRandomizationBlock
+	upn := "upn-" + uuid.NewString()
+	defer func() {
+		_ = platform.DeleteStorage(sCtx(), ParticipantIndex(study.Id))
+		_ = platform.DeleteStorage(sCtx(), ArmBlocks(study.Id))
+	}()
+	if _, err := CreateStudyParticipant(study.Id, upn); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := GetStudyParticipant(study.Id, upn)
+			if err != nil || p == nil {
+				t.Errorf("GetStudyParticipant failed: %v", err)
+				return
+			}
+			if err := AssignStudyArm(study, p); err != nil {
+				t.Errorf("AssignStudyArm failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	p, err := GetStudyParticipant(study.Id, upn)
+	if err != nil || p == nil || p.Arm == "" {
+		t.Fatalf("Participant after parallel assignments is %#v (%v), expected an arm", p, err)
+	}
+	// the slots taken by the assignments that lost must have been put back,
+	// so together with the assigned arm they make up whole blocks
+	left, err := platform.MapGet(sCtx(), ArmBlocks(study.Id), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arms := append(strings.Split(left, ","), p.Arm)
+	if a, b := strings.Count(strings.Join(arms, ","), "a"), strings.Count(strings.Join(arms, ","), "b"); a != b {
+		t.Errorf("Assigned arm %s and remaining block %q aren't balanced", p.Arm, left)
+	}
+}
+
+func TestApplyArmVoice(t *testing.T) {
+	study := NewStudy("test", "admin@example.com")
+	study.Arms = []StudyArm{
+		{Name: "cloned", Ratio: 1, Voice: ArmVoiceParticipant},
+		{Name: "control", Ratio: 1, Voice: ArmVoiceDefault},
+	}
+	profileId := uuid.NewString()
+	defer func() {
+		_ = DeleteSpeechSettings(profileId)
+		_ = platform.DeleteStorage(sCtx(), NotifiedSpeechClients(profileId))
+	}()
+	p := &StudyParticipant{StudyId: study.Id, Upn: "upn", ProfileId: profileId, Arm: "cloned",
+		ApiKey: "key", VoiceId: "voice", VoiceName: "Voice"}
+	if updated, err := ApplyArmVoice(study, p, "none"); err != nil || !updated {
+		t.Fatalf("Applying a participant voice returned %v (%v), expected an update", updated, err)
+	}
+	if s, err := GetSpeechSettings(profileId); err != nil || s == nil || s.ApiKey != "key" || s.VoiceId != "voice" {
+		t.Errorf("Speech settings after applying participant voice are %#v (%v)", s, err)
+	}
+	if updated, err := ApplyArmVoice(study, p, "none"); err != nil || updated {
+		t.Errorf("Reapplying a participant voice returned %v (%v), expected no update", updated, err)
+	}
+	p.Arm = "control"
+	if updated, err := ApplyArmVoice(study, p, "none"); err != nil || !updated {
+		t.Fatalf("Applying the default voice returned %v (%v), expected an update", updated, err)
+	}
+	if s, err := GetSpeechSettings(profileId); err != nil || s != nil {
+		t.Errorf("Speech settings after applying the default voice are %#v (%v), expected none", s, err)
+	}
+	if updated, err := ApplyArmVoice(study, p, "none"); err != nil || updated {
+		t.Errorf("Reapplying the default voice returned %v (%v), expected no update", updated, err)
+	}
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+// A ConsentDocument is a version of the text that participants agree to when they enroll in a study.
+// Versions are numbered from 1, and the highest numbered version is the current one.
+type ConsentDocument struct {
+	StudyId   string
+	Version   int64
+	Title     string
+	Text      string
+	Author    string // email of the admin who published it
+	Published int64  // Unix time in milliseconds
+}
+
+func (d *ConsentDocument) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(d); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (d *ConsentDocument) FromRedis(b []byte) error {
+	*d = ConsentDocument{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(d)
+}
+
+// The ConsentDocumentIndex of a study ID maps from version number to ConsentDocument.
+type ConsentDocumentIndex string
+
+func (i ConsentDocumentIndex) StoragePrefix() string {
+	return "consent-documents:"
+}
+func (i ConsentDocumentIndex) StorageId() string {
+	return string(i)
+}
+
+// A ConsentRecord is the evidence that a participant accepted a version of a study's consent document.
+type ConsentRecord struct {
+	Upn       string
+	ProfileId string
+	ClientId  string
+	Version   int64
+	Accepted  int64 // Unix time in milliseconds
+}
+
+func (r *ConsentRecord) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(r); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (r *ConsentRecord) FromRedis(b []byte) error {
+	*r = ConsentRecord{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(r)
+}
+
+// The ConsentRecordList of <studyId>+<upn> is all the participant's consent records, oldest first.
+type ConsentRecordList string
+
+func (l ConsentRecordList) StoragePrefix() string {
+	return "consent-records:"
+}
+func (l ConsentRecordList) StorageId() string {
+	return string(l)
+}
+
+// consentRequiredProfiles is the set of enrolled profiles that need to accept
+// a newly published version of their study's consent document.
+var consentRequiredProfiles platform.StorableSet = "consent-required-profiles"
+
+var ConsentVersionNotCurrentError = errors.New("consent version is not current")
+
+// PublishConsentDocument saves a new current version of the study's consent document,
+// and marks all the enrolled participants as needing to accept it.
+func PublishConsentDocument(studyId, author, title, text string) (*ConsentDocument, error) {
+	current, err := GetCurrentConsentDocument(studyId)
+	if err != nil {
+		return nil, err
+	}
+	d := &ConsentDocument{
+		StudyId:   studyId,
+		Version:   1,
+		Title:     title,
+		Text:      text,
+		Author:    author,
+		Published: time.Now().UnixMilli(),
+	}
+	if current != nil {
+		d.Version = current.Version + 1
+	}
+	if err := saveConsentDocument(d); err != nil {
+		return nil, err
+	}
+	participants, err := GetAllStudyParticipants(studyId)
+	if err != nil {
+		return nil, err
+	}
+	var profiles []string
+	for _, p := range participants {
+		if p.ProfileId != "" && p.Started > 0 && p.Finished == 0 {
+			profiles = append(profiles, p.ProfileId)
+		}
+	}
+	if len(profiles) > 0 {
+		if err := platform.AddMembers(sCtx(), consentRequiredProfiles, profiles...); err != nil {
+			sLog().Error("db failure on consent required add",
+				zap.String("studyId", studyId), zap.Error(err))
+			return nil, err
+		}
+	}
+	sLog().Info("consent document published", zap.String("studyId", studyId),
+		zap.Int64("version", d.Version), zap.Int("participants", len(profiles)))
+	return d, nil
+}
+
+func saveConsentDocument(d *ConsentDocument) error {
+	b, err := d.ToRedis()
+	if err != nil {
+		sLog().Error("serialization failure on consent document",
+			zap.String("studyId", d.StudyId), zap.Int64("version", d.Version), zap.Error(err))
+		return err
+	}
+	version := strconv.FormatInt(d.Version, 10)
+	if err := platform.MapSet(sCtx(), ConsentDocumentIndex(d.StudyId), version, string(b)); err != nil {
+		sLog().Error("db failure on consent document save",
+			zap.String("studyId", d.StudyId), zap.Int64("version", d.Version), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// GetAllConsentDocuments returns all versions of the study's consent document, newest first.
+func GetAllConsentDocuments(studyId string) ([]*ConsentDocument, error) {
+	m, err := platform.MapGetAll(sCtx(), ConsentDocumentIndex(studyId))
+	if err != nil {
+		sLog().Error("db failure on consent documents fetch",
+			zap.String("studyId", studyId), zap.Error(err))
+		return nil, err
+	}
+	result := make([]*ConsentDocument, 0, len(m))
+	for version, val := range m {
+		d := new(ConsentDocument)
+		if err := d.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on consent document",
+				zap.String("studyId", studyId), zap.String("version", version), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	slices.SortFunc(result, func(a, b *ConsentDocument) int {
+		return int(b.Version - a.Version)
+	})
+	return result, nil
+}
+
+// GetCurrentConsentDocument returns the latest version of the study's consent document,
+// or nil if the study doesn't have one.
+func GetCurrentConsentDocument(studyId string) (*ConsentDocument, error) {
+	documents, err := GetAllConsentDocuments(studyId)
+	if err != nil || len(documents) == 0 {
+		return nil, err
+	}
+	return documents[0], nil
+}
+
+// RecordParticipantConsent records that the enrolled participant has accepted
+// the given version of the consent document, which must be the current one.
+func RecordParticipantConsent(studyId, upn, profileId, clientId string, version int64) error {
+	current, err := GetCurrentConsentDocument(studyId)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Version != version {
+		return ConsentVersionNotCurrentError
+	}
+	r := ConsentRecord{Upn: upn, ProfileId: profileId, ClientId: clientId, Version: version, Accepted: time.Now().UnixMilli()}
+	if _, err := updateParticipant(studyId, upn, func(p *StudyParticipant, found bool) (bool, error) {
+		if !found || p.ProfileId != profileId {
+			return false, ParticipantNotValidError
+		}
+		p.ConsentVersion, p.ConsentAccepted = r.Version, r.Accepted
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	if err := pushConsentRecords(studyId, upn, r); err != nil {
+		return err
+	}
+	if err := platform.RemoveMembers(sCtx(), consentRequiredProfiles, profileId); err != nil {
+		sLog().Error("db failure on consent required remove",
+			zap.String("profileId", profileId), zap.Error(err))
+		return err
+	}
+	sLog().Info("participant consent recorded", zap.String("studyId", studyId),
+		zap.String("upn", upn), zap.Int64("version", version), zap.String("clientId", clientId))
+	return nil
+}
+
+func pushConsentRecords(studyId, upn string, records ...ConsentRecord) error {
+	vals := make([]string, 0, len(records))
+	for _, r := range records {
+		b, err := r.ToRedis()
+		if err != nil {
+			sLog().Error("serialization failure on consent record",
+				zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+			return err
+		}
+		vals = append(vals, string(b))
+	}
+	if err := platform.PushRange(sCtx(), ConsentRecordList(studyId+"+"+upn), false, vals...); err != nil {
+		sLog().Error("db failure on consent record push",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// FetchConsentRecords returns all the participant's consent records, oldest first.
+func FetchConsentRecords(studyId, upn string) ([]ConsentRecord, error) {
+	vals, err := platform.FetchRange(sCtx(), ConsentRecordList(studyId+"+"+upn), 0, -1)
+	if err != nil {
+		sLog().Error("db failure on consent records fetch",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return nil, err
+	}
+	records := make([]ConsentRecord, 0, len(vals))
+	for _, val := range vals {
+		var r ConsentRecord
+		if err := r.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on consent record",
+				zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// ProfileNeedsConsent returns whether the profile's participant needs to accept
+// a newly published version of their study's consent document.
+func ProfileNeedsConsent(profileId string) (bool, error) {
+	needed, err := platform.IsMember(sCtx(), consentRequiredProfiles, profileId)
+	if err != nil {
+		sLog().Error("db failure on consent required lookup",
+			zap.String("profileId", profileId), zap.Error(err))
+		return false, err
+	}
+	return needed, nil
+}
+
+// clearProfileConsentRequired is used when a profile leaves its study.
+func clearProfileConsentRequired(profileId string) error {
+	if err := platform.RemoveMembers(sCtx(), consentRequiredProfiles, profileId); err != nil {
+		sLog().Error("db failure on consent required remove",
+			zap.String("profileId", profileId), zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+func TestEnrollRecordsConsent(t *testing.T) {
+	studyId, upn := uuid.NewString(), "upn-"+uuid.NewString()
+	profileId, clientId := uuid.NewString(), uuid.NewString()
+	defer func() {
+		_ = platform.DeleteStorage(sCtx(), ParticipantIndex(studyId))
+		_ = platform.DeleteStorage(sCtx(), ConsentDocumentIndex(studyId))
+		_ = platform.DeleteStorage(sCtx(), ConsentRecordList(studyId+"+"+upn))
+		_ = platform.MapRemove(sCtx(), profileParticipantMap, profileId)
+	}()
+	if _, err := CreateStudyParticipant(studyId, upn); err != nil {
+		t.Fatal(err)
+	}
+	d, err := PublishConsentDocument(studyId, "author@example.com", "Consent", "You agree.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnrollStudyParticipant(profileId, clientId, studyId, upn, d.Version+1); !errors.Is(err, ConsentVersionNotCurrentError) {
+		t.Errorf("Enrollment with an old consent version returned %v, expected ConsentVersionNotCurrentError", err)
+	}
+	if p, err := GetStudyParticipant(studyId, upn); err != nil || p.ProfileId != "" {
+		t.Errorf("Participant after refused enrollment is %#v (%v), expected not enrolled", p, err)
+	}
+	p, err := EnrollStudyParticipant(profileId, clientId, studyId, upn, d.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ProfileId != profileId || p.ConsentVersion != d.Version || p.ConsentAccepted == 0 {
+		t.Errorf("Enrolled participant is %#v, expected consent to version %d", p, d.Version)
+	}
+	records, err := FetchConsentRecords(studyId, upn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Version != d.Version || records[0].ClientId != clientId {
+		t.Errorf("Consent records after enrollment are %#v, expected one for version %d", records, d.Version)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/gob"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+// A Device is a client that has been used with a profile.
+type Device struct {
+	ClientId   string
+	ProfileId  string
+	ClientType string
+	Platform   string // from PlatformName
+	AppVersion string
+	FirstSeen  int64 // Unix time in milliseconds
+	LastSeen   int64 // Unix time in milliseconds
+	Revoked    int64 // Unix time in milliseconds, 0 if the device is signed in
+}
+
+func (d *Device) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(d); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (d *Device) FromRedis(b []byte) error {
+	*d = Device{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(d)
+}
+
+// The DeviceIndex of a profile ID maps from client ID to Device.
+type DeviceIndex string
+
+func (i DeviceIndex) StoragePrefix() string {
+	return "profile-devices:"
+}
+func (i DeviceIndex) StorageId() string {
+	return string(i)
+}
+
+// deviceSeenInterval is how stale a device's last seen time can get before it's updated.
+const deviceSeenInterval = 5 * time.Minute
+
+var (
+	DeviceNotFoundError       = errors.New("device not found")
+	DeviceAlreadyRevokedError = errors.New("device already revoked")
+)
+
+// ObserveDevice records that a client was just used with a profile. To avoid
+// a write on every request, the device is only saved if it has changed or
+// hasn't been seen recently. Empty client type, platform, or version values
+// don't replace known ones, because not every request sends them. Revoked
+// devices aren't updated. It returns the device as now known, or nil if the
+// database couldn't be reached.
+func ObserveDevice(clientId, profileId, clientType, platformName, appVersion string) *Device {
+	now := time.Now().UnixMilli()
+	d := new(Device)
+	configChanged := false
+	update := func(found bool) (bool, error) {
+		configChanged = false
+		if !found {
+			*d = Device{ClientId: clientId, ProfileId: profileId, FirstSeen: now}
+		} else if d.Revoked > 0 {
+			return false, nil
+		}
+		changed := !found || now-d.LastSeen > deviceSeenInterval.Milliseconds()
+		if clientType != "" && clientType != d.ClientType {
+			d.ClientType, changed = clientType, true
+		}
+		if platformName != "" && platformName != PlatformNames[PlatformUnknown] && platformName != d.Platform {
+			d.Platform, changed, configChanged = platformName, true, true
+		}
+		if appVersion != "" && appVersion != d.AppVersion {
+			d.AppVersion, changed, configChanged = appVersion, true, true
+		}
+		if changed {
+			d.LastSeen = now
+		}
+		return changed, nil
+	}
+	if _, err := platform.MapUpdateValue(sCtx(), DeviceIndex(profileId), clientId, d, update); err != nil {
+		sLog().Error("db failure on device update",
+			zap.String("clientId", clientId), zap.String("profileId", profileId), zap.Error(err))
+		return nil
+	}
+	// flags may have platform and version overrides
+	if configChanged {
+		_ = profileClientConfigDidChange(profileId)
+	}
+	return d
+}
+
+// GetProfileDevices returns all the devices used with a profile, most recently seen first.
+func GetProfileDevices(profileId string) ([]*Device, error) {
+	m, err := platform.MapGetAll(sCtx(), DeviceIndex(profileId))
+	if err != nil {
+		sLog().Error("db failure on devices fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return nil, err
+	}
+	result := make([]*Device, 0, len(m))
+	for clientId, val := range m {
+		d := new(Device)
+		if err := d.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on device",
+				zap.String("profileId", profileId), zap.String("clientId", clientId), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	slices.SortFunc(result, func(a, b *Device) int {
+		return cmp.Compare(b.LastSeen, a.LastSeen)
+	})
+	return result, nil
+}
+
+func saveDevice(d *Device) error {
+	b, err := d.ToRedis()
+	if err != nil {
+		sLog().Error("serialization failure on device",
+			zap.String("profileId", d.ProfileId), zap.String("clientId", d.ClientId), zap.Error(err))
+		return err
+	}
+	if err := platform.MapSet(sCtx(), DeviceIndex(d.ProfileId), d.ClientId, string(b)); err != nil {
+		sLog().Error("db failure on device save",
+			zap.String("profileId", d.ProfileId), zap.String("clientId", d.ClientId), zap.Error(err))
+		return err
+	}
+	return profileClientConfigDidChange(d.ProfileId)
+}
+
+// RevokeDevice signs a device out of a profile, so that its requests for that profile
+// are rejected. Only the profile's own record of the device is marked, so a profile
+// can't sign out a device that it's never been used on.
+func RevokeDevice(profileId, clientId string) error {
+	d := new(Device)
+	update := func(found bool) (bool, error) {
+		if !found {
+			return false, DeviceNotFoundError
+		}
+		if d.Revoked > 0 {
+			return false, DeviceAlreadyRevokedError
+		}
+		d.Revoked = time.Now().UnixMilli()
+		return true, nil
+	}
+	if _, err := platform.MapUpdateValue(sCtx(), DeviceIndex(profileId), clientId, d, update); err != nil {
+		if !errors.Is(err, DeviceNotFoundError) && !errors.Is(err, DeviceAlreadyRevokedError) {
+			sLog().Error("db failure on device revoke",
+				zap.String("profileId", profileId), zap.String("clientId", clientId), zap.Error(err))
+		}
+		return err
+	}
+	sLog().Info("device revoked", zap.String("profileId", profileId), zap.String("clientId", clientId))
+	return nil
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+func TestRevokeDeviceIsPerProfile(t *testing.T) {
+	clientId, profileId, otherProfileId := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	defer func() {
+		_ = platform.DeleteStorage(sCtx(), DeviceIndex(profileId))
+		_ = platform.DeleteStorage(sCtx(), DeviceIndex(otherProfileId))
+	}()
+	// a profile can't revoke a device that it's never been used on
+	if err := RevokeDevice(otherProfileId, clientId); !errors.Is(err, DeviceNotFoundError) {
+		t.Errorf("Revoke of another profile's device returned %v, expected DeviceNotFoundError", err)
+	}
+	if d := ObserveDevice(clientId, profileId, "app", "Phone", "1.0"); d == nil || d.Revoked != 0 {
+		t.Fatalf("Device after revoke by another profile is %#v, expected signed in", d)
+	}
+	if err := RevokeDevice(profileId, clientId); err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeDevice(profileId, clientId); !errors.Is(err, DeviceAlreadyRevokedError) {
+		t.Errorf("Second revoke returned %v, expected DeviceAlreadyRevokedError", err)
+	}
+	if d := ObserveDevice(clientId, profileId, "app", "Phone", "1.1"); d == nil || d.Revoked == 0 || d.AppVersion != "1.0" {
+		t.Errorf("Revoked device after use is %#v, expected revoked and unchanged", d)
+	}
+	if d := ObserveDevice(clientId, otherProfileId, "app", "Phone", "1.1"); d == nil || d.Revoked != 0 {
+		t.Errorf("Device with another profile is %#v, expected signed in", d)
+	}
+}
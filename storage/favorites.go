@@ -14,6 +14,8 @@ import (
 	"fmt"
 	"github.com/whisper-project/in-my-voice.server.golang/platform"
 	"go.uber.org/zap"
+	"slices"
+	"time"
 )
 
 type FavoritesSettings struct {
@@ -65,23 +67,173 @@ func GetFavoritesSettings(profileId string) (*FavoritesSettings, error) {
 	return f, nil
 }
 
+var FavoritesConflictError = errors.New("favorites settings have changed")
+
 func UpdateFavoritesSettings(profileId, settings string) (bool, error) {
+	changed, _, err := UpdateMatchingFavoritesSettings(profileId, "", settings, nil)
+	return changed, err
+}
+
+// UpdateMatchingFavoritesSettings updates the settings only if the stored settings
+// have one of the given ETags ("*" matches any stored settings). If no ETags are given,
+// the update is unconditional. If the stored settings don't match, it returns
+// FavoritesConflictError along with the stored settings, which will be nil if there are none.
+// Changed settings are added to the profile's favorites history as written by the client.
+func UpdateMatchingFavoritesSettings(profileId, clientId, settings string, etags []string) (bool, *FavoritesSettings, error) {
 	n := NewFavoritesSettings(profileId, settings)
 	o := &FavoritesSettings{ProfileId: profileId}
-	if err := platform.LoadObject(sCtx(), o); err != nil {
-		if !errors.Is(err, platform.NotFoundError) {
-			sLog().Error("db failure on settings update",
-				zap.String("profileId", profileId), zap.Error(err))
-			return false, err
+	var prior *FavoritesSettings
+	update := func(found bool) (bool, error) {
+		prior = nil
+		if !found {
+			*o = FavoritesSettings{ProfileId: profileId}
+		}
+		if len(etags) > 0 && (!found || !(slices.Contains(etags, "*") || slices.Contains(etags, o.ETag))) {
+			return false, FavoritesConflictError
+		}
+		if found && o.ETag == n.ETag {
+			return false, nil
 		}
+		if found {
+			prior = &FavoritesSettings{}
+			*prior = *o
+		}
+		*o = *n
+		return true, nil
 	}
-	if o.ETag == n.ETag {
-		return false, nil
+	changed, err := platform.UpdateObject(sCtx(), o, update)
+	if errors.Is(err, FavoritesConflictError) {
+		if o.ETag == "" {
+			return false, nil, err
+		}
+		return false, o, err
 	}
-	if err := platform.SaveObject(sCtx(), n); err != nil {
+	if err != nil {
 		sLog().Error("db failure on settings update",
 			zap.String("profileId", profileId), zap.Error(err))
-		return false, err
+		return false, nil, err
+	}
+	if changed {
+		addFavoritesVersion(prior, o, clientId)
+	}
+	return changed, o, nil
+}
+
+// FavoritesHistoryLength is the number of versions kept in each profile's favorites history.
+const FavoritesHistoryLength = 10
+
+// A FavoritesVersion is one version of a profile's favorites settings.
+type FavoritesVersion struct {
+	ETag     string
+	Settings string
+	ClientId string // the client that wrote this version, if known
+	Saved    int64  // Unix time in milliseconds
+}
+
+func (v *FavoritesVersion) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (v *FavoritesVersion) FromRedis(b []byte) error {
+	*v = FavoritesVersion{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+// FavoritesHistory is the list of a profile's favorites versions, newest first.
+type FavoritesHistory string
+
+func (h FavoritesHistory) StoragePrefix() string {
+	return "favorites-history:"
+}
+func (h FavoritesHistory) StorageId() string {
+	return string(h)
+}
+
+// addFavoritesVersion adds the new settings to the profile's favorites history. If the
+// history is empty, as it is for favorites saved before the history was kept, the prior
+// settings (if any) are added first, so they can be restored if the new ones are bad.
+func addFavoritesVersion(prior, f *FavoritesSettings, clientId string) {
+	h := FavoritesHistory(f.ProfileId)
+	now := time.Now().UnixMilli()
+	versions := []*FavoritesVersion{{ETag: f.ETag, Settings: f.Settings, ClientId: clientId, Saved: now}}
+	if prior != nil {
+		existing, err := platform.FetchRange(sCtx(), h, 0, 0)
+		if err != nil {
+			sLog().Error("db failure on favorites history fetch",
+				zap.String("profileId", f.ProfileId), zap.Error(err))
+			return
+		}
+		if len(existing) == 0 {
+			// we don't know when the prior settings were saved, so date them just before these
+			v := &FavoritesVersion{ETag: prior.ETag, Settings: prior.Settings, Saved: now - 1}
+			versions = append([]*FavoritesVersion{v}, versions...)
+		}
+	}
+	vals := make([]string, 0, len(versions))
+	for _, v := range versions {
+		b, err := v.ToRedis()
+		if err != nil {
+			sLog().Error("serialization failure on favorites version",
+				zap.String("profileId", f.ProfileId), zap.Error(err))
+			return
+		}
+		vals = append(vals, string(b))
+	}
+	// pushing on the left one at a time leaves the newest version first
+	if err := platform.PushRange(sCtx(), h, true, vals...); err != nil {
+		sLog().Error("db failure on favorites history push",
+			zap.String("profileId", f.ProfileId), zap.Error(err))
+		return
+	}
+	if err := platform.TrimRange(sCtx(), h, 0, FavoritesHistoryLength-1); err != nil {
+		sLog().Error("db failure on favorites history trim",
+			zap.String("profileId", f.ProfileId), zap.Error(err))
+	}
+}
+
+// GetFavoritesHistory returns the saved versions of a profile's favorites, newest first.
+func GetFavoritesHistory(profileId string) ([]*FavoritesVersion, error) {
+	vals, err := platform.FetchRange(sCtx(), FavoritesHistory(profileId), 0, -1)
+	if err != nil {
+		sLog().Error("db failure on favorites history fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return nil, err
+	}
+	versions := make([]*FavoritesVersion, 0, len(vals))
+	for _, val := range vals {
+		v := new(FavoritesVersion)
+		if err := v.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on favorites version",
+				zap.String("profileId", profileId), zap.Error(err))
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// RestoreFavoritesVersion makes the saved version with the given ETag the current favorites.
+// It returns the restored settings, which are nil if there's no such version, and whether
+// they changed the current favorites.
+func RestoreFavoritesVersion(profileId, clientId, etag string) (*FavoritesSettings, bool, error) {
+	versions, err := GetFavoritesHistory(profileId)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, v := range versions {
+		if v.ETag == etag {
+			changed, f, err := UpdateMatchingFavoritesSettings(profileId, clientId, v.Settings, nil)
+			if err != nil {
+				return nil, false, err
+			}
+			sLog().Info("favorites version restored",
+				zap.String("profileId", profileId), zap.String("clientId", clientId),
+				zap.String("etag", etag), zap.Bool("changed", changed))
+			return f, changed, nil
+		}
 	}
-	return true, nil
+	return nil, false, nil
 }
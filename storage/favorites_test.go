@@ -0,0 +1,47 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+func TestFavoritesHistoryKeepsPriorSettings(t *testing.T) {
+	profileId := uuid.NewString()
+	defer func() {
+		_ = platform.DeleteStorage(sCtx(), &FavoritesSettings{ProfileId: profileId})
+		_ = platform.DeleteStorage(sCtx(), FavoritesHistory(profileId))
+	}()
+	// settings saved before there was a history
+	good := NewFavoritesSettings(profileId, "good")
+	if err := platform.SaveObject(sCtx(), good); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdateFavoritesSettings(profileId, "bad"); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := GetFavoritesHistory(profileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Settings != "bad" || versions[1].Settings != "good" {
+		t.Fatalf("History after first update is %#v, expected bad then good", versions)
+	}
+	if _, err := UpdateFavoritesSettings(profileId, "worse"); err != nil {
+		t.Fatal(err)
+	}
+	versions, err = GetFavoritesHistory(profileId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].Settings != "worse" || versions[2].Settings != "good" {
+		t.Errorf("History after second update is %#v, expected worse, bad, good", versions)
+	}
+}
@@ -0,0 +1,529 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+type FlagType = string
+
+const (
+	FlagTypeBool   FlagType = "bool"
+	FlagTypeInt    FlagType = "int"
+	FlagTypeString FlagType = "string"
+)
+
+var FlagTypes = []FlagType{FlagTypeBool, FlagTypeInt, FlagTypeString}
+
+type FlagOverrideKind = string
+
+// The override kinds, in the order they take precedence. Study arm values
+// come between profile overrides and the other kinds.
+const (
+	FlagOverrideProfile  FlagOverrideKind = "profile"  // matches a profile ID
+	FlagOverrideVersion  FlagOverrideKind = "version"  // matches app versions at or above a version
+	FlagOverridePlatform FlagOverrideKind = "platform" // matches a platform name, such as Tablet
+	FlagOverrideStudy    FlagOverrideKind = "study"    // matches the profiles enrolled in a study ID
+)
+
+var FlagOverrideKinds = []FlagOverrideKind{FlagOverrideProfile, FlagOverrideVersion, FlagOverridePlatform, FlagOverrideStudy}
+
+// A FlagOverride gives a flag a different value for the clients it matches.
+type FlagOverride struct {
+	Kind  FlagOverrideKind
+	Match string
+	Value string
+}
+
+// A FeatureFlag is a typed setting that is sent to the app. Its value for a
+// client is the value of its most specific matching override, except that the
+// values set by study arms take precedence over all but profile overrides. If
+// nothing matches, it's the rollout value for the rollout percentage of profiles, and the
+// default value for everyone else. Values are stored as strings and checked
+// against the flag's type when saved.
+type FeatureFlag struct {
+	Name           string
+	Type           FlagType
+	Description    string
+	Default        string
+	Overrides      []FlagOverride
+	RolloutPercent int
+	RolloutValue   string
+	Author         string // email of the admin who last changed it
+	Updated        int64  // Unix time in milliseconds
+}
+
+func (f *FeatureFlag) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(f); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (f *FeatureFlag) FromRedis(b []byte) error {
+	*f = FeatureFlag{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(f)
+}
+
+// the global map from flag name to flag
+var featureFlags = platform.StorableMap("feature-flags")
+
+// The ConfigETags of a profile ID maps from client ID to the ETag of the
+// configuration that client was last sent or told about.
+type ConfigETags string
+
+func (e ConfigETags) StoragePrefix() string {
+	return "client-config-etags:"
+}
+func (e ConfigETags) StorageId() string {
+	return string(e)
+}
+
+// The ClientConfigCache of a profile ID maps from client ID to the stamped ETag of
+// the client's current configuration, so it needn't be resolved on every request.
+// A cached ETag is current if its stamp matches both the clientConfigVersion and
+// the profile's own stamp, which is kept in the map under configCacheStampField.
+type ClientConfigCache string
+
+func (c ClientConfigCache) StoragePrefix() string {
+	return "client-config-cache:"
+}
+func (c ClientConfigCache) StorageId() string {
+	return string(c)
+}
+
+const configCacheStampField = "stamp"
+
+// clientConfigVersion changes whenever a change to flags or studies may change any client's configuration
+var clientConfigVersion platform.StorableString = "client-config-version"
+
+var (
+	InvalidFlagNameError     = errors.New("invalid flag name")
+	InvalidFlagTypeError     = errors.New("invalid flag type")
+	InvalidFlagValueError    = errors.New("value doesn't match flag type")
+	InvalidFlagOverrideError = errors.New("invalid flag override")
+	InvalidFlagRolloutError  = errors.New("rollout percentage must be between 0 and 100")
+)
+
+var flagNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// ParseValue converts a flag value to the flag's type.
+func (f *FeatureFlag) ParseValue(s string) (any, error) {
+	switch f.Type {
+	case FlagTypeBool:
+		if v, err := strconv.ParseBool(s); err == nil {
+			return v, nil
+		}
+	case FlagTypeInt:
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return v, nil
+		}
+	case FlagTypeString:
+		return s, nil
+	default:
+		return nil, InvalidFlagTypeError
+	}
+	return nil, fmt.Errorf("%w: %q is not a %s", InvalidFlagValueError, s, f.Type)
+}
+
+func (f *FeatureFlag) validate() error {
+	if !flagNamePattern.MatchString(f.Name) {
+		return InvalidFlagNameError
+	}
+	if !slices.Contains(FlagTypes, f.Type) {
+		return InvalidFlagTypeError
+	}
+	if _, err := f.ParseValue(f.Default); err != nil {
+		return err
+	}
+	if f.RolloutPercent < 0 || f.RolloutPercent > 100 {
+		return InvalidFlagRolloutError
+	}
+	if f.RolloutPercent > 0 {
+		if _, err := f.ParseValue(f.RolloutValue); err != nil {
+			return err
+		}
+	}
+	for _, o := range f.Overrides {
+		if !slices.Contains(FlagOverrideKinds, o.Kind) || o.Match == "" {
+			return InvalidFlagOverrideError
+		}
+		if o.Kind == FlagOverrideVersion && !policyVersionPattern.MatchString(o.Match) {
+			return fmt.Errorf("%w: %q is not a version number", InvalidFlagOverrideError, o.Match)
+		}
+		if o.Kind == FlagOverridePlatform && !slices.ContainsFunc(PlatformNames[PlatformPhone:], func(name string) bool {
+			return strings.EqualFold(name, o.Match)
+		}) {
+			return fmt.Errorf("%w: %q is not a platform", InvalidFlagOverrideError, o.Match)
+		}
+		if _, err := f.ParseValue(o.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseFlagOverrides reads overrides written one per line as "kind match = value",
+// such as "platform Tablet = true". Blank lines are ignored.
+func ParseFlagOverrides(text string) ([]FlagOverride, error) {
+	var result []FlagOverride
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		spec, value, found := strings.Cut(line, "=")
+		kind, match, _ := strings.Cut(strings.TrimSpace(spec), " ")
+		match = strings.TrimSpace(match)
+		if !found || !slices.Contains(FlagOverrideKinds, kind) || match == "" {
+			return nil, fmt.Errorf("%w: line %d", InvalidFlagOverrideError, i+1)
+		}
+		result = append(result, FlagOverride{
+			Kind:  kind,
+			Match: match,
+			Value: strings.TrimSpace(value),
+		})
+	}
+	return result, nil
+}
+
+// FormatFlagOverrides writes overrides in the form read by ParseFlagOverrides.
+func FormatFlagOverrides(overrides []FlagOverride) string {
+	lines := make([]string, 0, len(overrides))
+	for _, o := range overrides {
+		lines = append(lines, fmt.Sprintf("%s %s = %s", o.Kind, o.Match, o.Value))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func GetFeatureFlag(name string) (*FeatureFlag, error) {
+	val, err := platform.MapGet(sCtx(), featureFlags, name)
+	if err != nil {
+		sLog().Error("db failure on feature flag fetch", zap.String("name", name), zap.Error(err))
+		return nil, err
+	}
+	if val == "" {
+		return nil, nil
+	}
+	f := new(FeatureFlag)
+	if err := f.FromRedis([]byte(val)); err != nil {
+		sLog().Error("deserialization failure on feature flag", zap.String("name", name), zap.Error(err))
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetAllFeatureFlags returns all the feature flags, sorted by name.
+func GetAllFeatureFlags() ([]*FeatureFlag, error) {
+	m, err := platform.MapGetAll(sCtx(), featureFlags)
+	if err != nil {
+		sLog().Error("db failure on feature flags fetch", zap.Error(err))
+		return nil, err
+	}
+	result := make([]*FeatureFlag, 0, len(m))
+	for name, val := range m {
+		f := new(FeatureFlag)
+		if err := f.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on feature flag", zap.String("name", name), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, f)
+	}
+	slices.SortFunc(result, func(a, b *FeatureFlag) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result, nil
+}
+
+// SaveFeatureFlag checks the flag's values against its type and saves it.
+// Clients whose configuration changes are told to update on their next request.
+func SaveFeatureFlag(f *FeatureFlag, author string) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	f.Author = author
+	f.Updated = time.Now().UnixMilli()
+	if err := saveFeatureFlag(f); err != nil {
+		return err
+	}
+	sLog().Info("feature flag saved", zap.String("name", f.Name), zap.String("author", author))
+	return nil
+}
+
+func saveFeatureFlag(f *FeatureFlag) error {
+	b, err := f.ToRedis()
+	if err != nil {
+		sLog().Error("serialization failure on feature flag", zap.String("name", f.Name), zap.Error(err))
+		return err
+	}
+	if err := platform.MapSet(sCtx(), featureFlags, f.Name, string(b)); err != nil {
+		sLog().Error("db failure on feature flag save", zap.String("name", f.Name), zap.Error(err))
+		return err
+	}
+	return clientConfigsDidChange()
+}
+
+func DeleteFeatureFlag(name string) error {
+	if err := platform.MapRemove(sCtx(), featureFlags, name); err != nil {
+		sLog().Error("db failure on feature flag delete", zap.String("name", name), zap.Error(err))
+		return err
+	}
+	sLog().Info("feature flag deleted", zap.String("name", name))
+	return clientConfigsDidChange()
+}
+
+// removeProfileFlagOverrides removes the flag overrides that name a profile.
+func removeProfileFlagOverrides(profileId string) error {
+	flags, err := GetAllFeatureFlags()
+	if err != nil {
+		return err
+	}
+	for _, f := range flags {
+		n := len(f.Overrides)
+		f.Overrides = slices.DeleteFunc(f.Overrides, func(o FlagOverride) bool {
+			return o.Kind == FlagOverrideProfile && o.Match == profileId
+		})
+		if len(f.Overrides) < n {
+			if err := saveFeatureFlag(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A FlagContext describes the client that flags are being resolved for.
+type FlagContext struct {
+	ProfileId  string
+	StudyId    string
+	Platform   string // from PlatformName
+	AppVersion string
+	ArmFlags   map[string]string // the flag values of the participant's study arm
+}
+
+// Resolve returns the flag's typed value for a client.
+func (f *FeatureFlag) Resolve(fc FlagContext) any {
+	value, found := f.matchOverride(fc, FlagOverrideKinds[:1])
+	if !found {
+		value, found = fc.ArmFlags[f.Name]
+	}
+	if !found {
+		value, found = f.matchOverride(fc, FlagOverrideKinds[1:])
+	}
+	if !found {
+		if f.RolloutPercent > 0 && rolloutBucket(f.Name, fc.ProfileId) < f.RolloutPercent {
+			value = f.RolloutValue
+		} else {
+			value = f.Default
+		}
+	}
+	v, err := f.ParseValue(value)
+	if err != nil {
+		// values are checked when saved, so this should never happen
+		sLog().Error("invalid feature flag value", zap.String("name", f.Name), zap.String("value", value))
+		v, _ = f.ParseValue(f.Default)
+	}
+	return v
+}
+
+// matchOverride finds the value of the flag's most specific matching override of the given kinds.
+// Of the version overrides that match, the one with the highest version wins.
+func (f *FeatureFlag) matchOverride(fc FlagContext, kinds []FlagOverrideKind) (string, bool) {
+	for _, kind := range kinds {
+		var best *FlagOverride
+		for i, o := range f.Overrides {
+			if o.Kind != kind {
+				continue
+			}
+			var matches bool
+			switch kind {
+			case FlagOverrideProfile:
+				matches = o.Match == fc.ProfileId
+			case FlagOverrideVersion:
+				matches = platform.CompareVersions(fc.AppVersion, o.Match) >= 0 &&
+					(best == nil || platform.CompareVersions(o.Match, best.Match) > 0)
+			case FlagOverridePlatform:
+				matches = strings.EqualFold(o.Match, fc.Platform)
+			case FlagOverrideStudy:
+				matches = o.Match == fc.StudyId
+			}
+			if matches {
+				best = &f.Overrides[i]
+				if kind != FlagOverrideVersion {
+					break
+				}
+			}
+		}
+		if best != nil {
+			return best.Value, true
+		}
+	}
+	return "", false
+}
+
+// rolloutBucket puts each profile in a stable bucket from 0 to 99 for each flag,
+// so raising a rollout percentage only ever adds profiles.
+func rolloutBucket(name, profileId string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name + ":" + profileId))
+	return int(h.Sum32() % 100)
+}
+
+// GetClientConfig resolves all the feature flags for a client, returning
+// the configuration and its ETag. An empty configuration has an empty ETag.
+func GetClientConfig(profileId, clientId string) (map[string]any, string, error) {
+	flags, err := GetAllFeatureFlags()
+	if err != nil {
+		return nil, "", err
+	}
+	config := make(map[string]any, len(flags))
+	if len(flags) == 0 {
+		return config, "", nil
+	}
+	fc := FlagContext{ProfileId: profileId}
+	studyId, upn, err := GetProfileStudyMembership(profileId)
+	if err != nil {
+		return nil, "", err
+	}
+	if fc.StudyId = studyId; studyId != "" {
+		if fc.ArmFlags, err = participantArmFlags(studyId, upn); err != nil {
+			return nil, "", err
+		}
+	}
+	val, err := platform.MapGet(sCtx(), DeviceIndex(profileId), clientId)
+	if err != nil {
+		sLog().Error("db failure on device fetch",
+			zap.String("profileId", profileId), zap.String("clientId", clientId), zap.Error(err))
+		return nil, "", err
+	}
+	if val != "" {
+		d := new(Device)
+		if err := d.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on device",
+				zap.String("profileId", profileId), zap.String("clientId", clientId), zap.Error(err))
+			return nil, "", err
+		}
+		fc.Platform, fc.AppVersion = d.Platform, d.AppVersion
+	}
+	for _, f := range flags {
+		config[f.Name] = f.Resolve(fc)
+	}
+	// map keys are encoded in sorted order, so equal configurations have equal ETags
+	b, err := json.Marshal(config)
+	if err != nil {
+		sLog().Error("serialization failure on client config", zap.String("profileId", profileId), zap.Error(err))
+		return nil, "", err
+	}
+	return config, fmt.Sprintf("%x", md5.Sum(b)), nil
+}
+
+func participantArmFlags(studyId, upn string) (map[string]string, error) {
+	p, err := GetStudyParticipant(studyId, upn)
+	if err != nil || p == nil || p.Arm == "" {
+		return nil, err
+	}
+	study, err := GetStudy(studyId)
+	if err != nil || study == nil {
+		return nil, err
+	}
+	if arm := study.GetArm(p.Arm); arm != nil {
+		return arm.Flags, nil
+	}
+	return nil, nil
+}
+
+// GetClientConfigETag returns the ETag of the client's current configuration,
+// resolving the configuration only if the cached ETag isn't current.
+func GetClientConfigETag(profileId, clientId string) (string, error) {
+	version, err := platform.FetchString(sCtx(), clientConfigVersion)
+	if err != nil {
+		sLog().Error("db failure on config version fetch", zap.Error(err))
+		return "", err
+	}
+	cache, err := platform.MapGetAll(sCtx(), ClientConfigCache(profileId))
+	if err != nil {
+		sLog().Error("db failure on config cache fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return "", err
+	}
+	// the stamps are read before the configuration is resolved, so a change made while
+	// it's being resolved leaves the cached ETag out of date rather than wrongly current
+	stamp := version + "+" + cache[configCacheStampField] + " "
+	if etag, found := strings.CutPrefix(cache[clientId], stamp); found {
+		return etag, nil
+	}
+	_, etag, err := GetClientConfig(profileId, clientId)
+	if err != nil {
+		return "", err
+	}
+	if err := platform.MapSet(sCtx(), ClientConfigCache(profileId), clientId, stamp+etag); err != nil {
+		sLog().Error("db failure on config cache save",
+			zap.String("profileId", profileId), zap.String("clientId", clientId), zap.Error(err))
+		return "", err
+	}
+	return etag, nil
+}
+
+// clientConfigsDidChange makes all the cached configuration ETags out of date.
+func clientConfigsDidChange() error {
+	if err := platform.StoreString(sCtx(), clientConfigVersion, uuid.NewString()); err != nil {
+		sLog().Error("db failure on config version save", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// profileClientConfigDidChange makes the profile's cached configuration ETags out of date.
+func profileClientConfigDidChange(profileId string) error {
+	if err := platform.MapSet(sCtx(), ClientConfigCache(profileId), configCacheStampField, uuid.NewString()); err != nil {
+		sLog().Error("db failure on config cache stamp save",
+			zap.String("profileId", profileId), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ProfileClientConfigNeedsNotification returns whether the client's configuration
+// has changed since it was last sent or told about, and the ETag of the current one.
+func ProfileClientConfigNeedsNotification(profileId, clientId string) (bool, string, error) {
+	etag, err := GetClientConfigETag(profileId, clientId)
+	if err != nil {
+		return false, "", err
+	}
+	last, err := platform.MapGet(sCtx(), ConfigETags(profileId), clientId)
+	if err != nil {
+		sLog().Error("db failure on config etag fetch",
+			zap.String("profileId", profileId), zap.String("clientId", clientId), zap.Error(err))
+		return false, "", err
+	}
+	return etag != last, etag, nil
+}
+
+func ProfileClientConfigWasNotified(profileId, clientId, etag string) error {
+	if err := platform.MapSet(sCtx(), ConfigETags(profileId), clientId, etag); err != nil {
+		sLog().Error("db failure on config etag save",
+			zap.String("profileId", profileId), zap.String("clientId", clientId), zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+func TestParseFlagOverrides(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []FlagOverride
+		err  bool
+	}{
+		{"empty", "", nil, false},
+		{"blank lines", "\n  \n", nil, false},
+		{"one", "platform Tablet = true", []FlagOverride{{FlagOverridePlatform, "Tablet", "true"}}, false},
+		{"spacing", "  version   2.1=  7 ", []FlagOverride{{FlagOverrideVersion, "2.1", "7"}}, false},
+		{"several", "profile abc = x\n\nstudy def = y", []FlagOverride{
+			{FlagOverrideProfile, "abc", "x"}, {FlagOverrideStudy, "def", "y"},
+		}, false},
+		{"empty value", "study def =", []FlagOverride{{FlagOverrideStudy, "def", ""}}, false},
+		{"no equals", "platform Tablet true", nil, true},
+		{"no match", "platform = true", nil, true},
+		{"unknown kind", "device Tablet = true", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFlagOverrides(tt.text)
+			if tt.err {
+				if !errors.Is(err, InvalidFlagOverrideError) {
+					t.Errorf("ParseFlagOverrides(%q) returned %v, expected InvalidFlagOverrideError", tt.text, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFlagOverrides(%q) = %#v (%v), expected %#v", tt.text, got, err, tt.want)
+			}
+			if err == nil && len(got) > 0 {
+				if again, _ := ParseFlagOverrides(FormatFlagOverrides(got)); !reflect.DeepEqual(again, got) {
+					t.Errorf("Formatted overrides parse as %#v, expected %#v", again, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFlagValidateOverrides(t *testing.T) {
+	f := FeatureFlag{Name: "test", Type: FlagTypeBool, Default: "false"}
+	for _, o := range []FlagOverride{
+		{FlagOverridePlatform, "Mac", "true"},
+		{FlagOverridePlatform, "Unknown", "true"},
+		{FlagOverrideVersion, "latest", "true"},
+		{FlagOverrideStudy, "abc", "maybe"},
+	} {
+		f.Overrides = []FlagOverride{o}
+		if err := f.validate(); err == nil {
+			t.Errorf("Override %#v is valid, expected an error", o)
+		}
+	}
+	f.Overrides = []FlagOverride{{FlagOverridePlatform, "tablet", "true"}}
+	if err := f.validate(); err != nil {
+		t.Errorf("Override %#v is invalid: %v", f.Overrides[0], err)
+	}
+}
+
+func TestFlagResolvePrecedence(t *testing.T) {
+	f := FeatureFlag{
+		Name:    "test",
+		Type:    FlagTypeString,
+		Default: "default",
+		Overrides: []FlagOverride{
+			{FlagOverrideStudy, "study", "study"},
+			{FlagOverridePlatform, "Tablet", "platform"},
+			{FlagOverrideVersion, "2.0", "version 2.0"},
+			{FlagOverrideVersion, "2.5", "version 2.5"},
+			{FlagOverrideProfile, "profile", "profile"},
+		},
+		RolloutPercent: 100,
+		RolloutValue:   "rollout",
+	}
+	all := FlagContext{
+		ProfileId:  "profile",
+		StudyId:    "study",
+		Platform:   "Tablet",
+		AppVersion: "2.6",
+		ArmFlags:   map[string]string{"test": "arm"},
+	}
+	tests := []struct {
+		name string
+		fc   func(fc *FlagContext)
+		want string
+	}{
+		{"profile", func(fc *FlagContext) {}, "profile"},
+		{"arm", func(fc *FlagContext) { fc.ProfileId = "other" }, "arm"},
+		{"highest version", func(fc *FlagContext) { fc.ProfileId, fc.ArmFlags = "other", nil }, "version 2.5"},
+		{"lower version", func(fc *FlagContext) { fc.ProfileId, fc.ArmFlags, fc.AppVersion = "other", nil, "2.1" }, "version 2.0"},
+		{"platform", func(fc *FlagContext) { fc.ProfileId, fc.ArmFlags, fc.AppVersion = "other", nil, "1.0" }, "platform"},
+		{"study", func(fc *FlagContext) {
+			fc.ProfileId, fc.ArmFlags, fc.AppVersion, fc.Platform = "other", nil, "1.0", "Phone"
+		}, "study"},
+		{"rollout", func(fc *FlagContext) {
+			fc.ProfileId, fc.ArmFlags, fc.AppVersion, fc.Platform, fc.StudyId = "other", nil, "1.0", "Phone", ""
+		}, "rollout"},
+		{"other arm flag", func(fc *FlagContext) {
+			fc.ProfileId, fc.ArmFlags, fc.AppVersion, fc.Platform, fc.StudyId = "other", map[string]string{"x": "y"}, "", "", ""
+		}, "rollout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := all
+			tt.fc(&fc)
+			if got := f.Resolve(fc); got != tt.want {
+				t.Errorf("Resolve(%#v) = %v, expected %v", fc, got, tt.want)
+			}
+		})
+	}
+	f.RolloutPercent = 0
+	if got := f.Resolve(FlagContext{ProfileId: "other"}); got != "default" {
+		t.Errorf("Resolve with nothing matching = %v, expected default", got)
+	}
+}
+
+func TestClientConfigETagCache(t *testing.T) {
+	name := "test-" + uuid.NewString()
+	profileId, clientId := uuid.NewString(), uuid.NewString()
+	defer func() {
+		_ = DeleteFeatureFlag(name)
+		_ = platform.DeleteStorage(sCtx(), DeviceIndex(profileId))
+		_ = platform.DeleteStorage(sCtx(), ClientConfigCache(profileId))
+	}()
+	f := &FeatureFlag{Name: name, Type: FlagTypeBool, Default: "false",
+		Overrides: []FlagOverride{{FlagOverridePlatform, "Tablet", "true"}}}
+	if err := SaveFeatureFlag(f, "author@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ObserveDevice(clientId, profileId, "app", "Phone", "1.0")
+	etagOf := func(when string) string {
+		cached, err := GetClientConfigETag(profileId, clientId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, etag, err := GetClientConfig(profileId, clientId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached != etag {
+			t.Errorf("Cached ETag %s %s, expected current %s", when, cached, etag)
+		}
+		return etag
+	}
+	phone := etagOf("at first")
+	if again := etagOf("when unchanged"); again != phone {
+		t.Errorf("ETag changed from %s to %s with nothing changed", phone, again)
+	}
+	ObserveDevice(clientId, profileId, "app", "Tablet", "1.0")
+	if tablet := etagOf("after a device change"); tablet == phone {
+		t.Errorf("ETag didn't change when the device did")
+	}
+	f.Overrides = nil
+	if err := SaveFeatureFlag(f, "author@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if after := etagOf("after a flag change"); after != phone {
+		t.Errorf("ETag after the override was removed is %s, expected %s", after, phone)
+	}
+}
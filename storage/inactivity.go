@@ -0,0 +1,261 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"github.com/whisper-project/in-my-voice.server.golang/services"
+	"go.uber.org/zap"
+)
+
+// InactiveParticipants is the set of UPNs in a study that have been flagged as inactive.
+// A UPN stays in the set until the participant is active again, so that each
+// period of inactivity is only reported (and nudged) once.
+type InactiveParticipants string
+
+func (i InactiveParticipants) StoragePrefix() string {
+	return "inactive-participants:"
+}
+func (i InactiveParticipants) StorageId() string {
+	return string(i)
+}
+
+// inactivityCheckDone is an expiring key that is present if a study was checked in the last day.
+type inactivityCheckDone string
+
+func (i inactivityCheckDone) StoragePrefix() string {
+	return "inactivity-check-done:"
+}
+func (i inactivityCheckDone) StorageId() string {
+	return string(i)
+}
+
+// PendingMessages is the list of in-app messages waiting to be delivered to a profile, oldest first.
+// Each message is delivered (in the X-Message header) on the next launch or foreground of any of the profile's clients.
+type PendingMessages string
+
+func (p PendingMessages) StoragePrefix() string {
+	return "pending-messages:"
+}
+func (p PendingMessages) StorageId() string {
+	return string(p)
+}
+
+// QueueProfileMessage adds a message for delivery to one of the profile's clients.
+func QueueProfileMessage(profileId, message string) error {
+	if err := platform.PushRange(sCtx(), PendingMessages(profileId), false, message); err != nil {
+		sLog().Error("db failure on pending message queue",
+			zap.String("profileId", profileId), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// NextProfileMessage removes and returns the oldest pending message for the profile,
+// or the empty string if there are none.
+func NextProfileMessage(profileId string) (string, error) {
+	message, err := platform.PopOne(sCtx(), PendingMessages(profileId), true)
+	if err != nil {
+		sLog().Error("db failure on pending message fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return "", err
+	}
+	return message, nil
+}
+
+// An InactiveParticipant is a participant who hasn't used the app in too long.
+type InactiveParticipant struct {
+	Upn        string
+	LastActive int64 // Unix time in milliseconds
+	IsNew      bool  // whether this is the first check that found them inactive
+}
+
+func (p *InactiveParticipant) describe(now int64) string {
+	last := time.UnixMilli(p.LastActive).In(AdminTZ).Format("01/02/2006 3:04pm MST")
+	return fmt.Sprintf("Participant %s has not used the app in %d days (last active %s).",
+		p.Upn, daysBetween(p.LastActive, now), last)
+}
+
+// CheckInactiveParticipants looks for participants who have been inactive past their
+// study's threshold, as long as the study hasn't already been checked in the last day.
+// Newly inactive participants are sent the study's inactivity message (if any), and the
+// study's participant managers are sent a digest of all the inactive participants.
+func CheckInactiveParticipants(ctx context.Context) error {
+	studies, err := GetAllStudies()
+	if err != nil {
+		return err
+	}
+	var lastActive map[string]int64
+	for _, study := range studies {
+		if !study.Active || study.InactivityDays <= 0 {
+			continue
+		}
+		if done, err := platform.FetchString(ctx, inactivityCheckDone(study.Id)); err != nil || done != "" {
+			continue
+		}
+		if lastActive == nil {
+			if lastActive, err = fetchProfileLastActive(ctx); err != nil {
+				return err
+			}
+		}
+		if err := checkStudyInactivity(ctx, study, lastActive); err != nil {
+			continue
+		}
+		if err := platform.StoreString(ctx, inactivityCheckDone(study.Id), time.Now().Format(time.RFC3339)); err == nil {
+			_ = platform.SetExpiration(ctx, inactivityCheckDone(study.Id), 23*60*60)
+		}
+	}
+	return nil
+}
+
+// fetchProfileLastActive returns the last time any client of each profile was active.
+func fetchProfileLastActive(ctx context.Context) (map[string]int64, error) {
+	result := make(map[string]int64)
+	l := new(LifecycleData)
+	collect := func() error {
+		result[l.ProfileId] = max(result[l.ProfileId], l.LastActive, l.LastShutdown)
+		return nil
+	}
+	if err := platform.MapObjects(ctx, collect, l); err != nil {
+		sLog().Error("db failure on lifecycle data scan", zap.Error(err))
+		return nil, err
+	}
+	return result, nil
+}
+
+// participantLastActive returns the later of the participant's last client activity
+// and last typed line. Participants who haven't done either are active as of their start.
+func participantLastActive(ctx context.Context, p *StudyParticipant, lastActive map[string]int64) (int64, error) {
+	last := max(p.Started, lastActive[p.ProfileId])
+	vals, err := platform.FetchRange(ctx, StudyTypedLineStatsIndex(p.StudyId+"+"+p.Upn), -1, -1)
+	if err != nil {
+		sLog().Error("db failure on typed line stats fetch",
+			zap.String("studyId", p.StudyId), zap.String("upn", p.Upn), zap.Error(err))
+		return 0, err
+	}
+	if len(vals) > 0 {
+		var stat TypedLineStat
+		if err := stat.FromRedis([]byte(vals[0])); err != nil {
+			sLog().Error("deserialization failure on typed line stat",
+				zap.String("studyId", p.StudyId), zap.String("upn", p.Upn), zap.Error(err))
+			return 0, err
+		}
+		last = max(last, stat.Completed)
+	}
+	return last, nil
+}
+
+func checkStudyInactivity(ctx context.Context, study *Study, lastActive map[string]int64) error {
+	participants, err := GetAllStudyParticipants(study.Id)
+	if err != nil {
+		return err
+	}
+	flagged, err := platform.FetchMembers(ctx, InactiveParticipants(study.Id))
+	if err != nil {
+		sLog().Error("db failure on inactive participants fetch",
+			zap.String("studyId", study.Id), zap.Error(err))
+		return err
+	}
+	now := time.Now().UnixMilli()
+	cutoff := now - study.InactivityDays*24*time.Hour.Milliseconds()
+	var inactive []*InactiveParticipant
+	var upns, newUpns, newProfileIds []string
+	for _, p := range participants {
+		if p.ProfileId == "" || p.Started == 0 || p.Finished > 0 {
+			continue
+		}
+		last, err := participantLastActive(ctx, p, lastActive)
+		if err != nil {
+			return err
+		}
+		if last >= cutoff {
+			continue
+		}
+		ip := &InactiveParticipant{Upn: p.Upn, LastActive: last, IsNew: !slices.Contains(flagged, p.Upn)}
+		inactive = append(inactive, ip)
+		upns = append(upns, p.Upn)
+		if !ip.IsNew {
+			continue
+		}
+		newUpns = append(newUpns, p.Upn)
+		newProfileIds = append(newProfileIds, p.ProfileId)
+	}
+	// participants who are active again (or have finished) are no longer flagged
+	var cleared []string
+	for _, upn := range flagged {
+		if !slices.Contains(upns, upn) {
+			cleared = append(cleared, upn)
+		}
+	}
+	if len(cleared) > 0 {
+		if err := platform.RemoveMembers(ctx, InactiveParticipants(study.Id), cleared...); err != nil {
+			sLog().Error("db failure on inactive participants clear",
+				zap.String("studyId", study.Id), zap.Error(err))
+			return err
+		}
+	}
+	if len(newUpns) == 0 {
+		return nil
+	}
+	// the newly inactive participants are only flagged (and messaged) once the digest
+	// has been sent, so that a failed send is retried on the next check
+	if err := sendInactivityDigest(study, inactive, now); err != nil {
+		return err
+	}
+	if err := platform.AddMembers(ctx, InactiveParticipants(study.Id), newUpns...); err != nil {
+		sLog().Error("db failure on inactive participants add",
+			zap.String("studyId", study.Id), zap.Error(err))
+		return err
+	}
+	sLog().Info("inactive participants flagged",
+		zap.String("studyId", study.Id), zap.Strings("upns", newUpns))
+	if study.InactivityMessage != "" {
+		for _, profileId := range newProfileIds {
+			_ = QueueProfileMessage(profileId, study.InactivityMessage)
+		}
+	}
+	return nil
+}
+
+func sendInactivityDigest(study *Study, inactive []*InactiveParticipant, now int64) error {
+	emails, err := GetStudyAdminEmails(study.Id, AdminRoleParticipantManager)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(inactive, func(a, b *InactiveParticipant) int {
+		return strings.Compare(a.Upn, b.Upn)
+	})
+	var newLines, oldLines []string
+	for _, p := range inactive {
+		if p.IsNew {
+			newLines = append(newLines, p.describe(now))
+		} else {
+			oldLines = append(oldLines, p.describe(now))
+		}
+	}
+	body := fmt.Sprintf("These participants have become inactive (no use in the last %d days):\n\n%s\n",
+		study.InactivityDays, strings.Join(newLines, "\n"))
+	if len(oldLines) > 0 {
+		body += fmt.Sprintf("\nThese participants are still inactive:\n\n%s\n", strings.Join(oldLines, "\n"))
+	}
+	if study.InactivityMessage != "" {
+		body += "\nThe newly inactive participants have been sent the study's inactivity message.\n"
+	}
+	subject := fmt.Sprintf("In My Voice inactive participants for %s", study.Name)
+	if err := services.SendAlertViaEmail(emails, subject, body); err != nil {
+		sLog().Error("failed to send inactivity digest email",
+			zap.String("studyId", study.Id), zap.Strings("emails", emails), zap.Error(err))
+		return err
+	}
+	return nil
+}
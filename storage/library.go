@@ -0,0 +1,221 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+// A PhraseLibrary is a named set of phrases that a study gives to all its participants.
+type PhraseLibrary struct {
+	StudyId string
+	Id      string
+	Name    string
+	Phrases []string
+	Updated int64 // Unix time in milliseconds
+}
+
+func (l *PhraseLibrary) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(l); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (l *PhraseLibrary) FromRedis(b []byte) error {
+	*l = PhraseLibrary{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(l)
+}
+
+// The PhraseLibraryIndex of a study ID maps from library ID to PhraseLibrary.
+type PhraseLibraryIndex string
+
+func (i PhraseLibraryIndex) StoragePrefix() string {
+	return "phrase-libraries:"
+}
+func (i PhraseLibraryIndex) StorageId() string {
+	return string(i)
+}
+
+// The LibraryPhraseSet of a study ID holds the hashes of all the phrases in its libraries.
+type LibraryPhraseSet string
+
+func (s LibraryPhraseSet) StoragePrefix() string {
+	return "library-phrases:"
+}
+func (s LibraryPhraseSet) StorageId() string {
+	return string(s)
+}
+
+// normalizePhrase puts phrase content in the form used for phrase stats.
+func normalizePhrase(text string) string {
+	return strings.ToLower(whitespace.ReplaceAllLiteralString(strings.TrimSpace(text), " "))
+}
+
+// ParsePhrases splits text into phrases, one per non-blank line.
+func ParsePhrases(text string) []string {
+	var phrases []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			phrases = append(phrases, line)
+		}
+	}
+	return phrases
+}
+
+func NewPhraseLibrary(studyId, name string, phrases []string) *PhraseLibrary {
+	return &PhraseLibrary{
+		StudyId: studyId,
+		Id:      uuid.NewString(),
+		Name:    name,
+		Phrases: phrases,
+	}
+}
+
+func GetPhraseLibrary(studyId, libraryId string) (*PhraseLibrary, error) {
+	val, err := platform.MapGet(sCtx(), PhraseLibraryIndex(studyId), libraryId)
+	if err != nil {
+		sLog().Error("db failure on phrase library fetch",
+			zap.String("studyId", studyId), zap.String("libraryId", libraryId), zap.Error(err))
+		return nil, err
+	}
+	if val == "" {
+		return nil, nil
+	}
+	l := new(PhraseLibrary)
+	if err := l.FromRedis([]byte(val)); err != nil {
+		sLog().Error("deserialization failure on phrase library",
+			zap.String("studyId", studyId), zap.String("libraryId", libraryId), zap.Error(err))
+		return nil, err
+	}
+	return l, nil
+}
+
+func GetAllPhraseLibraries(studyId string) ([]*PhraseLibrary, error) {
+	m, err := platform.MapGetAll(sCtx(), PhraseLibraryIndex(studyId))
+	if err != nil {
+		sLog().Error("db failure on phrase libraries fetch",
+			zap.String("studyId", studyId), zap.Error(err))
+		return nil, err
+	}
+	result := make([]*PhraseLibrary, 0, len(m))
+	for id, val := range m {
+		l := new(PhraseLibrary)
+		if err := l.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on phrase library",
+				zap.String("studyId", studyId), zap.String("libraryId", id), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, l)
+	}
+	return result, nil
+}
+
+// PhraseLibrariesETag returns a tag that changes whenever any of the libraries change.
+func PhraseLibrariesETag(libraries []*PhraseLibrary) string {
+	hasher := md5.New()
+	for _, l := range libraries {
+		_, _ = fmt.Fprintf(hasher, "%s:%d\n", l.Id, l.Updated)
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+// SavePhraseLibrary stores the library and notifies the study's enrolled participants.
+func SavePhraseLibrary(l *PhraseLibrary) error {
+	l.Updated = time.Now().UnixMilli()
+	if err := l.save(); err != nil {
+		return err
+	}
+	return phraseLibrariesDidChange(l.StudyId)
+}
+
+func (l *PhraseLibrary) save() error {
+	b, err := l.ToRedis()
+	if err != nil {
+		sLog().Error("serialization failure on phrase library",
+			zap.String("studyId", l.StudyId), zap.String("libraryId", l.Id), zap.Error(err))
+		return err
+	}
+	if err := platform.MapSet(sCtx(), PhraseLibraryIndex(l.StudyId), l.Id, string(b)); err != nil {
+		sLog().Error("db failure on phrase library save",
+			zap.String("studyId", l.StudyId), zap.String("libraryId", l.Id), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DeletePhraseLibrary removes the library and notifies the study's enrolled participants.
+func DeletePhraseLibrary(studyId, libraryId string) error {
+	if err := platform.MapRemove(sCtx(), PhraseLibraryIndex(studyId), libraryId); err != nil {
+		sLog().Error("db failure on phrase library delete",
+			zap.String("studyId", studyId), zap.String("libraryId", libraryId), zap.Error(err))
+		return err
+	}
+	return phraseLibrariesDidChange(studyId)
+}
+
+// IsLibraryPhrase returns whether the (normalized) phrase is in one of the study's libraries.
+func IsLibraryPhrase(studyId, text string) (bool, error) {
+	return platform.IsMember(sCtx(), LibraryPhraseSet(studyId), phraseHash(text))
+}
+
+// phraseLibrariesDidChange rebuilds the study's set of library phrases,
+// and tells the clients of all enrolled participants that their favorites have changed.
+func phraseLibrariesDidChange(studyId string) error {
+	if err := rebuildLibraryPhraseSet(studyId); err != nil {
+		return err
+	}
+	participants, err := GetAllStudyParticipants(studyId)
+	if err != nil {
+		return err
+	}
+	for _, p := range participants {
+		if p.ProfileId != "" && p.Started > 0 && p.Finished == 0 {
+			if err := ProfileClientFavoritesDidUpdate(p.ProfileId, "none"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildLibraryPhraseSet replaces the study's set of library phrases with the phrases in its libraries.
+func rebuildLibraryPhraseSet(studyId string) error {
+	libraries, err := GetAllPhraseLibraries(studyId)
+	if err != nil {
+		return err
+	}
+	var hashes []string
+	for _, l := range libraries {
+		for _, phrase := range l.Phrases {
+			hashes = append(hashes, phraseHash(normalizePhrase(phrase)))
+		}
+	}
+	s := LibraryPhraseSet(studyId)
+	if err := platform.DeleteStorage(sCtx(), s); err != nil {
+		sLog().Error("db failure on library phrases delete",
+			zap.String("studyId", studyId), zap.Error(err))
+		return err
+	}
+	if len(hashes) > 0 {
+		if err := platform.AddMembers(sCtx(), s, hashes...); err != nil {
+			sLog().Error("db failure on library phrases save",
+				zap.String("studyId", studyId), zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
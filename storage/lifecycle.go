@@ -77,9 +77,12 @@ func ObserveClientLaunch(clientType, clientId, profileId string) {
 			zap.String("clientType", clientType), zap.String("clientId", clientId),
 			zap.String("profileId", profileId), zap.Error(err))
 	}
+	recordSessionEvent(SessionEventLaunch, clientType, clientId, profileId, l.LastLaunch)
 }
 
-func ObserveClientActive(clientId, profileId string) {
+// ObserveClientActive records that the client has come to the foreground
+// or gone to the background.
+func ObserveClientActive(clientId, profileId string, kind SessionEventKind) {
 	l := &LifecycleData{ClientId: clientId, ProfileId: profileId}
 	if err := platform.LoadObject(sCtx(), l); err != nil {
 		sLog().Error("db failure on client active",
@@ -91,6 +94,7 @@ func ObserveClientActive(clientId, profileId string) {
 		sLog().Error("db failure on client active",
 			zap.String("profileId", profileId), zap.Error(err))
 	}
+	recordSessionEvent(kind, l.ClientType, clientId, profileId, l.LastActive)
 }
 
 func ObserveClientShutdown(clientId, profileId string) {
@@ -105,6 +109,7 @@ func ObserveClientShutdown(clientId, profileId string) {
 		sLog().Error("db failure on client shutdown",
 			zap.String("profileId", profileId), zap.Error(err))
 	}
+	recordSessionEvent(SessionEventShutdown, l.ClientType, clientId, profileId, l.LastShutdown)
 }
 
 type NotifiedSpeechClients string
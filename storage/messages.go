@@ -0,0 +1,461 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+// A StudyMessage is a message sent by a study's researchers to some of its participants.
+type StudyMessage struct {
+	StudyId    string
+	Id         string
+	Title      string
+	Body       string
+	Author     string // email of the admin who sent it
+	Sent       int64  // Unix time in milliseconds
+	Recipients map[string]string
+}
+
+func (m *StudyMessage) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(m); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (m *StudyMessage) FromRedis(b []byte) error {
+	*m = StudyMessage{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(m)
+}
+
+// The StudyMessageIndex of a study ID maps from message ID to StudyMessage.
+type StudyMessageIndex string
+
+func (i StudyMessageIndex) StoragePrefix() string {
+	return "study-messages:"
+}
+func (i StudyMessageIndex) StorageId() string {
+	return string(i)
+}
+
+// A ProfileMessage is a participant's copy of a study message, with its receipts.
+type ProfileMessage struct {
+	Id        string
+	StudyId   string
+	Title     string
+	Body      string
+	Sent      int64 // Unix time in milliseconds
+	Delivered int64 // Unix time in milliseconds, 0 if not yet fetched by a client
+	Read      int64 // Unix time in milliseconds, 0 if not yet read
+}
+
+func (m *ProfileMessage) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(m); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (m *ProfileMessage) FromRedis(b []byte) error {
+	*m = ProfileMessage{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(m)
+}
+
+// The ProfileMessageIndex of a profile ID maps from message ID to ProfileMessage.
+type ProfileMessageIndex string
+
+func (i ProfileMessageIndex) StoragePrefix() string {
+	return "profile-messages:"
+}
+func (i ProfileMessageIndex) StorageId() string {
+	return string(i)
+}
+
+// The UndeliveredMessageSet of a profile ID holds the IDs of the messages
+// that haven't yet been fetched by any of its clients.
+type UndeliveredMessageSet string
+
+func (s UndeliveredMessageSet) StoragePrefix() string {
+	return "undelivered-messages:"
+}
+func (s UndeliveredMessageSet) StorageId() string {
+	return string(s)
+}
+
+type MessageTarget = string
+
+const (
+	MessageTargetAll      MessageTarget = "all"      // all enrolled participants
+	MessageTargetInactive MessageTarget = "inactive" // enrolled participants flagged as inactive
+	MessageTargetSelected MessageTarget = "selected" // the chosen enrolled participants
+)
+
+var NoMessageRecipientsError = errors.New("no enrolled participants match the message target")
+
+// SelectMessageRecipients returns a map from UPN to profile ID of the study's
+// enrolled participants that match the target.
+func SelectMessageRecipients(studyId string, target MessageTarget, upns []string) (map[string]string, error) {
+	participants, err := GetAllStudyParticipants(studyId)
+	if err != nil {
+		return nil, err
+	}
+	var inactive []string
+	if target == MessageTargetInactive {
+		if inactive, err = platform.FetchMembers(sCtx(), InactiveParticipants(studyId)); err != nil {
+			sLog().Error("db failure on inactive participants fetch",
+				zap.String("studyId", studyId), zap.Error(err))
+			return nil, err
+		}
+	}
+	recipients := make(map[string]string)
+	for _, p := range participants {
+		if p.ProfileId == "" || p.Started == 0 || p.Finished > 0 {
+			continue
+		}
+		switch target {
+		case MessageTargetInactive:
+			if !slices.Contains(inactive, p.Upn) {
+				continue
+			}
+		case MessageTargetSelected:
+			if !slices.Contains(upns, p.Upn) {
+				continue
+			}
+		}
+		recipients[p.Upn] = p.ProfileId
+	}
+	if len(recipients) == 0 {
+		return nil, NoMessageRecipientsError
+	}
+	return recipients, nil
+}
+
+// SendStudyMessage saves the message and queues a copy for each recipient.
+func SendStudyMessage(studyId, author, title, body string, recipients map[string]string) (*StudyMessage, error) {
+	m := &StudyMessage{
+		StudyId:    studyId,
+		Id:         uuid.NewString(),
+		Title:      title,
+		Body:       body,
+		Author:     author,
+		Sent:       time.Now().UnixMilli(),
+		Recipients: recipients,
+	}
+	if err := m.save(); err != nil {
+		return nil, err
+	}
+	pm := &ProfileMessage{Id: m.Id, StudyId: studyId, Title: title, Body: body, Sent: m.Sent}
+	for _, profileId := range recipients {
+		if err := pm.save(profileId); err != nil {
+			return nil, err
+		}
+	}
+	sLog().Info("study message sent", zap.String("studyId", studyId),
+		zap.String("messageId", m.Id), zap.Int("recipients", len(recipients)))
+	return m, nil
+}
+
+func (m *StudyMessage) save() error {
+	b, err := m.ToRedis()
+	if err != nil {
+		sLog().Error("serialization failure on study message",
+			zap.String("studyId", m.StudyId), zap.String("messageId", m.Id), zap.Error(err))
+		return err
+	}
+	if err := platform.MapSet(sCtx(), StudyMessageIndex(m.StudyId), m.Id, string(b)); err != nil {
+		sLog().Error("db failure on study message save",
+			zap.String("studyId", m.StudyId), zap.String("messageId", m.Id), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// save stores the profile's copy of the message, which is pending until a client fetches it.
+func (pm *ProfileMessage) save(profileId string) error {
+	b, err := pm.ToRedis()
+	if err != nil {
+		sLog().Error("serialization failure on profile message",
+			zap.String("profileId", profileId), zap.String("messageId", pm.Id), zap.Error(err))
+		return err
+	}
+	if err := platform.MapSet(sCtx(), ProfileMessageIndex(profileId), pm.Id, string(b)); err != nil {
+		sLog().Error("db failure on profile message save",
+			zap.String("profileId", profileId), zap.String("messageId", pm.Id), zap.Error(err))
+		return err
+	}
+	if pm.Delivered != 0 {
+		return nil
+	}
+	if err := platform.AddMembers(sCtx(), UndeliveredMessageSet(profileId), pm.Id); err != nil {
+		sLog().Error("db failure on undelivered message add",
+			zap.String("profileId", profileId), zap.String("messageId", pm.Id), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func GetStudyMessage(studyId, messageId string) (*StudyMessage, error) {
+	val, err := platform.MapGet(sCtx(), StudyMessageIndex(studyId), messageId)
+	if err != nil {
+		sLog().Error("db failure on study message fetch",
+			zap.String("studyId", studyId), zap.String("messageId", messageId), zap.Error(err))
+		return nil, err
+	}
+	if val == "" {
+		return nil, nil
+	}
+	m := new(StudyMessage)
+	if err := m.FromRedis([]byte(val)); err != nil {
+		sLog().Error("deserialization failure on study message",
+			zap.String("studyId", studyId), zap.String("messageId", messageId), zap.Error(err))
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetAllStudyMessages returns the study's messages, newest first.
+func GetAllStudyMessages(studyId string) ([]*StudyMessage, error) {
+	vals, err := platform.MapGetAll(sCtx(), StudyMessageIndex(studyId))
+	if err != nil {
+		sLog().Error("db failure on study messages fetch",
+			zap.String("studyId", studyId), zap.Error(err))
+		return nil, err
+	}
+	result := make([]*StudyMessage, 0, len(vals))
+	for id, val := range vals {
+		m := new(StudyMessage)
+		if err := m.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on study message",
+				zap.String("studyId", studyId), zap.String("messageId", id), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	slices.SortFunc(result, func(a, b *StudyMessage) int {
+		return int(b.Sent - a.Sent)
+	})
+	return result, nil
+}
+
+// A MessageReceipt tells when a recipient's clients fetched and read a message.
+// If the recipient's copy of the message is gone, both times are 0.
+type MessageReceipt struct {
+	Upn       string
+	Delivered int64
+	Read      int64
+}
+
+// GetMessageReceipts returns the receipts for all the message's recipients, ordered by UPN.
+func (m *StudyMessage) GetMessageReceipts() ([]MessageReceipt, error) {
+	receipts := make([]MessageReceipt, 0, len(m.Recipients))
+	for upn, profileId := range m.Recipients {
+		pm, err := getProfileMessage(profileId, m.Id)
+		if err != nil {
+			return nil, err
+		}
+		r := MessageReceipt{Upn: upn}
+		if pm != nil {
+			r.Delivered, r.Read = pm.Delivered, pm.Read
+		}
+		receipts = append(receipts, r)
+	}
+	slices.SortFunc(receipts, func(a, b MessageReceipt) int {
+		return strings.Compare(a.Upn, b.Upn)
+	})
+	return receipts, nil
+}
+
+// DeleteStudyMessage removes the message, including any recipient copies.
+func DeleteStudyMessage(studyId, messageId string) error {
+	m, err := GetStudyMessage(studyId, messageId)
+	if err != nil || m == nil {
+		return err
+	}
+	for _, profileId := range m.Recipients {
+		if err := removeProfileMessage(profileId, messageId); err != nil {
+			return err
+		}
+	}
+	if err := platform.MapRemove(sCtx(), StudyMessageIndex(studyId), messageId); err != nil {
+		sLog().Error("db failure on study message delete",
+			zap.String("studyId", studyId), zap.String("messageId", messageId), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func deleteAllStudyMessages(studyId string) error {
+	messages, err := GetAllStudyMessages(studyId)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		if err := DeleteStudyMessage(studyId, m.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeProfileMessage(profileId, messageId string) error {
+	if err := platform.MapRemove(sCtx(), ProfileMessageIndex(profileId), messageId); err != nil {
+		sLog().Error("db failure on profile message delete",
+			zap.String("profileId", profileId), zap.String("messageId", messageId), zap.Error(err))
+		return err
+	}
+	if err := platform.RemoveMembers(sCtx(), UndeliveredMessageSet(profileId), messageId); err != nil {
+		sLog().Error("db failure on undelivered message remove",
+			zap.String("profileId", profileId), zap.String("messageId", messageId), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func getProfileMessage(profileId, messageId string) (*ProfileMessage, error) {
+	val, err := platform.MapGet(sCtx(), ProfileMessageIndex(profileId), messageId)
+	if err != nil {
+		sLog().Error("db failure on profile message fetch",
+			zap.String("profileId", profileId), zap.String("messageId", messageId), zap.Error(err))
+		return nil, err
+	}
+	if val == "" {
+		return nil, nil
+	}
+	pm := new(ProfileMessage)
+	if err := pm.FromRedis([]byte(val)); err != nil {
+		sLog().Error("deserialization failure on profile message",
+			zap.String("profileId", profileId), zap.String("messageId", messageId), zap.Error(err))
+		return nil, err
+	}
+	return pm, nil
+}
+
+// GetAllProfileMessages returns all the profile's messages, oldest first,
+// without recording their delivery.
+func GetAllProfileMessages(profileId string) ([]*ProfileMessage, error) {
+	vals, err := platform.MapGetAll(sCtx(), ProfileMessageIndex(profileId))
+	if err != nil {
+		sLog().Error("db failure on profile messages fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return nil, err
+	}
+	result := make([]*ProfileMessage, 0, len(vals))
+	for id, val := range vals {
+		pm := new(ProfileMessage)
+		if err := pm.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on profile message",
+				zap.String("profileId", profileId), zap.String("messageId", id), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, pm)
+	}
+	slices.SortFunc(result, func(a, b *ProfileMessage) int {
+		return int(a.Sent - b.Sent)
+	})
+	return result, nil
+}
+
+// ProfileHasPendingMessages returns whether the profile has messages that no client has fetched.
+func ProfileHasPendingMessages(profileId string) (bool, error) {
+	ids, err := platform.FetchMembers(sCtx(), UndeliveredMessageSet(profileId))
+	if err != nil {
+		sLog().Error("db failure on undelivered messages fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return false, err
+	}
+	return len(ids) > 0, nil
+}
+
+// FetchProfileMessages returns all the profile's messages, oldest first,
+// recording the delivery of any that haven't been fetched before.
+func FetchProfileMessages(profileId string) ([]*ProfileMessage, error) {
+	vals, err := platform.MapGetAll(sCtx(), ProfileMessageIndex(profileId))
+	if err != nil {
+		sLog().Error("db failure on profile messages fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	result := make([]*ProfileMessage, 0, len(vals))
+	for id := range vals {
+		pm := new(ProfileMessage)
+		update := func(found bool) (bool, error) {
+			if !found || pm.Delivered != 0 {
+				return false, nil
+			}
+			pm.Delivered = now
+			return true, nil
+		}
+		if _, err := platform.MapUpdateValue(sCtx(), ProfileMessageIndex(profileId), id, pm, update); err != nil {
+			sLog().Error("db failure on profile message delivery",
+				zap.String("profileId", profileId), zap.String("messageId", id), zap.Error(err))
+			return nil, err
+		}
+		if pm.Id == "" {
+			// deleted since we listed it
+			continue
+		}
+		result = append(result, pm)
+	}
+	// only clear the messages being returned, so any sent since the listing stay pending
+	ids := make([]string, 0, len(result))
+	for _, pm := range result {
+		ids = append(ids, pm.Id)
+	}
+	if len(ids) > 0 {
+		if err := platform.RemoveMembers(sCtx(), UndeliveredMessageSet(profileId), ids...); err != nil {
+			sLog().Error("db failure on undelivered messages remove",
+				zap.String("profileId", profileId), zap.Error(err))
+			return nil, err
+		}
+	}
+	slices.SortFunc(result, func(a, b *ProfileMessage) int {
+		return int(a.Sent - b.Sent)
+	})
+	return result, nil
+}
+
+// MarkProfileMessageRead records that the message has been read, and returns it.
+// It returns nil if the profile has no such message.
+func MarkProfileMessageRead(profileId, messageId string) (*ProfileMessage, error) {
+	pm := new(ProfileMessage)
+	now := time.Now().UnixMilli()
+	update := func(found bool) (bool, error) {
+		if !found || pm.Read != 0 {
+			return false, nil
+		}
+		pm.Read = now
+		if pm.Delivered == 0 {
+			pm.Delivered = now
+		}
+		return true, nil
+	}
+	if _, err := platform.MapUpdateValue(sCtx(), ProfileMessageIndex(profileId), messageId, pm, update); err != nil {
+		sLog().Error("db failure on profile message read",
+			zap.String("profileId", profileId), zap.String("messageId", messageId), zap.Error(err))
+		return nil, err
+	}
+	if pm.Id == "" {
+		return nil, nil
+	}
+	if err := platform.RemoveMembers(sCtx(), UndeliveredMessageSet(profileId), messageId); err != nil {
+		sLog().Error("db failure on undelivered message remove",
+			zap.String("profileId", profileId), zap.String("messageId", messageId), zap.Error(err))
+		return nil, err
+	}
+	return pm, nil
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+// The schema migrations for stored types, in the order they must be run.
+//
+// The version 1 migrations establish a baseline: they check that every stored value
+// decodes with the current type definition, and re-encode any that don't round-trip.
+func init() {
+	platform.RegisterMigration(platform.Migration{
+		Type:        "Study",
+		Version:     1,
+		Description: "baseline study schema",
+		Run: func(ctx context.Context, dryRun bool, progress func()) (int64, error) {
+			return migrateMapValues[Study](ctx, "map:study-index", dryRun, progress, nil)
+		},
+	})
+	platform.RegisterMigration(platform.Migration{
+		Type:        "Study",
+		Version:     2,
+		Description: "default phrase privacy settings",
+		Run: func(ctx context.Context, dryRun bool, progress func()) (int64, error) {
+			upgrade := func(s *Study) bool {
+				if s.PhraseRedaction != "" {
+					return false
+				}
+				s.PhraseMinParticipants = DefaultPhraseMinParticipants
+				s.PhraseRedaction = PhraseRedactionRedact
+				s.PhraseScrubPII = true
+				return true
+			}
+			return migrateMapValues[Study](ctx, "map:study-index", dryRun, progress, upgrade)
+		},
+	})
+	platform.RegisterMigration(platform.Migration{
+		Type:        "Study",
+		Version:     3,
+		Description: "secret keys for phrase hashes",
+		Run: func(ctx context.Context, dryRun bool, progress func()) (int64, error) {
+			upgrade := func(s *Study) bool {
+				if s.PhraseHashKey != "" {
+					return false
+				}
+				s.PhraseHashKey = uuid.NewString()
+				return true
+			}
+			return migrateMapValues[Study](ctx, "map:study-index", dryRun, progress, upgrade)
+		},
+	})
+	platform.RegisterMigration(platform.Migration{
+		Type:        "StudyParticipant",
+		Version:     1,
+		Description: "baseline participant schema",
+		Run: func(ctx context.Context, dryRun bool, progress func()) (int64, error) {
+			return migrateMapValues[StudyParticipant](ctx, ParticipantIndex("").StoragePrefix(), dryRun, progress, nil)
+		},
+	})
+	platform.RegisterMigration(platform.Migration{
+		Type:        "StudyReport",
+		Version:     1,
+		Description: "baseline report schema",
+		Run: func(ctx context.Context, dryRun bool, progress func()) (int64, error) {
+			return migrateMapValues[StudyReport](ctx, ReportIndex("").StoragePrefix(), dryRun, progress, nil)
+		},
+	})
+	platform.RegisterMigration(platform.Migration{
+		Type:        "StudyReport",
+		Version:     2,
+		Description: "record the format of existing (spreadsheet) reports",
+		Run: func(ctx context.Context, dryRun bool, progress func()) (int64, error) {
+			upgrade := func(r *StudyReport) bool {
+				if r.Format != "" {
+					return false
+				}
+				r.Format = ReportFormatXlsx
+				return true
+			}
+			return migrateMapValues[StudyReport](ctx, ReportIndex("").StoragePrefix(), dryRun, progress, upgrade)
+		},
+	})
+}
+
+// migrateMapValues upgrades every value in every map whose key has the given prefix.
+// If the upgrade function is non-nil, it's applied to each decoded value, and
+// returns whether it changed the value. Values that don't re-encode to their
+// stored form are also counted as changed. Only changed values are stored.
+func migrateMapValues[T any, PT interface {
+	*T
+	platform.RedisValue
+}](ctx context.Context, prefix string, dryRun bool, progress func(), upgrade func(PT) bool) (int64, error) {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(ctx, collect, platform.PrefixedKey{Prefix: prefix}); err != nil {
+		return 0, err
+	}
+	var changed int64
+	for _, id := range ids {
+		key := platform.PrefixedKey{Prefix: prefix, Id: id}
+		m, err := platform.MapGetAll(ctx, key)
+		if err != nil {
+			return changed, err
+		}
+		for field, val := range m {
+			v := PT(new(T))
+			if err := v.FromRedis([]byte(val)); err != nil {
+				return changed, fmt.Errorf("decode %s%s field %q: %w", prefix, id, field, err)
+			}
+			modified := upgrade != nil && upgrade(v)
+			b, err := v.ToRedis()
+			if err != nil {
+				return changed, fmt.Errorf("encode %s%s field %q: %w", prefix, id, field, err)
+			}
+			if modified || string(b) != val {
+				changed++
+				if !dryRun {
+					if err := platform.MapSet(ctx, key, field, string(b)); err != nil {
+						return changed, err
+					}
+				}
+			}
+			progress()
+		}
+	}
+	return changed, nil
+}
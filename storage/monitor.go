@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"math"
 	"time"
 
 	"github.com/whisper-project/in-my-voice.server.golang/platform"
@@ -84,6 +85,16 @@ func RemoveMonitor(profileId string) error {
 	return nil
 }
 
+// GetAllMonitoredProfiles returns the profile IDs that are scheduled for monitoring.
+func GetAllMonitoredProfiles() ([]string, error) {
+	profiles, err := platform.FetchRangeScoreInterval(sCtx(), speechMonitors, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		sLog().Error("Failed to fetch monitored profiles", zap.Error(err))
+		return nil, err
+	}
+	return profiles, nil
+}
+
 func FetchMonitorsForUpdate(ctx context.Context) ([]*SpeechMonitor, error) {
 	now := float64(time.Now().Unix())
 	profiles, err := platform.FetchRangeScoreInterval(ctx, speechMonitors, -1, now)
@@ -162,6 +173,7 @@ func (s *SpeechMonitor) Update(ctx context.Context) error {
 	if s.LimitChars > 0 {
 		curPct = s.UsedChars * 100 / s.LimitChars
 	}
+	checkUsageAlerts(ctx, s, curPct)
 	rateDelay := ((s.LimitChars - s.UsedChars) * 24 * 3600) / maxCharsPerDay
 	rateDelay = min(rateDelay, maxRateDelay)
 	s.NextCheck = min(time.Now().Unix()+rateDelay, s.NextRenew)
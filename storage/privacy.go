@@ -0,0 +1,65 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+type PhraseRedaction = string
+
+const (
+	PhraseRedactionRedact PhraseRedaction = "redact" // replace the content with a placeholder
+	PhraseRedactionHash   PhraseRedaction = "hash"   // replace the content with its keyed hash
+)
+
+// DefaultPhraseMinParticipants is the privacy threshold given to new studies.
+const DefaultPhraseMinParticipants = 3
+
+const redactedPhrase = "[redacted]"
+
+// PhraseDisplay returns the content of a phrase as it should appear in a report,
+// given the number of distinct participants who used it. Raw content is only
+// returned if requested, and should only be shown to data stewards.
+func (s *Study) PhraseDisplay(stat *PhraseStat, participants int64, raw bool) string {
+	if raw {
+		return stat.Content
+	}
+	if participants < s.PhraseMinParticipants {
+		// the stored hash isn't keyed, so rare phrases could be recovered from it by
+		// hashing guesses; the study's secret key prevents that
+		if s.PhraseRedaction == PhraseRedactionHash && s.PhraseHashKey != "" {
+			mac := hmac.New(sha256.New, []byte(s.PhraseHashKey))
+			mac.Write([]byte(stat.Content))
+			return "#" + fmt.Sprintf("%x", mac.Sum(nil))[:16]
+		}
+		return redactedPhrase
+	}
+	if s.PhraseScrubPII {
+		return platform.ScrubPII(stat.Content)
+	}
+	return stat.Content
+}
+
+// CountPhraseParticipants returns the number of distinct participants who used
+// each phrase (keyed by hash) on the days that overlap the given time range.
+func CountPhraseParticipants(studyId string, start, end int64) (map[string]int64, error) {
+	stats, err := FetchAllParticipantPhraseStats(studyId, start, end, nil)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	for _, s := range stats {
+		// each participant has at most one stat per phrase
+		counts[s.Stat.Hash]++
+	}
+	return counts, nil
+}
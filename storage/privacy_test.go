@@ -0,0 +1,35 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPhraseDisplayHashIsKeyed(t *testing.T) {
+	stat := &PhraseStat{Content: "call Jane at home", Hash: phraseHash("call Jane at home")}
+	s1 := &Study{PhraseMinParticipants: 3, PhraseRedaction: PhraseRedactionHash, PhraseHashKey: "key-one"}
+	s2 := &Study{PhraseMinParticipants: 3, PhraseRedaction: PhraseRedactionHash, PhraseHashKey: "key-two"}
+	d1, d2 := s1.PhraseDisplay(stat, 1, false), s2.PhraseDisplay(stat, 1, false)
+	if !strings.HasPrefix(d1, "#") || strings.Contains(d1, stat.Hash) {
+		t.Errorf("Hashed display %q should be keyed, not the stored hash %q", d1, stat.Hash)
+	}
+	if d1 != s1.PhraseDisplay(stat, 2, false) {
+		t.Errorf("Hashed display isn't stable for the same study")
+	}
+	if d1 == d2 {
+		t.Errorf("Hashed display is the same for studies with different keys: %q", d1)
+	}
+	s1.PhraseHashKey = ""
+	if d := s1.PhraseDisplay(stat, 1, false); d != redactedPhrase {
+		t.Errorf("Hashed display without a key is %q, expected %q", d, redactedPhrase)
+	}
+	if d := s1.PhraseDisplay(stat, 3, false); d != stat.Content {
+		t.Errorf("Display of phrase at the threshold is %q, expected the content", d)
+	}
+}
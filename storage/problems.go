@@ -0,0 +1,266 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"github.com/whisper-project/in-my-voice.server.golang/services"
+	"go.uber.org/zap"
+)
+
+type ProblemKind = string
+
+const (
+	ProblemKindAnomaly       ProblemKind = "anomaly"
+	ProblemKindSpeechFailure ProblemKind = "speech-failure"
+)
+
+// A ProblemReport collects all the client reports of the same problem from the same profile.
+//
+// Each ProblemReport is stored at its own key, so that reports of different problems
+// don't contend with each other, and its fingerprint is kept in the problemIndex set.
+type ProblemReport struct {
+	Fingerprint string
+	Kind        ProblemKind
+	ClientType  string
+	ClientId    string // the most recent client to report the problem
+	ProfileId   string
+	StudyId     string
+	Upn         string
+	Message     string
+	Detail      string
+	Count       int64
+	RecentCount int64 // count since the problem was last resolved
+	FirstSeen   int64 // Unix time in milliseconds
+	LastSeen    int64 // Unix time in milliseconds
+	Resolved    int64 // Unix time in milliseconds, 0 if open
+}
+
+func (p *ProblemReport) StoragePrefix() string {
+	return "problem-report:"
+}
+func (p *ProblemReport) StorageId() string {
+	return p.Fingerprint
+}
+
+func (p *ProblemReport) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(p); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (p *ProblemReport) FromRedis(b []byte) error {
+	*p = ProblemReport{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(p)
+}
+
+var (
+	// the global set of the fingerprints of stored problem reports
+	problemIndex = platform.StorableSet("problem-reports")
+)
+
+const (
+	maxProblemTextLength = 2000 // longer messages and details are truncated
+	maxProblemReports    = 5000 // reports of new problems are dropped once there are about this many
+)
+
+var ProblemReportLimitError = errors.New("too many problem reports")
+
+// truncateProblemText limits client-supplied text to maxProblemTextLength bytes
+func truncateProblemText(s string) string {
+	if len(s) <= maxProblemTextLength {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxProblemTextLength], "")
+}
+
+func (p *ProblemReport) save() error {
+	if err := platform.SaveObject(sCtx(), p); err != nil {
+		sLog().Error("db failure on problem report save",
+			zap.String("fingerprint", p.Fingerprint), zap.Error(err))
+		return err
+	}
+	return p.index()
+}
+
+func (p *ProblemReport) index() error {
+	if err := platform.AddMembers(sCtx(), problemIndex, p.Fingerprint); err != nil {
+		sLog().Error("db failure on problem report index",
+			zap.String("fingerprint", p.Fingerprint), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func ProblemFingerprint(kind ProblemKind, profileId, message, detail string) string {
+	return platform.MakeSha1(kind + "|" + profileId + "|" + message + "|" + detail)
+}
+
+// RecordProblemReport adds a client report to the stored report for its problem,
+// creating the stored report if this is the first time the problem has been seen.
+// A report of a resolved problem reopens it. Overlong text is truncated, and reports
+// of new problems are dropped once the number of stored reports reaches its limit.
+func RecordProblemReport(kind ProblemKind, clientType, clientId, profileId, message, detail string) (*ProblemReport, error) {
+	message, detail = truncateProblemText(message), truncateProblemText(detail)
+	fingerprint := ProblemFingerprint(kind, profileId, message, detail)
+	var studyId, upn string
+	if profileId != "" {
+		studyId, upn, _ = GetProfileStudyMembership(profileId)
+	}
+	// the count is read before the update, so simultaneous reports of new problems
+	// can take it a little over the limit
+	count, err := platform.SetSize(sCtx(), problemIndex)
+	if err != nil {
+		sLog().Error("db failure on problem report count", zap.Error(err))
+		return nil, err
+	}
+	p := &ProblemReport{Fingerprint: fingerprint}
+	created := false
+	update := func(found bool) (bool, error) {
+		now := time.Now().UnixMilli()
+		created = !found
+		if !found {
+			if count >= maxProblemReports {
+				return false, ProblemReportLimitError
+			}
+			*p = ProblemReport{
+				Fingerprint: fingerprint,
+				Kind:        kind,
+				ProfileId:   profileId,
+				Message:     message,
+				Detail:      detail,
+				FirstSeen:   now,
+			}
+		}
+		p.ClientType = clientType
+		p.ClientId = clientId
+		p.Count++
+		p.RecentCount++
+		p.LastSeen = now
+		p.Resolved = 0
+		if studyId != "" {
+			p.StudyId, p.Upn = studyId, upn
+		}
+		return true, nil
+	}
+	if _, err := platform.UpdateObject(sCtx(), p, update); err != nil {
+		if errors.Is(err, ProblemReportLimitError) {
+			sLog().Warn("dropping report of new problem: too many problem reports",
+				zap.String("kind", kind), zap.String("profileId", profileId))
+			return nil, err
+		}
+		sLog().Error("db failure on problem report update",
+			zap.String("fingerprint", fingerprint), zap.Error(err))
+		return nil, err
+	}
+	if created {
+		if err := p.index(); err != nil {
+			return nil, err
+		}
+	}
+	if p.Kind == ProblemKindSpeechFailure && p.StudyId != "" {
+		// sending mail is slow, so it's not done while the client waits
+		go notifyRepeatedSpeechFailure(*p)
+	}
+	return p, nil
+}
+
+func GetProblemReport(fingerprint string) (*ProblemReport, error) {
+	p := &ProblemReport{Fingerprint: fingerprint}
+	if err := platform.LoadObject(sCtx(), p); err != nil {
+		if errors.Is(err, platform.NotFoundError) {
+			return nil, nil
+		}
+		sLog().Error("db failure on problem report lookup",
+			zap.String("fingerprint", fingerprint), zap.Error(err))
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetAllProblemReports returns the indexed problem reports. Fingerprints in the index
+// whose reports are missing are skipped.
+func GetAllProblemReports() ([]*ProblemReport, error) {
+	fingerprints, err := platform.FetchMembers(sCtx(), problemIndex)
+	if err != nil {
+		sLog().Error("db failure on fetch of all problem reports", zap.Error(err))
+		return nil, err
+	}
+	results := make([]*ProblemReport, 0, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		p, err := GetProblemReport(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			results = append(results, p)
+		}
+	}
+	return results, nil
+}
+
+func ResolveProblemReport(fingerprint string) error {
+	p := &ProblemReport{Fingerprint: fingerprint}
+	update := func(found bool) (bool, error) {
+		if !found || p.Resolved != 0 {
+			return false, nil
+		}
+		p.Resolved = time.Now().UnixMilli()
+		p.RecentCount = 0
+		return true, nil
+	}
+	if _, err := platform.UpdateObject(sCtx(), p, update); err != nil {
+		sLog().Error("db failure on problem report resolve",
+			zap.String("fingerprint", fingerprint), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func DeleteProblemReport(fingerprint string) error {
+	if err := platform.DeleteStorage(sCtx(), &ProblemReport{Fingerprint: fingerprint}); err != nil {
+		sLog().Error("db failure on problem report delete",
+			zap.String("fingerprint", fingerprint), zap.Error(err))
+		return err
+	}
+	if err := platform.RemoveMembers(sCtx(), problemIndex, fingerprint); err != nil {
+		sLog().Error("db failure on problem report unindex",
+			zap.String("fingerprint", fingerprint), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// notifyRepeatedSpeechFailure emails the study's participant managers when a participant's
+// speech failure has recurred as many times as the study's alert setting.
+func notifyRepeatedSpeechFailure(p ProblemReport) {
+	study, err := GetStudy(p.StudyId)
+	if err != nil || study == nil || study.FailureAlertCount <= 0 || p.RecentCount != study.FailureAlertCount {
+		return
+	}
+	emails, err := GetStudyAdminEmails(study.Id, AdminRoleParticipantManager)
+	if err != nil {
+		return
+	}
+	subject := fmt.Sprintf("In My Voice speech failures for %s", study.Name)
+	body := fmt.Sprintf("Participant %s has had the same speech failure %d times since it was last resolved "+
+		"(it was first seen %s). The failure is:\n\n%s (%s)\n",
+		p.Upn, p.RecentCount, time.UnixMilli(p.FirstSeen).In(AdminTZ).Format("01/02/2006 3:04pm MST"),
+		p.Message, p.Detail)
+	if err := services.SendAlertViaEmail(emails, subject, body); err != nil {
+		sLog().Error("failed to send speech failure email",
+			zap.String("studyId", study.Id), zap.String("upn", p.Upn), zap.Error(err))
+	}
+}
@@ -0,0 +1,335 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+// ProfileMembership is the study membership of a profile, as it appears in a profile's data.
+type ProfileMembership struct {
+	StudyId     string
+	StudyName   string
+	Participant *StudyParticipant
+}
+
+// ProfileNotifications is the notification state kept for a profile's clients.
+type ProfileNotifications struct {
+	SpeechNotifiedClients []string
+	UsageNotifiedClients  []string
+	SentUsageAlerts       map[string][]string // keyed by billing period
+	PendingMessages       []string
+	UndeliveredMessages   []string
+	ConsentRequired       bool
+}
+
+// ProfileData is everything the server stores that is tied to a profile.
+type ProfileData struct {
+	ProfileId        string
+	Exported         int64 // Unix time in milliseconds
+	SpeechSettings   *SpeechSettings
+	SpeechMonitor    *SpeechMonitor
+	Favorites        *FavoritesSettings
+	FavoritesHistory []*FavoritesVersion
+	Clients          []*LifecycleData
+	Devices          []*Device
+	SessionEvents    []SessionEvent
+	Notifications    ProfileNotifications
+	Messages         []*ProfileMessage
+	ProblemReports   []*ProblemReport
+	Membership       *ProfileMembership
+}
+
+// CollectProfileData gathers all the profile's data, without changing any of it.
+func CollectProfileData(profileId string) (*ProfileData, error) {
+	d := &ProfileData{ProfileId: profileId, Exported: time.Now().UnixMilli()}
+	var err error
+	if d.SpeechSettings, err = GetSpeechSettings(profileId); err != nil {
+		return nil, err
+	}
+	m := &SpeechMonitor{ProfileId: profileId}
+	if err = platform.LoadObject(sCtx(), m); err == nil {
+		d.SpeechMonitor = m
+	} else if !errors.Is(err, platform.NotFoundError) {
+		sLog().Error("db failure on speech monitor fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return nil, err
+	}
+	if d.Favorites, err = GetFavoritesSettings(profileId); err != nil {
+		return nil, err
+	}
+	if d.FavoritesHistory, err = GetFavoritesHistory(profileId); err != nil {
+		return nil, err
+	}
+	if d.Clients, err = fetchProfileClients(profileId); err != nil {
+		return nil, err
+	}
+	if d.Devices, err = GetProfileDevices(profileId); err != nil {
+		return nil, err
+	}
+	if d.SessionEvents, err = FetchSessionEvents(profileId, 0, math.MaxInt64); err != nil {
+		return nil, err
+	}
+	if d.Notifications, err = fetchProfileNotifications(profileId); err != nil {
+		return nil, err
+	}
+	if d.Messages, err = fetchProfileMessagesQuietly(profileId); err != nil {
+		return nil, err
+	}
+	if d.ProblemReports, err = fetchProfileProblemReports(profileId); err != nil {
+		return nil, err
+	}
+	studyId, upn, err := GetProfileStudyMembership(profileId)
+	if err != nil {
+		return nil, err
+	}
+	if studyId != "" {
+		d.Membership = &ProfileMembership{StudyId: studyId}
+		if study, err := GetStudy(studyId); err != nil {
+			return nil, err
+		} else if study != nil {
+			d.Membership.StudyName = study.Name
+		}
+		if d.Membership.Participant, err = GetStudyParticipant(studyId, upn); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// fetchProfileClients returns the lifecycle data of every client used with the profile.
+func fetchProfileClients(profileId string) ([]*LifecycleData, error) {
+	var result []*LifecycleData
+	l := new(LifecycleData)
+	collect := func() error {
+		if l.ProfileId == profileId {
+			c := *l
+			result = append(result, &c)
+		}
+		return nil
+	}
+	if err := platform.MapObjects(sCtx(), collect, l); err != nil {
+		sLog().Error("db failure on lifecycle data scan", zap.Error(err))
+		return nil, err
+	}
+	return result, nil
+}
+
+// fetchSentUsageAlertPeriods returns the billing periods in which the profile was sent usage alerts.
+func fetchSentUsageAlertPeriods(profileId string) ([]string, error) {
+	var periods []string
+	collect := func(id string) error {
+		if period, ok := strings.CutPrefix(id, profileId+":"); ok {
+			periods = append(periods, period)
+		}
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, SentUsageAlerts("")); err != nil {
+		sLog().Error("db failure on sent usage alerts scan", zap.Error(err))
+		return nil, err
+	}
+	return periods, nil
+}
+
+func fetchProfileNotifications(profileId string) (ProfileNotifications, error) {
+	var n ProfileNotifications
+	var err error
+	if n.SpeechNotifiedClients, err = platform.FetchMembers(sCtx(), NotifiedSpeechClients(profileId)); err != nil {
+		sLog().Error("db failure on notified clients fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return n, err
+	}
+	if n.UsageNotifiedClients, err = platform.FetchMembers(sCtx(), NotifiedUsageClients(profileId)); err != nil {
+		sLog().Error("db failure on notified clients fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return n, err
+	}
+	periods, err := fetchSentUsageAlertPeriods(profileId)
+	if err != nil {
+		return n, err
+	}
+	n.SentUsageAlerts = make(map[string][]string, len(periods))
+	for _, period := range periods {
+		thresholds, err := platform.FetchMembers(sCtx(), SentUsageAlerts(profileId+":"+period))
+		if err != nil {
+			sLog().Error("db failure on sent usage alerts fetch",
+				zap.String("profileId", profileId), zap.Error(err))
+			return n, err
+		}
+		n.SentUsageAlerts[period] = thresholds
+	}
+	if n.PendingMessages, err = platform.FetchRange(sCtx(), PendingMessages(profileId), 0, -1); err != nil {
+		sLog().Error("db failure on pending messages fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return n, err
+	}
+	if n.UndeliveredMessages, err = platform.FetchMembers(sCtx(), UndeliveredMessageSet(profileId)); err != nil {
+		sLog().Error("db failure on undelivered messages fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return n, err
+	}
+	if n.ConsentRequired, err = ProfileNeedsConsent(profileId); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// fetchProfileMessagesQuietly returns the profile's messages without marking them delivered.
+func fetchProfileMessagesQuietly(profileId string) ([]*ProfileMessage, error) {
+	vals, err := platform.MapGetAll(sCtx(), ProfileMessageIndex(profileId))
+	if err != nil {
+		sLog().Error("db failure on profile messages fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return nil, err
+	}
+	result := make([]*ProfileMessage, 0, len(vals))
+	for id, val := range vals {
+		pm := new(ProfileMessage)
+		if err := pm.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on profile message",
+				zap.String("profileId", profileId), zap.String("messageId", id), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, pm)
+	}
+	return result, nil
+}
+
+func fetchProfileProblemReports(profileId string) ([]*ProblemReport, error) {
+	reports, err := GetAllProblemReports()
+	if err != nil {
+		return nil, err
+	}
+	var result []*ProblemReport
+	for _, r := range reports {
+		if r.ProfileId == profileId {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// WriteArchive writes the profile's data as a zip archive with one JSON file per kind of data.
+func (d *ProfileData) WriteArchive(w io.Writer) error {
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", map[string]any{"profileId": d.ProfileId, "exported": d.Exported}},
+		{"speech-settings.json", d.SpeechSettings},
+		{"speech-monitor.json", d.SpeechMonitor},
+		{"favorites.json", d.Favorites},
+		{"favorites-history.json", d.FavoritesHistory},
+		{"clients.json", d.Clients},
+		{"devices.json", d.Devices},
+		{"session-events.json", d.SessionEvents},
+		{"notifications.json", d.Notifications},
+		{"messages.json", d.Messages},
+		{"problem-reports.json", d.ProblemReports},
+		{"study-membership.json", d.Membership},
+	}
+	z := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// EraseProfileData deletes all the profile's data. If the profile is enrolled
+// in a study, it leaves the study first; the study's own records of the
+// participant are kept, because they are governed by study withdrawal.
+func EraseProfileData(profileId string) error {
+	studyId, upn, err := GetProfileStudyMembership(profileId)
+	if err != nil {
+		return err
+	}
+	if studyId != "" {
+		if err := UnenrollStudyParticipant(profileId, studyId, upn); err != nil {
+			return err
+		}
+	}
+	if err := DeleteSpeechSettings(profileId); err != nil {
+		return err
+	}
+	// deleting the settings ignores monitor failures, but erasure can't
+	if err := RemoveMonitor(profileId); err != nil {
+		return err
+	}
+	clients, err := fetchProfileClients(profileId)
+	if err != nil {
+		return err
+	}
+	for _, l := range clients {
+		if err := platform.DeleteStorage(sCtx(), l); err != nil {
+			sLog().Error("db failure on lifecycle data delete",
+				zap.String("profileId", profileId), zap.String("clientId", l.ClientId), zap.Error(err))
+			return err
+		}
+	}
+	periods, err := fetchSentUsageAlertPeriods(profileId)
+	if err != nil {
+		return err
+	}
+	keys := []platform.RedisKey{
+		&FavoritesSettings{ProfileId: profileId},
+		FavoritesHistory(profileId),
+		SessionHistory(profileId),
+		DeviceIndex(profileId),
+		ConfigETags(profileId),
+		ClientConfigCache(profileId),
+		NotifiedSpeechClients(profileId),
+		NotifiedUsageClients(profileId),
+		PendingMessages(profileId),
+		ProfileMessageIndex(profileId),
+		UndeliveredMessageSet(profileId),
+	}
+	for _, period := range periods {
+		keys = append(keys, SentUsageAlerts(profileId+":"+period))
+	}
+	for _, key := range keys {
+		if err := platform.DeleteStorage(sCtx(), key); err != nil {
+			sLog().Error("db failure on profile data delete", zap.String("profileId", profileId),
+				zap.String("prefix", key.StoragePrefix()), zap.Error(err))
+			return err
+		}
+	}
+	if err := clearProfileConsentRequired(profileId); err != nil {
+		return err
+	}
+	if err := removeProfileFlagOverrides(profileId); err != nil {
+		return err
+	}
+	reports, err := fetchProfileProblemReports(profileId)
+	if err != nil {
+		return err
+	}
+	for _, r := range reports {
+		if err := DeleteProblemReport(r.Fingerprint); err != nil {
+			return err
+		}
+	}
+	sLog().Info("profile data erased", zap.String("profileId", profileId),
+		zap.Int("clients", len(clients)), zap.Int("problemReports", len(reports)))
+	return nil
+}
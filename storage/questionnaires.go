@@ -0,0 +1,475 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+type QuestionnaireItemType = string
+
+const (
+	QuestionnaireItemScale  QuestionnaireItemType = "scale"  // an integer between Min and Max
+	QuestionnaireItemChoice QuestionnaireItemType = "choice" // one of the Choices
+	QuestionnaireItemYesNo  QuestionnaireItemType = "yesno"  // "yes" or "no"
+	QuestionnaireItemText   QuestionnaireItemType = "text"   // free text
+)
+
+// MaxTextAnswerLength is the maximum number of characters in a text answer.
+const MaxTextAnswerLength = 1000
+
+// A QuestionnaireItem is a single question. Items are numbered in the order they're
+// added to the questionnaire, and keep their numbers when the questionnaire is edited.
+type QuestionnaireItem struct {
+	Id      string
+	Prompt  string
+	Type    QuestionnaireItemType
+	Min     int64
+	Max     int64
+	Choices []string
+}
+
+type QuestionnaireSchedule = string
+
+const (
+	QuestionnaireScheduleOnce   QuestionnaireSchedule = "once"
+	QuestionnaireScheduleDaily  QuestionnaireSchedule = "daily"
+	QuestionnaireScheduleWeekly QuestionnaireSchedule = "weekly"
+)
+
+// A Questionnaire is a set of questions that enrolled participants answer on a schedule.
+// A questionnaire is due for a participant if they haven't answered it in the current
+// period of its schedule (measured in the admin time zone).
+type Questionnaire struct {
+	StudyId  string
+	Id       string
+	Name     string
+	Items    []QuestionnaireItem
+	Schedule QuestionnaireSchedule
+	Active   bool
+	Updated  int64 // Unix time in milliseconds
+	// RetiredItems are the items removed by edits, kept so earlier answers can be reported
+	RetiredItems []QuestionnaireItem
+}
+
+func (q *Questionnaire) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(q); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (q *Questionnaire) FromRedis(b []byte) error {
+	*q = Questionnaire{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(q)
+}
+
+// The QuestionnaireIndex of a study ID maps from questionnaire ID to Questionnaire.
+type QuestionnaireIndex string
+
+func (i QuestionnaireIndex) StoragePrefix() string {
+	return "questionnaires:"
+}
+func (i QuestionnaireIndex) StorageId() string {
+	return string(i)
+}
+
+// A QuestionnaireResponse is a participant's answers to a questionnaire,
+// keyed by item ID. Unanswered items are omitted.
+type QuestionnaireResponse struct {
+	QuestionnaireId string
+	Upn             string
+	Period          string
+	Submitted       int64 // Unix time in milliseconds
+	Answers         map[string]string
+}
+
+func (r *QuestionnaireResponse) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(r); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (r *QuestionnaireResponse) FromRedis(b []byte) error {
+	*r = QuestionnaireResponse{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(r)
+}
+
+// The QuestionnaireResponseList of <studyId>+<upn> is the participant's responses, oldest first.
+type QuestionnaireResponseList string
+
+func (l QuestionnaireResponseList) StoragePrefix() string {
+	return "questionnaire-responses:"
+}
+func (l QuestionnaireResponseList) StorageId() string {
+	return string(l)
+}
+
+var (
+	InvalidQuestionnaireItemError   = errors.New("invalid questionnaire item")
+	InvalidQuestionnaireAnswerError = errors.New("invalid questionnaire answer")
+	QuestionnaireNotDueError        = errors.New("questionnaire is not due")
+)
+
+// ParseQuestionnaireItems parses item definitions, one per non-blank line, in the form
+// "<type>: <prompt>". The scale type has a range ("scale 1-5: How tired are you?"),
+// and the choice type has options separated by bars ("choice Low|Medium|High: Mood?").
+func ParseQuestionnaireItems(text string) ([]QuestionnaireItem, error) {
+	var items []QuestionnaireItem
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		spec, prompt, found := strings.Cut(line, ":")
+		prompt = strings.TrimSpace(prompt)
+		if !found || prompt == "" {
+			return nil, fmt.Errorf("%w: %q has no prompt", InvalidQuestionnaireItemError, line)
+		}
+		kind, arg, _ := strings.Cut(strings.TrimSpace(spec), " ")
+		arg = strings.TrimSpace(arg)
+		item := QuestionnaireItem{Id: strconv.Itoa(len(items) + 1), Prompt: prompt, Type: strings.ToLower(kind)}
+		switch item.Type {
+		case QuestionnaireItemScale:
+			lo, hi, _ := strings.Cut(arg, "-")
+			var err1, err2 error
+			item.Min, err1 = strconv.ParseInt(strings.TrimSpace(lo), 10, 64)
+			item.Max, err2 = strconv.ParseInt(strings.TrimSpace(hi), 10, 64)
+			if err1 != nil || err2 != nil || item.Min >= item.Max {
+				return nil, fmt.Errorf("%w: %q needs a range such as 1-5", InvalidQuestionnaireItemError, line)
+			}
+		case QuestionnaireItemChoice:
+			for _, choice := range strings.Split(arg, "|") {
+				if choice = strings.TrimSpace(choice); choice != "" && !slices.Contains(item.Choices, choice) {
+					item.Choices = append(item.Choices, choice)
+				}
+			}
+			if len(item.Choices) < 2 {
+				return nil, fmt.Errorf("%w: %q needs at least two choices", InvalidQuestionnaireItemError, line)
+			}
+		case QuestionnaireItemYesNo, QuestionnaireItemText:
+		default:
+			return nil, fmt.Errorf("%w: %q has an unknown type", InvalidQuestionnaireItemError, line)
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: there are no items", InvalidQuestionnaireItemError)
+	}
+	return items, nil
+}
+
+// FormatQuestionnaireItems is the inverse of ParseQuestionnaireItems.
+func FormatQuestionnaireItems(items []QuestionnaireItem) string {
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, item.format())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (item *QuestionnaireItem) format() string {
+	spec := item.Type
+	switch item.Type {
+	case QuestionnaireItemScale:
+		spec = fmt.Sprintf("%s %d-%d", item.Type, item.Min, item.Max)
+	case QuestionnaireItemChoice:
+		spec = item.Type + " " + strings.Join(item.Choices, "|")
+	}
+	return spec + ": " + item.Prompt
+}
+
+// UpdateItems replaces the questionnaire's items with newly parsed ones. Items that are
+// unchanged (including ones that were removed and have been put back) keep their IDs,
+// so existing answers stay with their prompts. Changed and added items get new IDs,
+// and removed items are retired. IDs are never reused.
+func (q *Questionnaire) UpdateItems(items []QuestionnaireItem) {
+	old, next := slices.Concat(q.Items, q.RetiredItems), 0
+	for _, item := range old {
+		if n, err := strconv.Atoi(item.Id); err == nil && n > next {
+			next = n
+		}
+	}
+	updated := make([]QuestionnaireItem, 0, len(items))
+	for _, item := range items {
+		if i := slices.IndexFunc(old, func(o QuestionnaireItem) bool { return o.format() == item.format() }); i >= 0 {
+			item.Id = old[i].Id
+			old = slices.Delete(old, i, i+1)
+		} else {
+			next++
+			item.Id = strconv.Itoa(next)
+		}
+		updated = append(updated, item)
+	}
+	q.Items, q.RetiredItems = updated, old
+}
+
+// GetItem returns the questionnaire's current or retired item with the given ID,
+// or nil if there isn't one (or there isn't a questionnaire).
+func (q *Questionnaire) GetItem(id string) *QuestionnaireItem {
+	if q == nil {
+		return nil
+	}
+	if i := slices.IndexFunc(q.Items, func(item QuestionnaireItem) bool { return item.Id == id }); i >= 0 {
+		return &q.Items[i]
+	}
+	if i := slices.IndexFunc(q.RetiredItems, func(item QuestionnaireItem) bool { return item.Id == id }); i >= 0 {
+		return &q.RetiredItems[i]
+	}
+	return nil
+}
+
+func NewQuestionnaire(studyId, name string, items []QuestionnaireItem, schedule QuestionnaireSchedule) *Questionnaire {
+	return &Questionnaire{
+		StudyId:  studyId,
+		Id:       uuid.NewString(),
+		Name:     name,
+		Items:    items,
+		Schedule: schedule,
+		Active:   true,
+	}
+}
+
+// Period returns the schedule period of the questionnaire that contains the given time.
+func (q *Questionnaire) Period(when int64) string {
+	switch q.Schedule {
+	case QuestionnaireScheduleDaily:
+		return adminDay(when)
+	case QuestionnaireScheduleWeekly:
+		year, week := time.UnixMilli(when).In(AdminTZ).ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	default:
+		return QuestionnaireScheduleOnce
+	}
+}
+
+// ValidateAnswers checks that there are answers, and that they are to the questionnaire's
+// items and of the right type.
+func (q *Questionnaire) ValidateAnswers(answers map[string]string) error {
+	if len(answers) == 0 {
+		return fmt.Errorf("%w: there are no answers", InvalidQuestionnaireAnswerError)
+	}
+	for id, answer := range answers {
+		i := slices.IndexFunc(q.Items, func(item QuestionnaireItem) bool { return item.Id == id })
+		if i < 0 {
+			return fmt.Errorf("%w: no item %q", InvalidQuestionnaireAnswerError, id)
+		}
+		item := q.Items[i]
+		switch item.Type {
+		case QuestionnaireItemScale:
+			if v, err := strconv.ParseInt(answer, 10, 64); err != nil || v < item.Min || v > item.Max {
+				return fmt.Errorf("%w: item %q must be between %d and %d", InvalidQuestionnaireAnswerError, id, item.Min, item.Max)
+			}
+		case QuestionnaireItemChoice:
+			if !slices.Contains(item.Choices, answer) {
+				return fmt.Errorf("%w: item %q must be one of the choices", InvalidQuestionnaireAnswerError, id)
+			}
+		case QuestionnaireItemYesNo:
+			if answer != "yes" && answer != "no" {
+				return fmt.Errorf("%w: item %q must be yes or no", InvalidQuestionnaireAnswerError, id)
+			}
+		case QuestionnaireItemText:
+			if utf8.RuneCountInString(answer) > MaxTextAnswerLength {
+				return fmt.Errorf("%w: item %q is too long", InvalidQuestionnaireAnswerError, id)
+			}
+		}
+	}
+	return nil
+}
+
+func GetQuestionnaire(studyId, questionnaireId string) (*Questionnaire, error) {
+	val, err := platform.MapGet(sCtx(), QuestionnaireIndex(studyId), questionnaireId)
+	if err != nil {
+		sLog().Error("db failure on questionnaire fetch",
+			zap.String("studyId", studyId), zap.String("questionnaireId", questionnaireId), zap.Error(err))
+		return nil, err
+	}
+	if val == "" {
+		return nil, nil
+	}
+	q := new(Questionnaire)
+	if err := q.FromRedis([]byte(val)); err != nil {
+		sLog().Error("deserialization failure on questionnaire",
+			zap.String("studyId", studyId), zap.String("questionnaireId", questionnaireId), zap.Error(err))
+		return nil, err
+	}
+	return q, nil
+}
+
+// GetAllQuestionnaires returns the study's questionnaires, ordered by name.
+func GetAllQuestionnaires(studyId string) ([]*Questionnaire, error) {
+	m, err := platform.MapGetAll(sCtx(), QuestionnaireIndex(studyId))
+	if err != nil {
+		sLog().Error("db failure on questionnaires fetch",
+			zap.String("studyId", studyId), zap.Error(err))
+		return nil, err
+	}
+	result := make([]*Questionnaire, 0, len(m))
+	for id, val := range m {
+		q := new(Questionnaire)
+		if err := q.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on questionnaire",
+				zap.String("studyId", studyId), zap.String("questionnaireId", id), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, q)
+	}
+	slices.SortFunc(result, func(a, b *Questionnaire) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result, nil
+}
+
+func SaveQuestionnaire(q *Questionnaire) error {
+	q.Updated = time.Now().UnixMilli()
+	b, err := q.ToRedis()
+	if err != nil {
+		sLog().Error("serialization failure on questionnaire",
+			zap.String("studyId", q.StudyId), zap.String("questionnaireId", q.Id), zap.Error(err))
+		return err
+	}
+	if err := platform.MapSet(sCtx(), QuestionnaireIndex(q.StudyId), q.Id, string(b)); err != nil {
+		sLog().Error("db failure on questionnaire save",
+			zap.String("studyId", q.StudyId), zap.String("questionnaireId", q.Id), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DeleteQuestionnaire removes the questionnaire, but not any responses to it.
+func DeleteQuestionnaire(studyId, questionnaireId string) error {
+	if err := platform.MapRemove(sCtx(), QuestionnaireIndex(studyId), questionnaireId); err != nil {
+		sLog().Error("db failure on questionnaire delete",
+			zap.String("studyId", studyId), zap.String("questionnaireId", questionnaireId), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// FetchQuestionnaireResponses returns the participant's responses submitted in the time range.
+func FetchQuestionnaireResponses(studyId, upn string, start, end int64) ([]QuestionnaireResponse, error) {
+	vals, err := platform.FetchRange(sCtx(), QuestionnaireResponseList(studyId+"+"+upn), 0, -1)
+	if err != nil {
+		sLog().Error("db failure on questionnaire responses fetch",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return nil, err
+	}
+	responses := make([]QuestionnaireResponse, 0, len(vals))
+	for _, val := range vals {
+		var r QuestionnaireResponse
+		if err := r.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on questionnaire response",
+				zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+			return nil, err
+		}
+		if r.Submitted < start || r.Submitted > end {
+			continue
+		}
+		responses = append(responses, r)
+	}
+	return responses, nil
+}
+
+func pushQuestionnaireResponses(studyId, upn string, responses ...QuestionnaireResponse) error {
+	vals := make([]string, 0, len(responses))
+	for _, r := range responses {
+		b, err := r.ToRedis()
+		if err != nil {
+			sLog().Error("serialization failure on questionnaire response",
+				zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+			return err
+		}
+		vals = append(vals, string(b))
+	}
+	if err := platform.PushRange(sCtx(), QuestionnaireResponseList(studyId+"+"+upn), false, vals...); err != nil {
+		sLog().Error("db failure on questionnaire response push",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DueQuestionnaires returns the study's active questionnaires that the participant
+// hasn't yet answered in their current period.
+func DueQuestionnaires(studyId, upn string, now int64) ([]*Questionnaire, error) {
+	questionnaires, err := GetAllQuestionnaires(studyId)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := FetchQuestionnaireResponses(studyId, upn, 0, now)
+	if err != nil {
+		return nil, err
+	}
+	var due []*Questionnaire
+	for _, q := range questionnaires {
+		if !q.Active {
+			continue
+		}
+		period := q.Period(now)
+		answered := slices.ContainsFunc(responses, func(r QuestionnaireResponse) bool {
+			return r.QuestionnaireId == q.Id && r.Period == period
+		})
+		if !answered {
+			due = append(due, q)
+		}
+	}
+	return due, nil
+}
+
+// SubmitQuestionnaireResponse records the participant's answers to a due questionnaire.
+// The check that it's due and the recording are atomic, so at most one of several
+// simultaneous submissions for the same period is recorded.
+func SubmitQuestionnaireResponse(studyId, upn string, q *Questionnaire, answers map[string]string) (*QuestionnaireResponse, error) {
+	if err := q.ValidateAnswers(answers); err != nil {
+		return nil, err
+	}
+	if !q.Active {
+		return nil, QuestionnaireNotDueError
+	}
+	now := time.Now().UnixMilli()
+	r := QuestionnaireResponse{QuestionnaireId: q.Id, Upn: upn, Period: q.Period(now), Submitted: now, Answers: answers}
+	b, err := r.ToRedis()
+	if err != nil {
+		sLog().Error("serialization failure on questionnaire response",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return nil, err
+	}
+	isDue := func(vals []string) (bool, error) {
+		for _, val := range vals {
+			var prior QuestionnaireResponse
+			if err := prior.FromRedis([]byte(val)); err != nil {
+				return false, err
+			}
+			if prior.QuestionnaireId == r.QuestionnaireId && prior.Period == r.Period {
+				return false, QuestionnaireNotDueError
+			}
+		}
+		return true, nil
+	}
+	_, err = platform.PushRangeIf(sCtx(), QuestionnaireResponseList(studyId+"+"+upn), false, isDue, string(b))
+	if errors.Is(err, QuestionnaireNotDueError) {
+		return nil, err
+	}
+	if err != nil {
+		sLog().Error("db failure on questionnaire response push",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+func TestQuestionnaireUpdateItems(t *testing.T) {
+	items, err := ParseQuestionnaireItems("scale 1-5: How are you?\nyesno: Did you speak?\ntext: Anything else?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewQuestionnaire("study", "Check-in", items, QuestionnaireScheduleDaily)
+	// drop the first item, change the second, keep the third
+	items, err = ParseQuestionnaireItems("yesno: Did you speak today?\ntext: Anything else?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.UpdateItems(items)
+	if ids := []string{q.Items[0].Id, q.Items[1].Id}; ids[0] != "4" || ids[1] != "3" {
+		t.Errorf("Item IDs after first edit are %v, expected [4 3]", ids)
+	}
+	if len(q.RetiredItems) != 2 || q.GetItem("1").Prompt != "How are you?" || q.GetItem("2").Prompt != "Did you speak?" {
+		t.Errorf("Retired items after first edit are %#v, expected items 1 and 2", q.RetiredItems)
+	}
+	// put the first item back
+	items, err = ParseQuestionnaireItems("scale 1-5: How are you?\nyesno: Did you speak today?\ntext: Anything else?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.UpdateItems(items)
+	if ids := []string{q.Items[0].Id, q.Items[1].Id, q.Items[2].Id}; ids[0] != "1" || ids[1] != "4" || ids[2] != "3" {
+		t.Errorf("Item IDs after second edit are %v, expected [1 4 3]", ids)
+	}
+	if len(q.RetiredItems) != 1 || q.RetiredItems[0].Id != "2" {
+		t.Errorf("Retired items after second edit are %#v, expected item 2", q.RetiredItems)
+	}
+	if err := q.ValidateAnswers(map[string]string{}); !errors.Is(err, InvalidQuestionnaireAnswerError) {
+		t.Errorf("Empty answers gave %v, expected an invalid answer error", err)
+	}
+	if err := q.ValidateAnswers(map[string]string{"2": "yes"}); !errors.Is(err, InvalidQuestionnaireAnswerError) {
+		t.Errorf("Answer to retired item gave %v, expected an invalid answer error", err)
+	}
+}
+
+func TestSubmitQuestionnaireResponseConcurrently(t *testing.T) {
+	_, _ = platform.GetDb() // connect before going parallel
+	studyId, upn := uuid.NewString(), "upn-"+uuid.NewString()
+	defer func() {
+		_ = platform.DeleteStorage(sCtx(), QuestionnaireResponseList(studyId+"+"+upn))
+	}()
+	items, err := ParseQuestionnaireItems("yesno: Did you speak?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewQuestionnaire(studyId, "Check-in", items, QuestionnaireScheduleOnce)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var submitted, notDue int
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := SubmitQuestionnaireResponse(studyId, upn, q, map[string]string{q.Items[0].Id: "yes"})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				submitted++
+			case errors.Is(err, QuestionnaireNotDueError):
+				notDue++
+			default:
+				t.Errorf("SubmitQuestionnaireResponse failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if submitted != 1 || notDue != 19 {
+		t.Errorf("Parallel submissions gave %d submitted and %d not due, expected 1 and 19", submitted, notDue)
+	}
+	responses, err := FetchQuestionnaireResponses(studyId, upn, 0, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 {
+		t.Errorf("Stored %d responses, expected 1", len(responses))
+	}
+}
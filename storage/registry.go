@@ -0,0 +1,289 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+type StoredKind = string
+
+const (
+	StoredKindObject    StoredKind = "object"     // an encoded value at the key
+	StoredKindMap       StoredKind = "map"        // a map from fields to (possibly encoded) values
+	StoredKindList      StoredKind = "list"       // a list of encoded values
+	StoredKindString    StoredKind = "string"     // a plain string at the key
+	StoredKindSet       StoredKind = "set"        // a set of plain strings
+	StoredKindSortedSet StoredKind = "sorted set" // a set of plain strings ordered by score
+)
+
+// A RegisteredPrefix describes the keys that start with a given storage prefix.
+// If New is non-nil, it returns an empty value of the type encoded at the key
+// (for objects) or in the map values or list elements (for maps and lists).
+type RegisteredPrefix struct {
+	Prefix string
+	Kind   StoredKind
+	New    func() platform.RedisValue
+}
+
+var prefixRegistry = []RegisteredPrefix{
+	{"admin-user:", StoredKindObject, func() platform.RedisValue { return new(AdminUser) }},
+	{"session-key:", StoredKindString, nil},
+	{"map:study-index", StoredKindMap, func() platform.RedisValue { return new(Study) }},
+	{"study-members:", StoredKindMap, func() platform.RedisValue { return new(StudyParticipant) }},
+	{"map:profile-participant-map", StoredKindMap, nil},
+	{"typed-line-stat-list:", StoredKindList, func() platform.RedisValue { return new(TypedLineStat) }},
+	{"phrase-stats:", StoredKindMap, func() platform.RedisValue { return new(PhraseStat) }},
+	{"participant-phrase-stats:", StoredKindMap, func() platform.RedisValue { return new(PhraseStat) }},
+	{"phrase-libraries:", StoredKindMap, func() platform.RedisValue { return new(PhraseLibrary) }},
+	{"library-phrases:", StoredKindSet, nil},
+	{"questionnaires:", StoredKindMap, func() platform.RedisValue { return new(Questionnaire) }},
+	{"questionnaire-responses:", StoredKindList, func() platform.RedisValue { return new(QuestionnaireResponse) }},
+	{"consent-documents:", StoredKindMap, func() platform.RedisValue { return new(ConsentDocument) }},
+	{"consent-records:", StoredKindList, func() platform.RedisValue { return new(ConsentRecord) }},
+	{"set:consent-required-profiles", StoredKindSet, nil},
+	{"withdrawals:", StoredKindList, func() platform.RedisValue { return new(WithdrawalTombstone) }},
+	{"arm-blocks:", StoredKindMap, nil},
+	{"study-messages:", StoredKindMap, func() platform.RedisValue { return new(StudyMessage) }},
+	{"profile-messages:", StoredKindMap, func() platform.RedisValue { return new(ProfileMessage) }},
+	{"undelivered-messages:", StoredKindSet, nil},
+	{"study-reports:", StoredKindMap, func() platform.RedisValue { return new(StudyReport) }},
+	{"speech-settings:", StoredKindObject, func() platform.RedisValue { return new(SpeechSettings) }},
+	{"favorites-settings:", StoredKindObject, func() platform.RedisValue { return new(FavoritesSettings) }},
+	{"favorites-history:", StoredKindList, func() platform.RedisValue { return new(FavoritesVersion) }},
+	{"launch-data:", StoredKindObject, func() platform.RedisValue { return new(LifecycleData) }},
+	{"session-events:", StoredKindList, func() platform.RedisValue { return new(SessionEvent) }},
+	{"profile-devices:", StoredKindMap, func() platform.RedisValue { return new(Device) }},
+	{"map:client-version-policies", StoredKindMap, func() platform.RedisValue { return new(ClientVersionPolicy) }},
+	{"map:feature-flags", StoredKindMap, func() platform.RedisValue { return new(FeatureFlag) }},
+	{"client-config-etags:", StoredKindMap, nil},
+	{"client-config-cache:", StoredKindMap, nil},
+	{"string:client-config-version", StoredKindString, nil},
+	{"speech-monitor:", StoredKindObject, func() platform.RedisValue { return new(SpeechMonitor) }},
+	{"zset:speech-monitors", StoredKindSortedSet, nil},
+	{"notified-speech-clients:", StoredKindSet, nil},
+	{"notified-usage-clients:", StoredKindSet, nil},
+	{"sent-usage-alerts:", StoredKindSet, nil},
+	{"pending-usage-alerts:", StoredKindList, func() platform.RedisValue { return new(UsageAlert) }},
+	{"usage-digest-sent:", StoredKindString, nil},
+	{"inactive-participants:", StoredKindSet, nil},
+	{"inactivity-check-done:", StoredKindString, nil},
+	{"pending-messages:", StoredKindList, nil},
+	{"problem-report:", StoredKindObject, func() platform.RedisValue { return new(ProblemReport) }},
+	{"set:problem-reports", StoredKindSet, nil},
+}
+
+var (
+	UnregisteredKeyError = errors.New("key doesn't match a registered prefix")
+	NotEditableError     = errors.New("values of this kind can't be edited")
+)
+
+// RegisteredPrefixes returns all the registered storage prefixes.
+func RegisteredPrefixes() []RegisteredPrefix {
+	return prefixRegistry
+}
+
+// LookupPrefix returns the registered prefix of the key, if there is one.
+// Where more than one prefix matches, the longest is returned.
+func LookupPrefix(key string) *RegisteredPrefix {
+	var found *RegisteredPrefix
+	for i, r := range prefixRegistry {
+		if strings.HasPrefix(key, r.Prefix) && (found == nil || len(r.Prefix) > len(found.Prefix)) {
+			found = &prefixRegistry[i]
+		}
+	}
+	return found
+}
+
+// ListStoredKeys returns all the keys that start with the given prefix.
+func ListStoredKeys(prefix string) ([]string, error) {
+	var keys []string
+	collect := func(id string) error {
+		keys = append(keys, prefix+id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, platform.PrefixedKey{Prefix: prefix}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// decodeStored decodes an encoded value for display.
+func (r *RegisteredPrefix) decodeStored(val string) (any, error) {
+	if r.New == nil {
+		return val, nil
+	}
+	v := r.New()
+	if err := v.FromRedis([]byte(val)); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// InspectStoredKey returns the decoded value stored at the key, suitable for
+// marshaling as JSON. Maps are returned as maps from fields to values,
+// lists and sets as slices of values, and sorted sets as maps from members to scores.
+func InspectStoredKey(key string) (any, error) {
+	r := LookupPrefix(key)
+	if r == nil {
+		return nil, UnregisteredKeyError
+	}
+	k := platform.PrefixedKey{Id: key}
+	switch r.Kind {
+	case StoredKindObject:
+		val, err := platform.FetchString(sCtx(), k)
+		if err != nil || val == "" {
+			return nil, err
+		}
+		return r.decodeStored(val)
+	case StoredKindString:
+		val, err := platform.FetchString(sCtx(), k)
+		if err != nil || val == "" {
+			return nil, err
+		}
+		return val, nil
+	case StoredKindMap:
+		m, err := platform.MapGetAll(sCtx(), k)
+		if err != nil {
+			return nil, err
+		}
+		result := make(map[string]any, len(m))
+		for field, val := range m {
+			if result[field], err = r.decodeStored(val); err != nil {
+				return nil, fmt.Errorf("field %q: %w", field, err)
+			}
+		}
+		return result, nil
+	case StoredKindList:
+		vals, err := platform.FetchRange(sCtx(), k, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]any, 0, len(vals))
+		for i, val := range vals {
+			v, err := r.decodeStored(val)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			result = append(result, v)
+		}
+		return result, nil
+	case StoredKindSet:
+		return platform.FetchMembers(sCtx(), k)
+	case StoredKindSortedSet:
+		members, err := platform.FetchRangeScoreInterval(sCtx(), k, math.Inf(-1), math.Inf(1))
+		if err != nil {
+			return nil, err
+		}
+		result := make(map[string]float64, len(members))
+		for _, m := range members {
+			if result[m], err = platform.GetMemberScore(sCtx(), k, m); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unknown kind: %q", r.Kind)
+}
+
+// InspectStoredElement returns the decoded value of one map field or list element.
+// For maps, the element is the field name; for lists, it's the index.
+func InspectStoredElement(key, element string) (any, error) {
+	r := LookupPrefix(key)
+	if r == nil {
+		return nil, UnregisteredKeyError
+	}
+	k := platform.PrefixedKey{Id: key}
+	var val string
+	var err error
+	switch r.Kind {
+	case StoredKindMap:
+		val, err = platform.MapGet(sCtx(), k, element)
+	case StoredKindList:
+		index, parseErr := strconv.ParseInt(element, 10, 64)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid list index: %q", element)
+		}
+		var vals []string
+		if vals, err = platform.FetchRange(sCtx(), k, index, index); err == nil && len(vals) == 1 {
+			val = vals[0]
+		}
+	default:
+		return nil, fmt.Errorf("%s values have no elements", r.Kind)
+	}
+	if err != nil || val == "" {
+		return nil, err
+	}
+	return r.decodeStored(val)
+}
+
+// EditStoredValue replaces an existing encoded value with one decoded from JSON.
+// The element is ignored for objects, and is the field name for maps and the index for lists.
+// The JSON must only use fields of the stored type, and is re-encoded with ToRedis.
+// It returns the decoded replacement value. If dryRun is true, nothing is stored.
+func EditStoredValue(key, element string, js []byte, dryRun bool) (any, error) {
+	r := LookupPrefix(key)
+	if r == nil {
+		return nil, UnregisteredKeyError
+	}
+	if r.New == nil {
+		return nil, NotEditableError
+	}
+	var existing any
+	var err error
+	if r.Kind == StoredKindObject {
+		existing, err = InspectStoredKey(key)
+	} else {
+		existing, err = InspectStoredElement(key, element)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, platform.NotFoundError
+	}
+	v := r.New()
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return nil, fmt.Errorf("invalid JSON for stored type: %w", err)
+	}
+	if o, ok := v.(platform.RedisKey); ok && o.StoragePrefix()+o.StorageId() != key {
+		return nil, fmt.Errorf("edited value belongs at key %q", o.StoragePrefix()+o.StorageId())
+	}
+	b, err := v.ToRedis()
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return v, nil
+	}
+	k := platform.PrefixedKey{Id: key}
+	switch r.Kind {
+	case StoredKindObject:
+		err = platform.StoreString(sCtx(), k, string(b))
+	case StoredKindMap:
+		err = platform.MapSet(sCtx(), k, element, string(b))
+	case StoredKindList:
+		index, _ := strconv.ParseInt(element, 10, 64)
+		err = platform.SetElement(sCtx(), k, index, string(b))
+	default:
+		err = NotEditableError
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
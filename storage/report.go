@@ -8,17 +8,19 @@ package storage
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"regexp"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/tealeg/xlsx/v3"
 	"github.com/whisper-project/in-my-voice.server.golang/platform"
 	"go.uber.org/zap"
 )
@@ -26,8 +28,11 @@ import (
 type ReportType = string
 
 const (
-	ReportTypeLines   = "lines"
-	ReportTypePhrases = "phrases"
+	ReportTypeLines          = "lines"
+	ReportTypePhrases        = "phrases"
+	ReportTypeCombined       = "combined" // lines, phrases, and participant summaries in one workbook
+	ReportTypeEngagement     = "engagement"
+	ReportTypeQuestionnaires = "questionnaires"
 )
 
 type StudyReport struct {
@@ -42,6 +47,8 @@ type StudyReport struct {
 	Generated int64
 	Stored    bool
 	Schedule  string
+	Raw       bool // participant content is shown without privacy protection (for data stewards only)
+	Format    ReportFormat
 }
 
 func (s *StudyReport) ToRedis() ([]byte, error) {
@@ -168,20 +175,36 @@ func (s *StudyReport) Delete() error {
 	return err
 }
 
-func NewStudyReport(studyId, name, reportType string, start, end int64, upns []string) *StudyReport {
+func NewStudyReport(studyId, name, reportType, format string, start, end int64, upns []string) *StudyReport {
 	s := &StudyReport{
 		ReportId: uuid.NewString(),
 		StudyId:  studyId,
 		Name:     name,
 		Type:     reportType,
+		Format:   format,
 		Start:    start,
 		End:      end,
 		Upns:     upns,
 	}
-	s.Filename = s.SanitizeName() + ".xlsx"
+	s.Filename = s.SanitizeName() + "." + format
 	return s
 }
 
+// UnsupportedReportFormatError means a report type can't be produced in the requested format.
+var UnsupportedReportFormatError = errors.New("report type doesn't support format")
+
+// CheckFormat returns an error if the report can't be produced in its format.
+// Combined reports have several tables, so they can't be CSV files.
+func (s *StudyReport) CheckFormat() error {
+	if !slices.Contains(ReportFormats, s.Format) {
+		return fmt.Errorf("%w: unknown format %q", UnsupportedReportFormatError, s.Format)
+	}
+	if s.Type == ReportTypeCombined && s.Format == ReportFormatCsv {
+		return fmt.Errorf("%w: %s reports can't be %s", UnsupportedReportFormatError, s.Type, s.Format)
+	}
+	return nil
+}
+
 func GetStudyReport(studyId, reportId string) (*StudyReport, error) {
 	val, err := platform.MapGet(sCtx(), ReportIndex(studyId), reportId)
 	if err != nil {
@@ -242,118 +265,413 @@ func ComputeReportDates(startString, endString, dateFormat string) (start, end i
 }
 
 func (s *StudyReport) generate(dest string) (err error) {
+	if err = s.CheckFormat(); err != nil {
+		return
+	}
+	var tables []*reportTable
 	switch s.Type {
 	case ReportTypeLines:
-		var stats [][]TypedLineStat
-		stats, err = FetchAllTypedLineStats(s.StudyId, s.Start, s.End, s.Upns)
-		if err == nil {
-			err = generateLinesReport(dest, stats)
+		var t *reportTable
+		if t, err = s.linesTable(); err == nil {
+			tables = append(tables, t)
 		}
 	case ReportTypePhrases:
-		var stats []PhraseStat
-		stats, err = FetchAllPhraseStats(s.StudyId)
-		if err == nil {
-			err = generatePhraseReport(dest, stats)
+		var t *reportTable
+		if t, err = s.phrasesTable(); err == nil {
+			tables = append(tables, t)
 		}
+	case ReportTypeEngagement:
+		var t *reportTable
+		if t, err = s.engagementTable(); err == nil {
+			tables = append(tables, t)
+		}
+	case ReportTypeQuestionnaires:
+		var t *reportTable
+		if t, err = s.questionnairesTable(); err == nil {
+			tables = append(tables, t)
+		}
+	case ReportTypeCombined:
+		var lines, phrases, participants *reportTable
+		if lines, err = s.linesTable(); err != nil {
+			break
+		}
+		if phrases, err = s.phrasesTable(); err != nil {
+			break
+		}
+		if participants, err = s.participantsTable(); err != nil {
+			break
+		}
+		tables = append(tables, lines, phrases, participants)
 	default:
 		err = fmt.Errorf("unknown report type: %s", s.Type)
 	}
+	if err == nil {
+		var arms map[string]string
+		if arms, err = s.participantArms(); err == nil {
+			for _, t := range tables {
+				t.addArmColumn(arms)
+			}
+		}
+	}
+	if err == nil {
+		err = writeReportTables(dest, s.Format, tables...)
+	}
 	if err == nil {
 		s.Generated = time.Now().UnixMilli()
 	}
 	return
 }
 
-func generateLinesReport(name string, stats [][]TypedLineStat) error {
-	// the report is not sorted
-	xlsx.SetDefaultFont(12, "Arial")
-	xf := xlsx.NewFile()
-	xs, err := xf.AddSheet("Lines Report")
+// reportEnd returns the end of the report's time range, which is now if it has none.
+func (s *StudyReport) reportEnd() int64 {
+	if s.End == 0 {
+		return time.Now().UnixMilli()
+	}
+	return s.End
+}
+
+func (s *StudyReport) linesTable() (*reportTable, error) {
+	stats, err := FetchAllTypedLineStats(s.StudyId, s.Start, s.reportEnd(), s.Upns)
 	if err != nil {
-		return fmt.Errorf("failed to create the report worksheet: %w", err)
-	}
-	headings := []string{"UPN", "When", "Key Count", "Char Count", "Time (ms)", "Platform"}
-	headingsRow := xs.AddRow()
-	headingStyle := xlsx.NewStyle()
-	headingStyle.Alignment.Horizontal = "center"
-	headingStyle.Font.Bold = true
-	for _, h := range headings {
-		cell := headingsRow.AddCell()
-		cell.SetString(h)
-		cell.SetStyle(headingStyle)
-	}
-	textStyle := xlsx.NewStyle()
-	textStyle.Font.Bold = false
-	textStyle.Alignment.Horizontal = "center"
-	cols1to2 := xlsx.NewColForRange(1, 2)
-	cols1to2.SetWidth(22)
-	cols1to2.SetStyle(textStyle)
-	xs.SetColParameters(cols1to2)
-	cols3to5 := xlsx.NewColForRange(3, 5)
-	cols3to5.SetWidth(11)
-	xs.SetColParameters(cols3to5)
-	col6 := xlsx.NewColForRange(6, 6)
-	col6.SetWidth(15)
-	col6.SetStyle(textStyle)
-	xs.SetColParameters(col6)
-	xlDateFormat := `yyyy/mm/dd hh:mm:ss.000`
+		return nil, err
+	}
+	// the table is not sorted
+	t := &reportTable{
+		Name: "Lines Report",
+		Columns: []reportColumn{
+			{"UPN", "upn", 22, true},
+			{"When", "when", 22, true},
+			{"Key Count", "keyCount", 11, false},
+			{"Char Count", "charCount", 11, false},
+			{"Time (ms)", "timeMs", 11, false},
+			{"Platform", "platform", 15, true},
+		},
+	}
 	for _, user := range stats {
 		for _, stat := range user {
-			row := xs.AddRow()
-			row.AddCell().SetString(stat.Upn)
-			date := time.UnixMilli(stat.Completed).In(AdminTZ)
-			xlDate := xlsx.TimeToExcelTime(date, xf.Date1904)
-			row.AddCell().SetDateTimeWithFormat(xlDate, xlDateFormat)
-			row.AddCell().SetInt64(stat.Changes)
-			row.AddCell().SetInt64(stat.Length)
-			row.AddCell().SetInt64(stat.Duration)
-			row.AddCell().SetString(PlatformNames[stat.From])
+			when := time.UnixMilli(stat.Completed).In(AdminTZ)
+			t.addRow(stat.Upn, when, stat.Changes, stat.Length, stat.Duration, PlatformNames[stat.From])
+		}
+	}
+	return t, nil
+}
+
+// phrasesTable returns the phrase usage in the report, protecting phrase content
+// according to the study's privacy settings unless the report is raw.
+func (s *StudyReport) phrasesTable() (*reportTable, error) {
+	study, err := GetStudy(s.StudyId)
+	if err != nil {
+		return nil, err
+	}
+	if study == nil {
+		return nil, fmt.Errorf("no such study: %s", s.StudyId)
+	}
+	counts, err := CountPhraseParticipants(s.StudyId, s.Start, s.reportEnd())
+	if err != nil {
+		return nil, err
+	}
+	display := func(stat *PhraseStat) string {
+		return study.PhraseDisplay(stat, counts[stat.Hash], s.Raw)
+	}
+	columns := []reportColumn{
+		{"Total Count", "totalCount", 15, false},
+		{"Favorite Count", "favoriteCount", 15, false},
+		{"Repeat Count", "repeatCount", 15, false},
+		{"Library Count", "libraryCount", 15, false},
+		{"Participants", "participants", 15, false},
+		{"Content", "content", 80, false},
+	}
+	if s.Start == 0 && s.End == 0 && len(s.Upns) == 0 && len(study.Arms) == 0 {
+		// unrestricted reports use the study totals, which predate participant stats,
+		// unless the study has arms, because the totals can't be split by arm
+		stats, err := FetchAllPhraseStats(s.StudyId)
+		if err != nil {
+			return nil, err
 		}
+		// the table is sorted descending by total usage
+		slices.SortFunc(stats, func(a, b PhraseStat) int {
+			return cmp.Compare(b.FavoriteCount+b.RepeatCount, a.FavoriteCount+a.RepeatCount)
+		})
+		t := &reportTable{Name: "Phrases Report", Columns: columns}
+		for _, phrase := range stats {
+			t.addRow(phrase.FavoriteCount+phrase.RepeatCount, phrase.FavoriteCount, phrase.RepeatCount,
+				phrase.LibraryCount, counts[phrase.Hash], display(&phrase))
+		}
+		return t, nil
 	}
-	if err := xf.Save(name); err != nil {
-		return fmt.Errorf("failed to save the report to %q: %w", name, err)
+	stats, err := FetchAllParticipantPhraseStats(s.StudyId, s.Start, s.reportEnd(), s.Upns)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	// the table is grouped by participant, and then descending by total usage
+	slices.SortStableFunc(stats, func(a, b ParticipantPhraseStat) int {
+		if a.Upn != b.Upn {
+			return strings.Compare(a.Upn, b.Upn)
+		}
+		return cmp.Compare(b.Stat.FavoriteCount+b.Stat.RepeatCount, a.Stat.FavoriteCount+a.Stat.RepeatCount)
+	})
+	t := &reportTable{
+		Name:    "Phrases Report",
+		Columns: append([]reportColumn{{"UPN", "upn", 22, false}}, columns...),
+	}
+	for _, ps := range stats {
+		t.addRow(ps.Upn, ps.Stat.FavoriteCount+ps.Stat.RepeatCount, ps.Stat.FavoriteCount, ps.Stat.RepeatCount,
+			ps.Stat.LibraryCount, counts[ps.Stat.Hash], display(&ps.Stat))
+	}
+	return t, nil
 }
 
-func generatePhraseReport(name string, stats []PhraseStat) error {
-	// the report is sorted by default: descending by total usage
-	slices.SortFunc(stats, func(a, b PhraseStat) int {
-		if diff := (a.FavoriteCount + a.RepeatCount) - (b.FavoriteCount + b.RepeatCount); diff > 0 {
-			return -1
-		} else if diff < 0 {
-			return 1
-		} else {
-			return 0
+// participantArms returns the arm of each of the study's participants, keyed by
+// lowercase UPN, or nil if the study doesn't have arms.
+func (s *StudyReport) participantArms() (map[string]string, error) {
+	study, err := GetStudy(s.StudyId)
+	if err != nil {
+		return nil, err
+	}
+	if study == nil || len(study.Arms) == 0 {
+		return nil, nil
+	}
+	participants, err := GetAllStudyParticipants(s.StudyId)
+	if err != nil {
+		return nil, err
+	}
+	arms := make(map[string]string, len(participants))
+	for _, p := range participants {
+		arms[strings.ToLower(p.Upn)] = p.Arm
+	}
+	return arms, nil
+}
+
+// addArmColumn adds each participant's arm after the UPN column of a table,
+// so analyses can be split by arm. Tables without a UPN column are unchanged.
+func (t *reportTable) addArmColumn(arms map[string]string) {
+	if arms == nil || len(t.Columns) == 0 || t.Columns[0].Key != "upn" {
+		return
+	}
+	t.Columns = slices.Insert(t.Columns, 1, reportColumn{"Arm", "arm", 15, true})
+	for i, row := range t.Rows {
+		upn, _ := row[0].(string)
+		t.Rows[i] = slices.Insert(row, 1, any(arms[strings.ToLower(upn)]))
+	}
+}
+
+// participantsTable returns a summary of each participant's activity in the report.
+func (s *StudyReport) participantsTable() (*reportTable, error) {
+	participants, err := GetAllStudyParticipants(s.StudyId)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(participants, func(a, b *StudyParticipant) int {
+		return strings.Compare(a.Upn, b.Upn)
+	})
+	end := s.reportEnd()
+	t := &reportTable{
+		Name: "Participants Report",
+		Columns: []reportColumn{
+			{"UPN", "upn", 22, true},
+			{"Memo", "memo", 22, false},
+			{"Started", "started", 22, true},
+			{"Finished", "finished", 22, true},
+			{"Typed Lines", "typedLines", 13, false},
+			{"Key Count", "keyCount", 13, false},
+			{"Char Count", "charCount", 13, false},
+			{"Favorite Uses", "favoriteUses", 13, false},
+			{"Repeat Uses", "repeatUses", 13, false},
+		},
+	}
+	formatTime := func(ms int64) any {
+		if ms == 0 {
+			return ""
+		}
+		return time.UnixMilli(ms).In(AdminTZ)
+	}
+	for _, p := range participants {
+		if p.Started == 0 || (len(s.Upns) > 0 && !slices.Contains(s.Upns, p.Upn)) {
+			continue
+		}
+		lines, err := FetchTypedLineStats(s.StudyId, p.Upn, s.Start, end)
+		if err != nil {
+			return nil, err
 		}
+		// repeated lines are counted in the phrase stats, not the typed line stats
+		typed := int64(len(lines))
+		var keys, chars int64
+		for _, l := range lines {
+			keys += l.Changes
+			chars += l.Length
+		}
+		phrases, err := FetchAllParticipantPhraseStats(s.StudyId, s.Start, end, []string{p.Upn})
+		if err != nil {
+			return nil, err
+		}
+		var favorites, repeats int64
+		for _, ps := range phrases {
+			favorites += ps.Stat.FavoriteCount
+			repeats += ps.Stat.RepeatCount
+		}
+		t.addRow(p.Upn, p.Memo, formatTime(p.Started), formatTime(p.Finished),
+			typed, keys, chars, favorites, repeats)
+	}
+	return t, nil
+}
+
+// engagementTable returns each participant's app sessions per day during their
+// participation, along with the number of days from their last activity to the end
+// of the report.
+func (s *StudyReport) engagementTable() (*reportTable, error) {
+	participants, err := GetAllStudyParticipants(s.StudyId)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(participants, func(a, b *StudyParticipant) int {
+		return strings.Compare(a.Upn, b.Upn)
 	})
-	xlsx.SetDefaultFont(12, "Arial")
-	xf := xlsx.NewFile()
-	xs, err := xf.AddSheet("Phrases Report")
+	end := s.reportEnd()
+	t := &reportTable{
+		Name: "Engagement Report",
+		Columns: []reportColumn{
+			{"UPN", "upn", 22, true},
+			{"Day", "day", 13, true},
+			{"Sessions", "sessions", 11, false},
+			{"Devices", "devices", 11, false},
+			{"Total Session Time (s)", "totalSessionSeconds", 22, false},
+			{"Average Session Time (s)", "averageSessionSeconds", 22, false},
+			{"Days Since Last Active", "daysSinceLastActive", 22, false},
+		},
+	}
+	type dayTotals struct {
+		sessions, measured, length int64
+		devices                    map[string]bool
+	}
+	for _, p := range participants {
+		if p.Started == 0 || p.ProfileId == "" || (len(s.Upns) > 0 && !slices.Contains(s.Upns, p.Upn)) {
+			continue
+		}
+		from, to := max(s.Start, p.Started), end
+		if p.Finished > 0 {
+			to = min(to, p.Finished)
+		}
+		// fetch earlier events as well, so inactive participants get reported
+		events, err := FetchSessionEvents(p.ProfileId, 0, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			t.addRow(p.Upn, "never active", int64(0), int64(0), int64(0), int64(0), daysBetween(p.Started, end))
+			continue
+		}
+		sinceLastActive := daysBetween(events[len(events)-1].When, end)
+		var days []string
+		totals := make(map[string]*dayTotals)
+		for _, session := range ComputeSessions(events) {
+			if session.Start < from {
+				continue
+			}
+			day := adminDay(session.Start)
+			d := totals[day]
+			if d == nil {
+				d = &dayTotals{devices: make(map[string]bool)}
+				totals[day] = d
+				days = append(days, day)
+			}
+			d.sessions++
+			d.devices[session.ClientId] = true
+			if session.End > 0 {
+				d.measured++
+				d.length += session.End - session.Start
+			}
+		}
+		if len(days) == 0 {
+			t.addRow(p.Upn, "no sessions", int64(0), int64(0), int64(0), int64(0), sinceLastActive)
+		}
+		for _, day := range days {
+			d := totals[day]
+			var average int64
+			if d.measured > 0 {
+				average = d.length / d.measured / 1000
+			}
+			t.addRow(p.Upn, day, d.sessions, int64(len(d.devices)), d.length/1000, average, sinceLastActive)
+		}
+	}
+	return t, nil
+}
+
+// questionnairesTable returns one row for each answer in the participants' questionnaire
+// responses. Text answers are scrubbed if the study scrubs phrases, unless the report is raw.
+func (s *StudyReport) questionnairesTable() (*reportTable, error) {
+	study, err := GetStudy(s.StudyId)
 	if err != nil {
-		return fmt.Errorf("failed to create the report worksheet: %v", err)
-	}
-	headings := []string{"Total Count", "Favorite Count", "Repeat Count", "Content"}
-	headingsRow := xs.AddRow()
-	headingStyle := xlsx.NewStyle()
-	headingStyle.Alignment.Horizontal = "center"
-	headingStyle.Font.Bold = true
-	for _, h := range headings {
-		cell := headingsRow.AddCell()
-		cell.SetString(h)
-		cell.SetStyle(headingStyle)
-	}
-	xs.SetColWidth(1, 3, 15)
-	xs.SetColWidth(4, 4, 80)
-	for _, phrase := range stats {
-		row := xs.AddRow()
-		row.AddCell().SetInt64(phrase.FavoriteCount + phrase.RepeatCount)
-		row.AddCell().SetInt64(phrase.FavoriteCount)
-		row.AddCell().SetInt64(phrase.RepeatCount)
-		row.AddCell().SetString(phrase.Content)
-	}
-	if err = xf.Save(name); err != nil {
-		return fmt.Errorf("failed to save the report to %q: %w", name, err)
+		return nil, err
 	}
-	return nil
+	if study == nil {
+		return nil, fmt.Errorf("no such study: %s", s.StudyId)
+	}
+	questionnaires, err := GetAllQuestionnaires(s.StudyId)
+	if err != nil {
+		return nil, err
+	}
+	upns := s.Upns
+	if len(upns) == 0 {
+		participants, err := GetAllStudyParticipants(s.StudyId)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range participants {
+			upns = append(upns, p.Upn)
+		}
+	}
+	slices.Sort(upns)
+	t := &reportTable{
+		Name: "Questionnaires Report",
+		Columns: []reportColumn{
+			{"UPN", "upn", 22, true},
+			{"Questionnaire", "questionnaire", 30, false},
+			{"Period", "period", 13, true},
+			{"Submitted", "submitted", 25, true},
+			{"Item", "item", 8, true},
+			{"Prompt", "prompt", 50, false},
+			{"Answer", "answer", 50, false},
+		},
+	}
+	for _, upn := range upns {
+		responses, err := FetchQuestionnaireResponses(s.StudyId, upn, s.Start, s.reportEnd())
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range responses {
+			// responses to deleted questionnaires are still reported, without names or prompts
+			var q *Questionnaire
+			if i := slices.IndexFunc(questionnaires, func(q *Questionnaire) bool { return q.Id == r.QuestionnaireId }); i >= 0 {
+				q = questionnaires[i]
+			}
+			name, ids := r.QuestionnaireId, make([]string, 0, len(r.Answers))
+			if q != nil {
+				name = q.Name
+				// current items first, in questionnaire order, then any answers to retired items
+				for _, item := range slices.Concat(q.Items, q.RetiredItems) {
+					if _, ok := r.Answers[item.Id]; ok {
+						ids = append(ids, item.Id)
+					}
+				}
+			} else {
+				for id := range r.Answers {
+					ids = append(ids, id)
+				}
+				slices.Sort(ids)
+			}
+			for _, id := range ids {
+				answer, prompt := r.Answers[id], ""
+				if item := q.GetItem(id); item != nil {
+					prompt = item.Prompt
+					if item.Type == QuestionnaireItemText && study.PhraseScrubPII && !s.Raw {
+						answer = platform.ScrubPII(answer)
+					}
+				} else if study.PhraseScrubPII && !s.Raw {
+					answer = platform.ScrubPII(answer)
+				}
+				t.addRow(upn, name, r.Period, time.UnixMilli(r.Submitted), id, prompt, answer)
+			}
+		}
+	}
+	return t, nil
 }
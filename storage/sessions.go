@@ -0,0 +1,153 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+type SessionEventKind = string
+
+const (
+	SessionEventLaunch     SessionEventKind = "launch"
+	SessionEventForeground SessionEventKind = "foreground"
+	SessionEventBackground SessionEventKind = "background"
+	SessionEventShutdown   SessionEventKind = "shutdown"
+)
+
+// SessionHistoryLength is the number of session events kept for each profile.
+const SessionHistoryLength = 5000
+
+// A SessionEvent records a lifecycle event of one of a profile's clients.
+type SessionEvent struct {
+	Kind       SessionEventKind
+	ClientId   string
+	ClientType string
+	When       int64 // Unix time in milliseconds
+}
+
+func (e *SessionEvent) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(e); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (e *SessionEvent) FromRedis(b []byte) error {
+	*e = SessionEvent{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(e)
+}
+
+// SessionHistory is the list of a profile's session events, oldest first.
+type SessionHistory string
+
+func (h SessionHistory) StoragePrefix() string {
+	return "session-events:"
+}
+func (h SessionHistory) StorageId() string {
+	return string(h)
+}
+
+// PushEvents adds events to the end of the history, dropping the oldest
+// events if the history is longer than SessionHistoryLength.
+func (h SessionHistory) PushEvents(events ...SessionEvent) error {
+	vals := make([]string, 0, len(events))
+	for _, e := range events {
+		b, err := e.ToRedis()
+		if err != nil {
+			sLog().Error("serialization failure on session event",
+				zap.String("profileId", string(h)), zap.Error(err))
+			return err
+		}
+		vals = append(vals, string(b))
+	}
+	if err := platform.PushRange(sCtx(), h, false, vals...); err != nil {
+		sLog().Error("db failure on session event push",
+			zap.String("profileId", string(h)), zap.Error(err))
+		return err
+	}
+	if err := platform.TrimRange(sCtx(), h, -SessionHistoryLength, -1); err != nil {
+		sLog().Error("db failure on session history trim",
+			zap.String("profileId", string(h)), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func recordSessionEvent(kind SessionEventKind, clientType, clientId, profileId string, when int64) {
+	e := SessionEvent{Kind: kind, ClientId: clientId, ClientType: clientType, When: when}
+	_ = SessionHistory(profileId).PushEvents(e)
+}
+
+// FetchSessionEvents returns the profile's session events in the given time range, oldest first.
+func FetchSessionEvents(profileId string, start, end int64) ([]SessionEvent, error) {
+	vals, err := platform.FetchRange(sCtx(), SessionHistory(profileId), 0, -1)
+	if err != nil {
+		sLog().Error("db failure on session history fetch",
+			zap.String("profileId", profileId), zap.Error(err))
+		return nil, err
+	}
+	events := make([]SessionEvent, 0, len(vals))
+	for _, val := range vals {
+		var e SessionEvent
+		if err := e.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on session event",
+				zap.String("profileId", profileId), zap.Error(err))
+			return nil, err
+		}
+		if e.When < start || e.When > end {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// A Session is a period during which one of a profile's clients was in the foreground.
+// If the end of the session wasn't observed, End is 0.
+type Session struct {
+	ClientId string
+	Start    int64
+	End      int64
+}
+
+// ComputeSessions pairs each launch or foreground event with the next background
+// or shutdown event from the same client. A foreground event while a session is
+// open continues that session. A session whose end wasn't observed (because the
+// client crashed or lost its connection) is ended by that client's next launch,
+// but its length is unknown.
+func ComputeSessions(events []SessionEvent) []Session {
+	var sessions []Session
+	open := make(map[string]int) // client ID to index of its open session
+	for _, e := range events {
+		i, isOpen := open[e.ClientId]
+		switch e.Kind {
+		case SessionEventLaunch, SessionEventForeground:
+			if isOpen && e.Kind == SessionEventForeground {
+				continue
+			}
+			open[e.ClientId] = len(sessions)
+			sessions = append(sessions, Session{ClientId: e.ClientId, Start: e.When})
+		case SessionEventBackground, SessionEventShutdown:
+			if isOpen {
+				sessions[i].End = e.When
+				delete(open, e.ClientId)
+			}
+		}
+	}
+	return sessions
+}
+
+// daysBetween returns the number of whole days from one time to a later one.
+func daysBetween(from, to int64) int64 {
+	return int64(time.UnixMilli(to).Sub(time.UnixMilli(from)) / (24 * time.Hour))
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestComputeSessions(t *testing.T) {
+	ev := func(kind SessionEventKind, clientId string, when int64) SessionEvent {
+		return SessionEvent{Kind: kind, ClientId: clientId, When: when}
+	}
+	cases := []struct {
+		name   string
+		events []SessionEvent
+		want   []Session
+	}{
+		{"none", nil, nil},
+		{"closed", []SessionEvent{
+			ev(SessionEventLaunch, "a", 1), ev(SessionEventBackground, "a", 5),
+			ev(SessionEventForeground, "a", 7), ev(SessionEventShutdown, "a", 9),
+		}, []Session{{"a", 1, 5}, {"a", 7, 9}}},
+		{"open", []SessionEvent{
+			ev(SessionEventLaunch, "a", 1), ev(SessionEventBackground, "a", 5),
+			ev(SessionEventForeground, "a", 7),
+		}, []Session{{"a", 1, 5}, {"a", 7, 0}}},
+		{"foreground continues open session", []SessionEvent{
+			ev(SessionEventLaunch, "a", 1), ev(SessionEventForeground, "a", 3), ev(SessionEventBackground, "a", 5),
+		}, []Session{{"a", 1, 5}}},
+		{"relaunch after crash", []SessionEvent{
+			ev(SessionEventLaunch, "a", 1), ev(SessionEventLaunch, "a", 4), ev(SessionEventBackground, "a", 6),
+		}, []Session{{"a", 1, 0}, {"a", 4, 6}}},
+		{"unmatched end", []SessionEvent{
+			ev(SessionEventBackground, "a", 1), ev(SessionEventLaunch, "a", 2), ev(SessionEventShutdown, "a", 3),
+		}, []Session{{"a", 2, 3}}},
+		{"interleaved clients", []SessionEvent{
+			ev(SessionEventLaunch, "a", 1), ev(SessionEventLaunch, "b", 2), ev(SessionEventBackground, "a", 3),
+			ev(SessionEventForeground, "a", 4), ev(SessionEventShutdown, "b", 5), ev(SessionEventBackground, "a", 6),
+		}, []Session{{"a", 1, 3}, {"b", 2, 5}, {"a", 4, 6}}},
+	}
+	for _, c := range cases {
+		if got := ComputeSessions(c.events); !slices.Equal(got, c.want) {
+			t.Errorf("ComputeSessions(%s) should be %v but is %v", c.name, c.want, got)
+		}
+	}
+}
@@ -13,10 +13,12 @@ import (
 	"fmt"
 	"hash/fnv"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/whisper-project/in-my-voice.server.golang/platform"
 	"github.com/whisper-project/in-my-voice.server.golang/services"
 	"go.uber.org/zap"
@@ -26,10 +28,43 @@ import (
 //
 // Each Study is a value kept in the studyIndex map from studyIds to studies.
 type Study struct {
-	Id         string
-	Name       string
-	AdminEmail string
-	Active     bool
+	Id              string
+	Name            string
+	AdminEmail      string
+	Active          bool
+	AlertThresholds []int64 // percentages of character quota that trigger admin alerts
+	AlertDigest     bool    // send alerts as a daily digest rather than immediately
+	// FailureAlertCount is the number of repeats of a participant's speech failure
+	// that triggers an email to the study admins (0 means never)
+	FailureAlertCount int64
+	// PhraseMinParticipants is the number of distinct participants who must have used
+	// a phrase before its content is shown in reports (0 means always show it)
+	PhraseMinParticipants int64
+	PhraseRedaction       PhraseRedaction // how phrases below the minimum are shown
+	PhraseHashKey         string          // the secret key for the hashes of phrases below the minimum
+	PhraseScrubPII        bool            // scrub emails, phone numbers, and numbers from shown phrases
+	// InactivityDays is the number of days without use after which a participant
+	// is reported as inactive (0 means never)
+	InactivityDays    int64
+	InactivityMessage string // in-app message sent to newly inactive participants (if non-empty)
+	// Arms are the groups participants are randomized into (none means no randomization)
+	Arms              []StudyArm
+	ArmRandomization  ArmRandomization
+	ArmBlockSize      int64 // participants per block (0 means the sum of the arm ratios)
+	ArmAssignOnCreate bool  // assign arms when participants are created, rather than when they enroll
+}
+
+func NewStudy(name, adminEmail string) *Study {
+	return &Study{
+		Id:                    uuid.NewString(),
+		Name:                  name,
+		AdminEmail:            adminEmail,
+		Active:                true,
+		PhraseMinParticipants: DefaultPhraseMinParticipants,
+		PhraseRedaction:       PhraseRedactionRedact,
+		PhraseHashKey:         uuid.NewString(),
+		PhraseScrubPII:        true,
+	}
 }
 
 func (s *Study) ToRedis() ([]byte, error) {
@@ -59,7 +94,8 @@ func (s *Study) Save() error {
 		sLog().Error("map set failure on study save", zap.String("studyId", s.Id), zap.Error(err))
 		return err
 	}
-	return nil
+	// the study's arms may set flag values
+	return clientConfigsDidChange()
 }
 
 func GetStudy(id string) (*Study, error) {
@@ -122,13 +158,68 @@ func DeleteStudy(studyId string) error {
 			zap.String("studyId", studyId), zap.Error(err))
 		return err
 	}
-	// next, delete all the line stats for the participants
+	// next, delete all the phrase libraries
+	if err = platform.DeleteStorage(sCtx(), PhraseLibraryIndex(studyId)); err != nil {
+		sLog().Error("db failure on phrase libraries delete",
+			zap.String("studyId", studyId), zap.Error(err))
+		return err
+	}
+	if err = platform.DeleteStorage(sCtx(), LibraryPhraseSet(studyId)); err != nil {
+		sLog().Error("db failure on library phrases delete",
+			zap.String("studyId", studyId), zap.Error(err))
+		return err
+	}
+	// next, delete all the messages, questionnaires, and consent documents
+	if err = deleteAllStudyMessages(studyId); err != nil {
+		return err
+	}
+	if err = platform.DeleteStorage(sCtx(), QuestionnaireIndex(studyId)); err != nil {
+		sLog().Error("db failure on questionnaires delete",
+			zap.String("studyId", studyId), zap.Error(err))
+		return err
+	}
+	if err = platform.DeleteStorage(sCtx(), InactiveParticipants(studyId)); err != nil {
+		sLog().Error("db failure on inactive participants delete",
+			zap.String("studyId", studyId), zap.Error(err))
+		return err
+	}
+	if err = platform.DeleteStorage(sCtx(), ConsentDocumentIndex(studyId)); err != nil {
+		sLog().Error("db failure on consent documents delete",
+			zap.String("studyId", studyId), zap.Error(err))
+		return err
+	}
+	if err = platform.DeleteStorage(sCtx(), WithdrawalList(studyId)); err != nil {
+		sLog().Error("db failure on withdrawals delete",
+			zap.String("studyId", studyId), zap.Error(err))
+		return err
+	}
+	if err = platform.DeleteStorage(sCtx(), ArmBlocks(studyId)); err != nil {
+		sLog().Error("db failure on arm blocks delete",
+			zap.String("studyId", studyId), zap.Error(err))
+		return err
+	}
+	// next, delete all the line and phrase stats for the participants
 	for _, p := range participants {
 		if err = platform.DeleteStorage(sCtx(), StudyTypedLineStatsIndex(studyId+"+"+p.Upn)); err != nil {
 			sLog().Error("db failure on typed line stats delete",
 				zap.String("studyId", studyId), zap.String("upn", p.Upn), zap.Error(err))
 			return err
 		}
+		if err = platform.DeleteStorage(sCtx(), ParticipantPhraseStatsIndex(studyId+"+"+p.Upn)); err != nil {
+			sLog().Error("db failure on participant phrase stats delete",
+				zap.String("studyId", studyId), zap.String("upn", p.Upn), zap.Error(err))
+			return err
+		}
+		if err = platform.DeleteStorage(sCtx(), QuestionnaireResponseList(studyId+"+"+p.Upn)); err != nil {
+			sLog().Error("db failure on questionnaire responses delete",
+				zap.String("studyId", studyId), zap.String("upn", p.Upn), zap.Error(err))
+			return err
+		}
+		if err = platform.DeleteStorage(sCtx(), ConsentRecordList(studyId+"+"+p.Upn)); err != nil {
+			sLog().Error("db failure on consent records delete",
+				zap.String("studyId", studyId), zap.String("upn", p.Upn), zap.Error(err))
+			return err
+		}
 	}
 	// finally, delete all the participants
 	if err = platform.DeleteStorage(sCtx(), ParticipantIndex(studyId)); err != nil {
@@ -159,6 +250,14 @@ type StudyParticipant struct {
 	ApiKey    string
 	VoiceId   string
 	VoiceName string
+	// the latest consent document version the participant accepted, and when
+	ConsentVersion  int64
+	ConsentAccepted int64
+	Withdrawn       int64 // set when the participant withdraws, which is permanent
+	// the participant's study arm, when they were assigned it, and the stratum used to assign it
+	Arm         string
+	ArmAssigned int64
+	Stratum     string
 }
 
 func (s *StudyParticipant) ToRedis() ([]byte, error) {
@@ -186,19 +285,62 @@ func (s *StudyParticipant) save() error {
 	return nil
 }
 
+// updateParticipant atomically applies a change to a stored participant and returns
+// the result. The change is told whether the participant was found, and returns whether
+// the participant should be stored. It may be called more than once.
+func updateParticipant(studyId, upn string, change func(p *StudyParticipant, found bool) (bool, error)) (*StudyParticipant, error) {
+	var p StudyParticipant
+	var changeErr error
+	update := func(found bool) (bool, error) {
+		ok, err := change(&p, found)
+		changeErr = err
+		return ok, err
+	}
+	// lowercase the UPN to prevent lookup errors
+	_, err := platform.MapUpdateValue(sCtx(), ParticipantIndex(studyId), strings.ToLower(upn), &p, update)
+	if err != nil {
+		if err != changeErr {
+			sLog().Error("db failure on participant update",
+				zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+// update atomically applies a change to this participant's stored state,
+// and then replaces this participant with the result. If the participant
+// has been deleted in the meantime, it isn't recreated.
+func (s *StudyParticipant) update(change func(p *StudyParticipant)) error {
+	p, err := updateParticipant(s.StudyId, s.Upn, func(p *StudyParticipant, found bool) (bool, error) {
+		if !found {
+			return false, ParticipantNotValidError
+		}
+		change(p)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	*s = *p
+	return nil
+}
+
 func (s *StudyParticipant) UpdateApiKey(apiKey string) (bool, error) {
-	if apiKey == "" {
-		s.ApiKey = ""
-		s.VoiceId = ""
-	} else {
+	if apiKey != "" {
 		if ok, err := services.ElevenValidateApiKey(apiKey); err != nil {
 			return false, err
 		} else if !ok {
 			return false, nil
 		}
-		s.ApiKey = apiKey
 	}
-	if err := s.save(); err != nil {
+	err := s.update(func(p *StudyParticipant) {
+		p.ApiKey = apiKey
+		if apiKey == "" {
+			p.VoiceId = ""
+		}
+	})
+	if err != nil {
 		return false, err
 	}
 	return true, nil
@@ -214,23 +356,23 @@ func (s *StudyParticipant) UpdateVoiceId(voiceId string) (bool, error) {
 	} else if !ok {
 		return false, nil
 	}
-	s.VoiceId = voiceId
-	s.VoiceName = name
-	if err := s.save(); err != nil {
+	err = s.update(func(p *StudyParticipant) {
+		p.VoiceId = voiceId
+		p.VoiceName = name
+	})
+	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
 func (s *StudyParticipant) UpdateAssignment(memo string) error {
-	s.Memo = memo
-	if s.Assigned == 0 {
-		s.Assigned = time.Now().UnixMilli()
-	}
-	if err := s.save(); err != nil {
-		return err
-	}
-	return nil
+	return s.update(func(p *StudyParticipant) {
+		p.Memo = memo
+		if p.Assigned == 0 {
+			p.Assigned = time.Now().UnixMilli()
+		}
+	})
 }
 
 func GetStudyParticipant(studyId, upn string) (*StudyParticipant, error) {
@@ -254,7 +396,7 @@ func GetStudyParticipant(studyId, upn string) (*StudyParticipant, error) {
 }
 
 // DeleteStudyParticipant should be used with caution because it will also delete
-// any collected line stats for this participant.
+// any collected line and phrase stats, questionnaire responses, and consent records for this participant.
 func DeleteStudyParticipant(studyId, upn string) error {
 	s, err := GetStudyParticipant(studyId, upn)
 	if err != nil {
@@ -276,6 +418,18 @@ func DeleteStudyParticipant(studyId, upn string) error {
 		sLog().Error("db failure on typed line stats delete",
 			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
 	}
+	if err = platform.DeleteStorage(sCtx(), ParticipantPhraseStatsIndex(studyId+"+"+upn)); err != nil {
+		sLog().Error("db failure on participant phrase stats delete",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+	}
+	if err = platform.DeleteStorage(sCtx(), QuestionnaireResponseList(studyId+"+"+upn)); err != nil {
+		sLog().Error("db failure on questionnaire responses delete",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+	}
+	if err = platform.DeleteStorage(sCtx(), ConsentRecordList(studyId+"+"+upn)); err != nil {
+		sLog().Error("db failure on consent records delete",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+	}
 	return nil
 }
 
@@ -300,18 +454,13 @@ func GetAllStudyParticipants(studyId string) ([]*StudyParticipant, error) {
 }
 
 func CreateStudyParticipant(studyId, upn string) (*StudyParticipant, error) {
-	existing, err := GetStudyParticipant(studyId, upn)
-	if err != nil {
-		return nil, err
-	}
-	if existing != nil {
-		return nil, ParticipantAlreadyExistsError
-	}
-	p := &StudyParticipant{Upn: upn, StudyId: studyId}
-	if err = p.save(); err != nil {
-		return nil, err
-	}
-	return p, nil
+	return updateParticipant(studyId, upn, func(p *StudyParticipant, found bool) (bool, error) {
+		if found {
+			return false, ParticipantAlreadyExistsError
+		}
+		*p = StudyParticipant{Upn: upn, StudyId: studyId}
+		return true, nil
+	})
 }
 
 var (
@@ -334,64 +483,145 @@ func GetProfileStudyMembership(profileId string) (studyId string, upn string, er
 	return
 }
 
-func EnrollStudyParticipant(profileId, studyId, upn string) (*StudyParticipant, error) {
-	var p *StudyParticipant
-	p, err := GetStudyParticipant(studyId, upn)
+// GetAllProfileStudyMemberships returns the study ID and UPN of every enrolled profile.
+func GetAllProfileStudyMemberships() (map[string][2]string, error) {
+	m, err := platform.MapGetAll(sCtx(), profileParticipantMap)
 	if err != nil {
+		sLog().Error("map get failure on profile map fetch", zap.Error(err))
 		return nil, err
 	}
-	if p == nil {
-		return nil, ParticipantNotAvailableError
+	result := make(map[string][2]string, len(m))
+	for profileId, id := range m {
+		studyId, upn, _ := strings.Cut(id, "+")
+		result[profileId] = [2]string{studyId, upn}
 	}
-	if p.Assigned == 0 {
-		sLog().Info("auto-assigning participant",
-			zap.String("studyId", studyId), zap.String("upn", upn), zap.String("profileId", profileId))
-		p.Assigned = time.Now().UnixMilli()
-		p.Memo = "in-app"
-	}
-	if p.ProfileId == "" {
-		p.ProfileId = profileId
-		p.Started = time.Now().UnixMilli()
-	} else if p.ProfileId == profileId {
-		// participant is re-enrolling
-		p.Finished = 0
-	} else {
-		return nil, ParticipantNotAvailableError
+	return result, nil
+}
+
+// SetProfileStudyMembership records the profile as enrolled in the study as the UPN.
+// This is normally done by EnrollStudyParticipant; it's only needed for repairs.
+func SetProfileStudyMembership(profileId, studyId, upn string) error {
+	if err := platform.MapSet(sCtx(), profileParticipantMap, profileId, studyId+"+"+upn); err != nil {
+		sLog().Error("map set failure on profile membership",
+			zap.String("profileId", profileId), zap.String("studyId", studyId), zap.String("upn", upn),
+			zap.Error(err))
+		return err
+	}
+	return profileClientConfigDidChange(profileId)
+}
+
+// RemoveProfileStudyMembership records the profile as not enrolled in any study.
+// This is normally done by UnenrollStudyParticipant; it's only needed for repairs.
+func RemoveProfileStudyMembership(profileId string) error {
+	if err := platform.MapRemove(sCtx(), profileParticipantMap, profileId); err != nil {
+		sLog().Error("map remove failure on profile membership",
+			zap.String("profileId", profileId), zap.Error(err))
+		return err
 	}
-	if err = p.save(); err != nil {
+	return profileClientConfigDidChange(profileId)
+}
+
+// EnrollStudyParticipant enrolls the profile in the study as the UPN. If the study has
+// a consent document, consentVersion must be its current version, and the participant's
+// consent is recorded as part of their enrollment; if it can't be recorded, they aren't enrolled.
+func EnrollStudyParticipant(profileId, clientId, studyId, upn string, consentVersion int64) (*StudyParticipant, error) {
+	autoAssigned := false
+	var consent *ConsentRecord
+	p, err := updateParticipant(studyId, upn, func(p *StudyParticipant, found bool) (bool, error) {
+		if !found || p.Withdrawn > 0 {
+			return false, ParticipantNotAvailableError
+		}
+		current, err := GetCurrentConsentDocument(studyId)
+		if err != nil {
+			return false, err
+		}
+		if current != nil {
+			if current.Version != consentVersion {
+				return false, ConsentVersionNotCurrentError
+			}
+			consent = &ConsentRecord{Upn: upn, ProfileId: profileId, ClientId: clientId,
+				Version: consentVersion, Accepted: time.Now().UnixMilli()}
+			p.ConsentVersion, p.ConsentAccepted = consent.Version, consent.Accepted
+		}
+		autoAssigned = p.Assigned == 0
+		if autoAssigned {
+			p.Assigned = time.Now().UnixMilli()
+			p.Memo = "in-app"
+		}
+		if p.ProfileId == "" {
+			p.ProfileId = profileId
+			p.Started = time.Now().UnixMilli()
+		} else if p.ProfileId == profileId {
+			// participant is re-enrolling
+			p.Finished = 0
+		} else {
+			return false, ParticipantNotAvailableError
+		}
+		return true, nil
+	})
+	if err != nil {
 		return nil, err
 	}
+	if autoAssigned {
+		sLog().Info("auto-assigned participant",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.String("profileId", profileId))
+	}
+	if consent != nil {
+		if err = pushConsentRecords(studyId, upn, *consent); err != nil {
+			// without a record of their consent, the participant can't stay enrolled
+			_ = UnenrollStudyParticipant(profileId, studyId, upn)
+			return nil, err
+		}
+		if err = clearProfileConsentRequired(profileId); err != nil {
+			return nil, err
+		}
+		sLog().Info("participant consent recorded", zap.String("studyId", studyId),
+			zap.String("upn", upn), zap.Int64("version", consent.Version), zap.String("clientId", clientId))
+	}
 	if err = platform.MapSet(sCtx(), profileParticipantMap, profileId, studyId+"+"+upn); err != nil {
 		sLog().Error("map set failure on participant assignment",
 			zap.String("profileId", profileId), zap.String("studyId", studyId), zap.String("upn", upn),
 			zap.Error(err))
 		return nil, err
 	}
+	if err = profileClientConfigDidChange(profileId); err != nil {
+		return nil, err
+	}
+	// participants who weren't randomized when they were created are randomized now,
+	// but the caller applies the arm's voice, because it may already have been assigned
+	study, err := GetStudy(studyId)
+	if err != nil {
+		return nil, err
+	}
+	if study != nil {
+		if _, err = assignStudyArm(study, p); err != nil {
+			return nil, err
+		}
+	}
 	return p, nil
 }
 
 func UnenrollStudyParticipant(profileId string, studyId, upn string) error {
-	p, err := GetStudyParticipant(studyId, upn)
+	_, err := updateParticipant(studyId, upn, func(p *StudyParticipant, found bool) (bool, error) {
+		if !found || p.ProfileId != profileId {
+			return false, ParticipantNotValidError
+		}
+		p.Finished = time.Now().UnixMilli()
+		return true, nil
+	})
 	if err != nil {
 		return err
 	}
-	if p == nil {
-		return ParticipantNotValidError
-	}
-	if p.ProfileId != profileId {
-		return ParticipantNotValidError
-	}
-	p.Finished = time.Now().UnixMilli()
-	if err = p.save(); err != nil {
-		return err
-	}
 	if err = platform.MapRemove(sCtx(), profileParticipantMap, profileId); err != nil {
 		sLog().Error("map remove failure on participant unassignment",
 			zap.String("profileId", profileId), zap.String("studyId", studyId), zap.String("upn", upn),
 			zap.Error(err))
 		return err
 	}
-	return nil
+	if err = profileClientConfigDidChange(profileId); err != nil {
+		return err
+	}
+	return clearProfileConsentRequired(profileId)
 }
 
 type Platform = uint8
@@ -406,6 +636,27 @@ const (
 
 var PlatformNames = []string{"Unknown", "Phone", "Tablet", "Computer", "Browser"}
 
+// ParsePlatform returns the platform given in an X-Platform-Info header.
+func ParsePlatform(header string) Platform {
+	switch header {
+	case "phone":
+		return PlatformPhone
+	case "pad", "tablet":
+		return PlatformTablet
+	case "mac", "windows", "linux", "android":
+		return PlatformComputer
+	case "web":
+		return PlatformBrowser
+	default:
+		return PlatformUnknown
+	}
+}
+
+// PlatformName returns the display name of the platform given in an X-Platform-Info header.
+func PlatformName(header string) string {
+	return PlatformNames[ParsePlatform(header)]
+}
+
 // A StudyTypedLineStatsIndex is a study's map from UPN to the list of TypedLineStat values for that participant.
 //
 // Rather than keeping this as a hash table keyed by UPN, where each value is a list, we instead keep each list
@@ -522,6 +773,7 @@ type PhraseStat struct {
 	Content       string // Content of canned line
 	FavoriteCount int64  // Count of uses as a favorite
 	RepeatCount   int64  // Count of uses as a repeat
+	LibraryCount  int64  // Count of those uses (of either kind) made while the phrase was in a study library
 }
 
 func (s *PhraseStat) ToRedis() ([]byte, error) {
@@ -548,32 +800,154 @@ func (i PhraseStatsIndex) StorageId() string {
 
 var whitespace = regexp.MustCompile(`\s+`)
 
-func GetOrCreatePhraseStat(studyId, text string) (*PhraseStat, error) {
-	text = strings.ToLower(whitespace.ReplaceAllLiteralString(strings.TrimSpace(text), " "))
+// phraseHash returns the hash of a phrase's (normalized) content
+func phraseHash(text string) string {
 	hasher := fnv.New64a()
 	_, _ = hasher.Write([]byte(text))
-	hash := strconv.FormatUint(hasher.Sum64(), 32)
-	val, err := platform.MapGet(sCtx(), PhraseStatsIndex(studyId), hash)
+	return strconv.FormatUint(hasher.Sum64(), 32)
+}
+
+// CountPhraseUse counts a use of the phrase as a favorite or a repeat, both in
+// the study's totals and in the participant's bucket for the day of use, creating
+// the stats if this is the first use. Uses of phrases from the study's libraries
+// are also counted separately. If the time of use is 0, the phrase was used now.
+func CountPhraseUse(studyId, upn, text string, isFavorite bool, used int64) error {
+	text = normalizePhrase(text)
+	hash := phraseHash(text)
+	inLibrary, err := IsLibraryPhrase(studyId, text)
 	if err != nil {
-		sLog().Error("map get failure on canned line stat lookup",
+		sLog().Error("db failure on library phrase check",
 			zap.String("studyId", studyId), zap.String("hash", hash), zap.Error(err))
-		return nil, err
-	}
-	if val == "" {
-		return &PhraseStat{Hash: hash, Content: text}, nil
+		return err
 	}
 	var s PhraseStat
-	if err := s.FromRedis([]byte(val)); err != nil {
-		sLog().Error("deserialization failure on canned line stat",
+	update := func(found bool) (bool, error) {
+		if !found {
+			s = PhraseStat{Hash: hash, Content: text}
+		} else if s.Content != text {
+			sLog().Info("hash collision on canned line stat",
+				zap.String("hash", s.Hash),
+				zap.String("existing", s.Content), zap.String("ignored", text))
+		}
+		if isFavorite {
+			s.FavoriteCount++
+		} else {
+			s.RepeatCount++
+		}
+		if inLibrary {
+			s.LibraryCount++
+		}
+		return true, nil
+	}
+	if _, err := platform.MapUpdateValue(sCtx(), PhraseStatsIndex(studyId), hash, &s, update); err != nil {
+		sLog().Error("db failure on canned line stat update",
 			zap.String("studyId", studyId), zap.String("hash", hash), zap.Error(err))
-		return nil, err
+		return err
+	}
+	if upn == "" {
+		return nil
 	}
-	if s.Content != text {
-		sLog().Info("hash collision on canned line stat",
-			zap.String("hash", s.Hash),
-			zap.String("existing", s.Content), zap.String("ignored", text))
+	if used == 0 {
+		used = time.Now().UnixMilli()
 	}
-	return &s, nil
+	field := phraseBucketField(adminDay(used), hash)
+	index := ParticipantPhraseStatsIndex(studyId + "+" + upn)
+	if _, err := platform.MapUpdateValue(sCtx(), index, field, &s, update); err != nil {
+		sLog().Error("db failure on participant phrase stat update",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.String("field", field), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// The ParticipantPhraseStatsIndex of a study ID and UPN (joined by +) maps from
+// day and phrase hash (also joined by +) to the participant's PhraseStat for that day.
+type ParticipantPhraseStatsIndex string
+
+func (i ParticipantPhraseStatsIndex) StoragePrefix() string {
+	return "participant-phrase-stats:"
+}
+func (i ParticipantPhraseStatsIndex) StorageId() string {
+	return string(i)
+}
+
+// adminDay returns the day (in the admin time zone) that contains the given time.
+func adminDay(when int64) string {
+	return time.UnixMilli(when).In(AdminTZ).Format(time.DateOnly)
+}
+
+func phraseBucketField(day, hash string) string {
+	return day + "+" + hash
+}
+
+// A ParticipantPhraseStat records a participant's usage of a phrase.
+type ParticipantPhraseStat struct {
+	Upn  string
+	Stat PhraseStat
+}
+
+// FetchParticipantPhraseStats returns the participant's daily phrase stats,
+// keyed by day and phrase hash (joined by +).
+func FetchParticipantPhraseStats(studyId, upn string) (map[string]PhraseStat, error) {
+	m, err := platform.MapGetAll(sCtx(), ParticipantPhraseStatsIndex(studyId+"+"+upn))
+	if err != nil {
+		return nil, fmt.Errorf("db failure fetch participant stats map: %w", err)
+	}
+	result := make(map[string]PhraseStat, len(m))
+	for field, v := range m {
+		var s PhraseStat
+		if err := s.FromRedis([]byte(v)); err != nil {
+			return nil, fmt.Errorf("deserialization failure on participant phrase stat: %w", err)
+		}
+		result[field] = s
+	}
+	return result, nil
+}
+
+// FetchAllParticipantPhraseStats totals the daily phrase stats of each of the
+// given participants (or all of them, if none are given) over the days that
+// overlap the given time range. The results are grouped by participant.
+func FetchAllParticipantPhraseStats(studyId string, start int64, end int64, upns []string) ([]ParticipantPhraseStat, error) {
+	if len(upns) == 0 {
+		participants, err := GetAllStudyParticipants(studyId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch study members: %w", err)
+		}
+		for _, p := range participants {
+			upns = append(upns, p.Upn)
+		}
+		slices.Sort(upns)
+	}
+	firstDay, lastDay := adminDay(start), adminDay(end)
+	var results []ParticipantPhraseStat
+	for _, upn := range upns {
+		buckets, err := FetchParticipantPhraseStats(studyId, upn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch phrase stats for %q: %w", upn, err)
+		}
+		totals := make(map[string]*PhraseStat)
+		var hashes []string
+		for field, s := range buckets {
+			day, hash, _ := strings.Cut(field, "+")
+			if day < firstDay || day > lastDay {
+				continue
+			}
+			t := totals[hash]
+			if t == nil {
+				t = &PhraseStat{Hash: hash, Content: s.Content}
+				totals[hash] = t
+				hashes = append(hashes, hash)
+			}
+			t.FavoriteCount += s.FavoriteCount
+			t.RepeatCount += s.RepeatCount
+			t.LibraryCount += s.LibraryCount
+		}
+		slices.Sort(hashes)
+		for _, hash := range hashes {
+			results = append(results, ParticipantPhraseStat{Upn: upn, Stat: *totals[hash]})
+		}
+	}
+	return results, nil
 }
 
 func SavePhraseStat(studyId string, s *PhraseStat) error {
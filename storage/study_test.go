@@ -0,0 +1,157 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+func TestCountPhraseUseConcurrently(t *testing.T) {
+	_, _ = platform.GetDb() // connect before going parallel
+	studyId, upn := uuid.NewString(), "upn-"+uuid.NewString()
+	defer func() {
+		_ = platform.DeleteStorage(sCtx(), PhraseStatsIndex(studyId))
+		_ = platform.DeleteStorage(sCtx(), ParticipantPhraseStatsIndex(studyId+"+"+upn))
+	}()
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				if err := CountPhraseUse(studyId, upn, "Hello there", i%2 == 0, 0); err != nil {
+					t.Errorf("CountPhraseUse failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var stat PhraseStat
+	if val, err := platform.MapGet(sCtx(), PhraseStatsIndex(studyId), phraseHash(normalizePhrase("Hello there"))); err != nil {
+		t.Fatal(err)
+	} else if err := stat.FromRedis([]byte(val)); err != nil {
+		t.Fatal(err)
+	}
+	if stat.FavoriteCount != 100 || stat.RepeatCount != 100 {
+		t.Errorf("Study phrase stat after parallel uses is %#v, expected 100 favorite and 100 repeat", stat)
+	}
+	buckets, err := FetchParticipantPhraseStats(studyId, upn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var favorites, repeats int64
+	for _, stat := range buckets {
+		favorites += stat.FavoriteCount
+		repeats += stat.RepeatCount
+	}
+	if favorites != 100 || repeats != 100 {
+		t.Errorf("Participant phrase stats after parallel uses are %d favorite and %d repeat, expected 100 each",
+			favorites, repeats)
+	}
+}
+
+func TestRecordProblemReportConcurrently(t *testing.T) {
+	_, _ = platform.GetDb() // connect before going parallel
+	clientId, profileId := uuid.NewString(), uuid.NewString()
+	fingerprint := ProblemFingerprint(ProblemKindAnomaly, profileId, "test anomaly", "")
+	defer func() { _ = DeleteProblemReport(fingerprint) }()
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				_, err := RecordProblemReport(ProblemKindAnomaly, "test", clientId, profileId, "test anomaly", "")
+				if err != nil {
+					t.Errorf("RecordProblemReport failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	p, err := GetProblemReport(fingerprint)
+	if err != nil || p == nil {
+		t.Fatalf("Problem report after parallel reports is %v (%v)", p, err)
+	}
+	if p.Count != 200 || p.RecentCount != 200 {
+		t.Errorf("Problem report after parallel reports has counts %d and %d, expected 200",
+			p.Count, p.RecentCount)
+	}
+}
+
+func TestParticipantUpdateConcurrently(t *testing.T) {
+	_, _ = platform.GetDb() // connect before going parallel
+	studyId, upn := uuid.NewString(), "upn-"+uuid.NewString()
+	defer func() { _ = platform.DeleteStorage(sCtx(), ParticipantIndex(studyId)) }()
+	if _, err := CreateStudyParticipant(studyId, upn); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := GetStudyParticipant(studyId, upn)
+			if err != nil || p == nil {
+				t.Errorf("GetStudyParticipant failed: %v", err)
+				return
+			}
+			for range 10 {
+				if err := p.update(func(p *StudyParticipant) { p.ConsentVersion++ }); err != nil {
+					t.Errorf("participant update failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	p, err := GetStudyParticipant(studyId, upn)
+	if err != nil || p == nil || p.ConsentVersion != 200 {
+		t.Fatalf("Participant after parallel updates is %#v (%v), expected count 200", p, err)
+	}
+	if err := DeleteStudyParticipant(studyId, upn); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.update(func(p *StudyParticipant) { p.ConsentVersion++ }); !errors.Is(err, ParticipantNotValidError) {
+		t.Errorf("Update of deleted participant returned %v, expected ParticipantNotValidError", err)
+	}
+	if p, err := GetStudyParticipant(studyId, upn); err != nil || p != nil {
+		t.Errorf("Deleted participant was recreated by update: %#v (%v)", p, err)
+	}
+}
+
+func TestCreateStudyParticipantConcurrently(t *testing.T) {
+	_, _ = platform.GetDb() // connect before going parallel
+	studyId, upn := uuid.NewString(), "upn-"+uuid.NewString()
+	defer func() { _ = platform.DeleteStorage(sCtx(), ParticipantIndex(studyId)) }()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := CreateStudyParticipant(studyId, upn)
+			switch {
+			case err == nil:
+				mu.Lock()
+				created++
+				mu.Unlock()
+			case !errors.Is(err, ParticipantAlreadyExistsError):
+				t.Errorf("CreateStudyParticipant failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("Parallel creates made the participant %d times, expected once", created)
+	}
+}
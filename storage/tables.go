@@ -0,0 +1,208 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+type ReportFormat = string
+
+const (
+	ReportFormatXlsx   ReportFormat = "xlsx"
+	ReportFormatCsv    ReportFormat = "csv"
+	ReportFormatNdjson ReportFormat = "ndjson"
+)
+
+var ReportFormats = []ReportFormat{ReportFormatXlsx, ReportFormatCsv, ReportFormatNdjson}
+
+//goland:noinspection SpellCheckingInspection
+var reportContentTypes = map[ReportFormat]string{
+	ReportFormatXlsx:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	ReportFormatCsv:    "text/csv; charset=utf-8",
+	ReportFormatNdjson: "application/x-ndjson",
+}
+
+// ReportContentType returns the MIME type of reports in the given format.
+func ReportContentType(format ReportFormat) string {
+	if t, ok := reportContentTypes[format]; ok {
+		return t
+	}
+	return reportContentTypes[ReportFormatXlsx]
+}
+
+// A reportColumn describes one column of a report table.
+// Cells in the column can be strings, int64s, or times.
+type reportColumn struct {
+	Heading  string
+	Key      string  // the field name used in NDJSON output
+	Width    float64 // the spreadsheet column width
+	Centered bool    // whether spreadsheet cells are centered
+}
+
+// A reportTable is the format-independent content of a report.
+// Multi-table reports become multiple sheets in a workbook.
+type reportTable struct {
+	Name    string
+	Columns []reportColumn
+	Rows    [][]any
+}
+
+func (t *reportTable) addRow(cells ...any) {
+	t.Rows = append(t.Rows, cells)
+}
+
+// writeReportTables saves the tables to the named file in the given format.
+// CSV files can only contain one table; NDJSON records from multiple tables
+// are distinguished by a "table" field.
+func writeReportTables(name string, format ReportFormat, tables ...*reportTable) error {
+	switch format {
+	case ReportFormatXlsx, "":
+		return writeXlsxTables(name, tables)
+	case ReportFormatCsv:
+		if len(tables) != 1 {
+			return fmt.Errorf("a CSV report must have exactly one table, not %d", len(tables))
+		}
+		return writeCsvTable(name, tables[0])
+	case ReportFormatNdjson:
+		return writeNdjsonTables(name, tables)
+	}
+	return fmt.Errorf("unknown report format: %s", format)
+}
+
+const xlDateFormat = `yyyy/mm/dd hh:mm:ss.000`
+
+// textDateFormat is how times appear in text formats
+const textDateFormat = "2006-01-02T15:04:05.000Z07:00"
+
+func writeXlsxTables(name string, tables []*reportTable) error {
+	xlsx.SetDefaultFont(12, "Arial")
+	xf := xlsx.NewFile()
+	headingStyle := xlsx.NewStyle()
+	headingStyle.Alignment.Horizontal = "center"
+	headingStyle.Font.Bold = true
+	centeredStyle := xlsx.NewStyle()
+	centeredStyle.Font.Bold = false
+	centeredStyle.Alignment.Horizontal = "center"
+	for _, t := range tables {
+		xs, err := xf.AddSheet(t.Name)
+		if err != nil {
+			return fmt.Errorf("failed to create the %q worksheet: %w", t.Name, err)
+		}
+		headingsRow := xs.AddRow()
+		for i, c := range t.Columns {
+			cell := headingsRow.AddCell()
+			cell.SetString(c.Heading)
+			cell.SetStyle(headingStyle)
+			col := xlsx.NewColForRange(i+1, i+1)
+			col.SetWidth(c.Width)
+			if c.Centered {
+				col.SetStyle(centeredStyle)
+			}
+			xs.SetColParameters(col)
+		}
+		for _, cells := range t.Rows {
+			row := xs.AddRow()
+			for _, v := range cells {
+				cell := row.AddCell()
+				switch v := v.(type) {
+				case string:
+					cell.SetString(v)
+				case int64:
+					cell.SetInt64(v)
+				case time.Time:
+					cell.SetDateTimeWithFormat(xlsx.TimeToExcelTime(v, xf.Date1904), xlDateFormat)
+				default:
+					cell.SetString(fmt.Sprint(v))
+				}
+			}
+		}
+	}
+	if err := xf.Save(name); err != nil {
+		return fmt.Errorf("failed to save the report to %q: %w", name, err)
+	}
+	return nil
+}
+
+func writeCsvTable(name string, t *reportTable) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create the report file %q: %w", name, err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	headings := make([]string, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		headings = append(headings, c.Heading)
+	}
+	if err := w.Write(headings); err != nil {
+		return fmt.Errorf("failed to write the report to %q: %w", name, err)
+	}
+	for _, cells := range t.Rows {
+		record := make([]string, 0, len(cells))
+		for _, v := range cells {
+			switch v := v.(type) {
+			case string:
+				record = append(record, v)
+			case int64:
+				record = append(record, strconv.FormatInt(v, 10))
+			case time.Time:
+				record = append(record, v.Format(textDateFormat))
+			default:
+				record = append(record, fmt.Sprint(v))
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return fmt.Errorf("failed to write the report to %q: %w", name, err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write the report to %q: %w", name, err)
+	}
+	return f.Close()
+}
+
+func writeNdjsonTables(name string, tables []*reportTable) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create the report file %q: %w", name, err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, t := range tables {
+		for _, cells := range t.Rows {
+			record := make(map[string]any, len(cells)+1)
+			if len(tables) > 1 {
+				record["table"] = t.Name
+			}
+			for i, v := range cells {
+				if tv, ok := v.(time.Time); ok {
+					v = tv.Format(textDateFormat)
+				}
+				record[t.Columns[i].Key] = v
+			}
+			if err := encoder.Encode(record); err != nil {
+				return fmt.Errorf("failed to write the report to %q: %w", name, err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write the report to %q: %w", name, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,1146 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+// A TransferFilter restricts which stored objects are dumped or loaded.
+//
+// If StudyIds is non-empty, only objects belonging to those studies are included,
+// and profile-keyed objects are only included for the profiles of their participants.
+// If Prefixes is non-empty, only categories whose storage prefix starts with
+// one of the given prefixes are included.
+type TransferFilter struct {
+	StudyIds []string
+	Prefixes []string
+}
+
+func (f *TransferFilter) includesPrefix(prefix string) bool {
+	if len(f.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range f.Prefixes {
+		if strings.HasPrefix(prefix, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *TransferFilter) includesStudy(studyId string) bool {
+	return len(f.StudyIds) == 0 || slices.Contains(f.StudyIds, studyId)
+}
+
+// StudyLineStats is the transfer form of a participant's typed line stats.
+type StudyLineStats struct {
+	StudyId string
+	Upn     string
+	Stats   []TypedLineStat
+}
+
+// StudyPhraseStat is the transfer form of a study's phrase stat.
+type StudyPhraseStat struct {
+	StudyId string
+	Stat    PhraseStat
+}
+
+// StudyParticipantPhraseStats is the transfer form of a participant's daily phrase stats,
+// keyed by day and phrase hash (joined by +).
+type StudyParticipantPhraseStats struct {
+	StudyId string
+	Upn     string
+	Stats   map[string]PhraseStat
+}
+
+// ProfileSessionEvents is the transfer form of a profile's session history.
+type ProfileSessionEvents struct {
+	ProfileId string
+	Events    []SessionEvent
+}
+
+// ProfileFavoritesHistory is the transfer form of a profile's favorites history.
+type ProfileFavoritesHistory struct {
+	ProfileId string
+	Versions  []*FavoritesVersion // newest first
+}
+
+// ProfileDevices is the transfer form of a profile's devices.
+type ProfileDevices struct {
+	ProfileId string
+	Devices   []*Device
+}
+
+// StudyQuestionnaireResponses is the transfer form of a participant's questionnaire responses.
+type StudyQuestionnaireResponses struct {
+	StudyId   string
+	Upn       string
+	Responses []QuestionnaireResponse
+}
+
+// StudyConsentRecords is the transfer form of a participant's consent records.
+type StudyConsentRecords struct {
+	StudyId string
+	Upn     string
+	Records []ConsentRecord
+}
+
+// StudyWithdrawals is the transfer form of a study's withdrawal tombstones.
+type StudyWithdrawals struct {
+	StudyId     string
+	Withdrawals []*WithdrawalTombstone
+}
+
+// StudyArmBlocks is the transfer form of a study's arm blocks, keyed by stratum.
+type StudyArmBlocks struct {
+	StudyId string
+	Blocks  map[string]string
+}
+
+// ProfileMessages is the transfer form of a profile's copies of study messages.
+type ProfileMessages struct {
+	ProfileId string
+	Messages  []*ProfileMessage
+}
+
+// MonitorRecord is the transfer form of a speech monitor and its schedule.
+type MonitorRecord struct {
+	Monitor SpeechMonitor
+	Score   float64
+}
+
+// A transferCategory knows how to dump and load one kind of stored object.
+type transferCategory struct {
+	name   string
+	prefix string
+	dump   func(f *TransferFilter, profiles map[string]bool) ([]any, error)
+	load   func(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error)
+}
+
+var transferCategories = []transferCategory{
+	{"studies", "map:study-index", dumpStudies, loadStudies},
+	{"participants", "study-members:", dumpParticipants, loadParticipants},
+	{"line-stats", "typed-line-stat-list:", dumpLineStats, loadLineStats},
+	{"phrase-stats", "phrase-stats:", dumpPhraseStats, loadPhraseStats},
+	{"participant-phrase-stats", "participant-phrase-stats:", dumpParticipantPhraseStats, loadParticipantPhraseStats},
+	{"phrase-libraries", "phrase-libraries:", dumpPhraseLibraries, loadPhraseLibraries},
+	{"questionnaires", "questionnaires:", dumpQuestionnaires, loadQuestionnaires},
+	{"questionnaire-responses", "questionnaire-responses:", dumpQuestionnaireResponses, loadQuestionnaireResponses},
+	{"consent-documents", "consent-documents:", dumpConsentDocuments, loadConsentDocuments},
+	{"consent-records", "consent-records:", dumpConsentRecords, loadConsentRecords},
+	{"withdrawals", "withdrawals:", dumpWithdrawals, loadWithdrawals},
+	{"arm-blocks", "arm-blocks:", dumpArmBlocks, loadArmBlocks},
+	{"study-messages", "study-messages:", dumpStudyMessages, loadStudyMessages},
+	{"reports", "study-reports:", dumpReports, loadReports},
+	{"admins", "admin-user:", dumpAdmins, loadAdmins},
+	{"speech-settings", "speech-settings:", dumpProfileObjects[SpeechSettings], loadProfileObjects[SpeechSettings]},
+	{"favorites-settings", "favorites-settings:", dumpProfileObjects[FavoritesSettings], loadProfileObjects[FavoritesSettings]},
+	{"favorites-history", "favorites-history:", dumpFavoritesHistories, loadFavoritesHistories},
+	{"lifecycle", "launch-data:", dumpProfileObjects[LifecycleData], loadProfileObjects[LifecycleData]},
+	{"session-events", "session-events:", dumpSessionEvents, loadSessionEvents},
+	{"devices", "profile-devices:", dumpDevices, loadDevices},
+	{"profile-messages", "profile-messages:", dumpProfileMessages, loadProfileMessages},
+	{"monitors", "speech-monitor:", dumpMonitors, loadMonitors},
+	{"version-policies", "map:client-version-policies", dumpVersionPolicies, loadVersionPolicies},
+	{"feature-flags", "map:feature-flags", dumpFeatureFlags, loadFeatureFlags},
+	{"problem-reports", "problem-report:", dumpProblemReports, loadProblemReports},
+}
+
+// TransferCategories returns the names and storage prefixes of the transferable categories.
+func TransferCategories() [][2]string {
+	result := make([][2]string, 0, len(transferCategories))
+	for _, c := range transferCategories {
+		result = append(result, [2]string{c.name, c.prefix})
+	}
+	return result
+}
+
+// DumpObjects collects all the stored objects that pass the filter, keyed by category.
+func DumpObjects(f TransferFilter) (platform.ObjectMap, error) {
+	profiles, err := filterProfiles(&f)
+	if err != nil {
+		return nil, err
+	}
+	result := make(platform.ObjectMap)
+	for _, c := range transferCategories {
+		if !f.includesPrefix(c.prefix) {
+			continue
+		}
+		objs, err := c.dump(&f, profiles)
+		if err != nil {
+			return nil, fmt.Errorf("dump %s: %w", c.name, err)
+		}
+		result[c.name] = objs
+	}
+	return result, nil
+}
+
+// LoadObjects stores all the dumped objects that pass the filter, and returns
+// the count loaded in each category. Unknown categories are an error.
+// If dryRun is true, the objects are decoded and counted but not stored.
+func LoadObjects(m platform.StoredObjectMap, f TransferFilter, dryRun bool) (map[string]int, error) {
+	counts := make(map[string]int)
+	for name := range m {
+		if !slices.ContainsFunc(transferCategories, func(c transferCategory) bool { return c.name == name }) {
+			return nil, fmt.Errorf("unknown category: %q", name)
+		}
+	}
+	// load in category order, so studies precede the objects that belong to them
+	for _, c := range transferCategories {
+		ms, ok := m[c.name]
+		if !ok || !f.includesPrefix(c.prefix) {
+			continue
+		}
+		n, err := c.load(&f, ms, dryRun)
+		if err != nil {
+			return counts, fmt.Errorf("load %s: %w", c.name, err)
+		}
+		counts[c.name] = n
+	}
+	return counts, nil
+}
+
+// filterProfiles returns the set of profiles that pass the filter, or nil if all do.
+func filterProfiles(f *TransferFilter) (map[string]bool, error) {
+	if len(f.StudyIds) == 0 {
+		return nil, nil
+	}
+	profiles := make(map[string]bool)
+	for _, studyId := range f.StudyIds {
+		participants, err := GetAllStudyParticipants(studyId)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range participants {
+			if p.ProfileId != "" {
+				profiles[p.ProfileId] = true
+			}
+		}
+	}
+	return profiles, nil
+}
+
+func filteredStudyIds(f *TransferFilter) ([]string, error) {
+	if len(f.StudyIds) > 0 {
+		return f.StudyIds, nil
+	}
+	return GetAllStudyIds()
+}
+
+// loadEach decodes each of the messages into a fresh value and hands it to the loader.
+func loadEach[T any](ms []json.RawMessage, load func(*T) (bool, error)) (int, error) {
+	count := 0
+	for i, js := range ms {
+		v := new(T)
+		if err := json.Unmarshal(js, v); err != nil {
+			return count, fmt.Errorf("decode item %d: %w", i, err)
+		}
+		if ok, err := load(v); err != nil {
+			return count, fmt.Errorf("store item %d: %w", i, err)
+		} else if ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func dumpStudies(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studies, err := GetAllStudies()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]any, 0, len(studies))
+	for _, s := range studies {
+		if f.includesStudy(s.Id) {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func loadStudies(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(s *Study) (bool, error) {
+		if !f.includesStudy(s.Id) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, s.Save()
+	})
+}
+
+func dumpParticipants(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studyIds, err := filteredStudyIds(f)
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, studyId := range studyIds {
+		participants, err := GetAllStudyParticipants(studyId)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range participants {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func loadParticipants(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(p *StudyParticipant) (bool, error) {
+		if !f.includesStudy(p.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		if err := p.save(); err != nil {
+			return false, err
+		}
+		// active participants are also in the profile map
+		if p.ProfileId != "" && p.Started > 0 && p.Finished == 0 {
+			err := SetProfileStudyMembership(p.ProfileId, p.StudyId, p.Upn)
+			return err == nil, err
+		}
+		return true, nil
+	})
+}
+
+func dumpLineStats(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, StudyTypedLineStatsIndex("")); err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, id := range ids {
+		studyId, upn, _ := strings.Cut(id, "+")
+		if !f.includesStudy(studyId) {
+			continue
+		}
+		stats, err := FetchTypedLineStats(studyId, upn, 0, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &StudyLineStats{StudyId: studyId, Upn: upn, Stats: stats})
+	}
+	return result, nil
+}
+
+func loadLineStats(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(s *StudyLineStats) (bool, error) {
+		if !f.includesStudy(s.StudyId) {
+			return false, nil
+		}
+		if dryRun || len(s.Stats) == 0 {
+			return true, nil
+		}
+		// replace any existing stats, so that loading is idempotent
+		index := StudyTypedLineStatsIndex(s.StudyId + "+" + s.Upn)
+		if err := platform.DeleteStorage(sCtx(), index); err != nil {
+			return false, err
+		}
+		return true, index.PushRange(s.Stats)
+	})
+}
+
+func dumpPhraseStats(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studyIds, err := filteredStudyIds(f)
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, studyId := range studyIds {
+		stats, err := FetchAllPhraseStats(studyId)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range stats {
+			result = append(result, &StudyPhraseStat{StudyId: studyId, Stat: s})
+		}
+	}
+	return result, nil
+}
+
+func loadPhraseStats(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(s *StudyPhraseStat) (bool, error) {
+		if !f.includesStudy(s.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, SavePhraseStat(s.StudyId, &s.Stat)
+	})
+}
+
+func dumpParticipantPhraseStats(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, ParticipantPhraseStatsIndex("")); err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, id := range ids {
+		studyId, upn, _ := strings.Cut(id, "+")
+		if !f.includesStudy(studyId) {
+			continue
+		}
+		stats, err := FetchParticipantPhraseStats(studyId, upn)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &StudyParticipantPhraseStats{StudyId: studyId, Upn: upn, Stats: stats})
+	}
+	return result, nil
+}
+
+func loadParticipantPhraseStats(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(s *StudyParticipantPhraseStats) (bool, error) {
+		if !f.includesStudy(s.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		index := ParticipantPhraseStatsIndex(s.StudyId + "+" + s.Upn)
+		for field, stat := range s.Stats {
+			b, err := stat.ToRedis()
+			if err != nil {
+				return false, err
+			}
+			if err := platform.MapSet(sCtx(), index, field, string(b)); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+func dumpPhraseLibraries(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studyIds, err := filteredStudyIds(f)
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, studyId := range studyIds {
+		libraries, err := GetAllPhraseLibraries(studyId)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range libraries {
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+func loadPhraseLibraries(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	studyIds := make(map[string]bool)
+	n, err := loadEach(ms, func(l *PhraseLibrary) (bool, error) {
+		if !f.includesStudy(l.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		studyIds[l.StudyId] = true
+		return true, l.save()
+	})
+	if err != nil {
+		return n, err
+	}
+	// the library phrase sets are derived from the libraries, so rebuild them
+	for studyId := range studyIds {
+		if err := rebuildLibraryPhraseSet(studyId); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func dumpQuestionnaires(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studyIds, err := filteredStudyIds(f)
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, studyId := range studyIds {
+		questionnaires, err := GetAllQuestionnaires(studyId)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range questionnaires {
+			result = append(result, q)
+		}
+	}
+	return result, nil
+}
+
+func loadQuestionnaires(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(q *Questionnaire) (bool, error) {
+		if !f.includesStudy(q.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, SaveQuestionnaire(q)
+	})
+}
+
+func dumpQuestionnaireResponses(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, QuestionnaireResponseList("")); err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, id := range ids {
+		studyId, upn, _ := strings.Cut(id, "+")
+		if !f.includesStudy(studyId) {
+			continue
+		}
+		responses, err := FetchQuestionnaireResponses(studyId, upn, 0, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &StudyQuestionnaireResponses{StudyId: studyId, Upn: upn, Responses: responses})
+	}
+	return result, nil
+}
+
+func loadQuestionnaireResponses(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(r *StudyQuestionnaireResponses) (bool, error) {
+		if !f.includesStudy(r.StudyId) {
+			return false, nil
+		}
+		if dryRun || len(r.Responses) == 0 {
+			return true, nil
+		}
+		// replace any existing responses, so that loading is idempotent
+		if err := platform.DeleteStorage(sCtx(), QuestionnaireResponseList(r.StudyId+"+"+r.Upn)); err != nil {
+			return false, err
+		}
+		return true, pushQuestionnaireResponses(r.StudyId, r.Upn, r.Responses...)
+	})
+}
+
+func dumpConsentDocuments(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studyIds, err := filteredStudyIds(f)
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, studyId := range studyIds {
+		documents, err := GetAllConsentDocuments(studyId)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range documents {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+func loadConsentDocuments(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(d *ConsentDocument) (bool, error) {
+		if !f.includesStudy(d.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, saveConsentDocument(d)
+	})
+}
+
+func dumpConsentRecords(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, ConsentRecordList("")); err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, id := range ids {
+		studyId, upn, _ := strings.Cut(id, "+")
+		if !f.includesStudy(studyId) {
+			continue
+		}
+		records, err := FetchConsentRecords(studyId, upn)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &StudyConsentRecords{StudyId: studyId, Upn: upn, Records: records})
+	}
+	return result, nil
+}
+
+func loadConsentRecords(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(r *StudyConsentRecords) (bool, error) {
+		if !f.includesStudy(r.StudyId) {
+			return false, nil
+		}
+		if dryRun || len(r.Records) == 0 {
+			return true, nil
+		}
+		// replace any existing records, so that loading is idempotent
+		if err := platform.DeleteStorage(sCtx(), ConsentRecordList(r.StudyId+"+"+r.Upn)); err != nil {
+			return false, err
+		}
+		return true, pushConsentRecords(r.StudyId, r.Upn, r.Records...)
+	})
+}
+
+func dumpWithdrawals(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studyIds, err := filteredStudyIds(f)
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, studyId := range studyIds {
+		withdrawals, err := FetchWithdrawals(studyId)
+		if err != nil {
+			return nil, err
+		}
+		if len(withdrawals) > 0 {
+			result = append(result, &StudyWithdrawals{StudyId: studyId, Withdrawals: withdrawals})
+		}
+	}
+	return result, nil
+}
+
+func loadWithdrawals(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(w *StudyWithdrawals) (bool, error) {
+		if !f.includesStudy(w.StudyId) {
+			return false, nil
+		}
+		if dryRun || len(w.Withdrawals) == 0 {
+			return true, nil
+		}
+		// replace any existing tombstones, so that loading is idempotent
+		if err := platform.DeleteStorage(sCtx(), WithdrawalList(w.StudyId)); err != nil {
+			return false, err
+		}
+		return true, pushWithdrawals(w.StudyId, w.Withdrawals...)
+	})
+}
+
+func dumpArmBlocks(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studyIds, err := filteredStudyIds(f)
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, studyId := range studyIds {
+		blocks, err := platform.MapGetAll(sCtx(), ArmBlocks(studyId))
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) > 0 {
+			result = append(result, &StudyArmBlocks{StudyId: studyId, Blocks: blocks})
+		}
+	}
+	return result, nil
+}
+
+func loadArmBlocks(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(b *StudyArmBlocks) (bool, error) {
+		if !f.includesStudy(b.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		// replace any existing blocks, so that loading is idempotent
+		if err := platform.DeleteStorage(sCtx(), ArmBlocks(b.StudyId)); err != nil {
+			return false, err
+		}
+		for stratum, block := range b.Blocks {
+			if err := platform.MapSet(sCtx(), ArmBlocks(b.StudyId), stratum, block); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+func dumpStudyMessages(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studyIds, err := filteredStudyIds(f)
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, studyId := range studyIds {
+		messages, err := GetAllStudyMessages(studyId)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range messages {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+func loadStudyMessages(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(m *StudyMessage) (bool, error) {
+		if !f.includesStudy(m.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, m.save()
+	})
+}
+
+func dumpReports(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	studyIds, err := filteredStudyIds(f)
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, studyId := range studyIds {
+		reports, err := FetchAllStudyReports(studyId)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range reports {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func loadReports(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(r *StudyReport) (bool, error) {
+		if !f.includesStudy(r.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, r.save()
+	})
+}
+
+func dumpAdmins(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	users, err := GetAllAdminUsers()
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, u := range users {
+		if len(f.StudyIds) > 0 && (u.HasRole(AdminRoleSuperAdmin) || !f.includesStudy(u.StudyId)) {
+			continue
+		}
+		result = append(result, u)
+	}
+	return result, nil
+}
+
+func loadAdmins(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(u *AdminUser) (bool, error) {
+		if len(f.StudyIds) > 0 && (u.HasRole(AdminRoleSuperAdmin) || !f.includesStudy(u.StudyId)) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, SaveAdminUser(u)
+	})
+}
+
+// profileObject is any stored object that is keyed by a profile.
+type profileObject[T any] interface {
+	*T
+	platform.Object
+	profileId() string
+}
+
+func (s *SpeechSettings) profileId() string    { return s.ProfileId }
+func (f *FavoritesSettings) profileId() string { return f.ProfileId }
+func (l *LifecycleData) profileId() string     { return l.ProfileId }
+
+func dumpProfileObjects[T any, PT profileObject[T]](_ *TransferFilter, profiles map[string]bool) ([]any, error) {
+	var result []any
+	obj := PT(new(T))
+	collect := func() error {
+		if profiles == nil || profiles[obj.profileId()] {
+			v := *obj
+			result = append(result, PT(&v))
+		}
+		return nil
+	}
+	if err := platform.MapObjects(sCtx(), collect, obj); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func loadProfileObjects[T any, PT profileObject[T]](f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	profiles, err := filterProfiles(f)
+	if err != nil {
+		return 0, err
+	}
+	return loadEach(ms, func(v *T) (bool, error) {
+		obj := PT(v)
+		if profiles != nil && !profiles[obj.profileId()] {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, platform.SaveObject(sCtx(), obj)
+	})
+}
+
+func dumpFavoritesHistories(_ *TransferFilter, profiles map[string]bool) ([]any, error) {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, FavoritesHistory("")); err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, id := range ids {
+		if profiles != nil && !profiles[id] {
+			continue
+		}
+		versions, err := GetFavoritesHistory(id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &ProfileFavoritesHistory{ProfileId: id, Versions: versions})
+	}
+	return result, nil
+}
+
+func loadFavoritesHistories(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	profiles, err := filterProfiles(f)
+	if err != nil {
+		return 0, err
+	}
+	return loadEach(ms, func(h *ProfileFavoritesHistory) (bool, error) {
+		if profiles != nil && !profiles[h.ProfileId] {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		// replace any existing history, so that loading is idempotent
+		key := FavoritesHistory(h.ProfileId)
+		if err := platform.DeleteStorage(sCtx(), key); err != nil {
+			return false, err
+		}
+		vals := make([]string, 0, len(h.Versions))
+		for _, v := range h.Versions {
+			b, err := v.ToRedis()
+			if err != nil {
+				return false, err
+			}
+			vals = append(vals, string(b))
+		}
+		if len(vals) == 0 {
+			return true, nil
+		}
+		// pushing on the right keeps the newest version first
+		return true, platform.PushRange(sCtx(), key, false, vals...)
+	})
+}
+
+func dumpSessionEvents(_ *TransferFilter, profiles map[string]bool) ([]any, error) {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, SessionHistory("")); err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, id := range ids {
+		if profiles != nil && !profiles[id] {
+			continue
+		}
+		events, err := FetchSessionEvents(id, 0, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &ProfileSessionEvents{ProfileId: id, Events: events})
+	}
+	return result, nil
+}
+
+func loadSessionEvents(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	profiles, err := filterProfiles(f)
+	if err != nil {
+		return 0, err
+	}
+	return loadEach(ms, func(e *ProfileSessionEvents) (bool, error) {
+		if profiles != nil && !profiles[e.ProfileId] {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		// replace any existing events, so that loading is idempotent
+		h := SessionHistory(e.ProfileId)
+		if err := platform.DeleteStorage(sCtx(), h); err != nil {
+			return false, err
+		}
+		if len(e.Events) == 0 {
+			return true, nil
+		}
+		return true, h.PushEvents(e.Events...)
+	})
+}
+
+func dumpDevices(_ *TransferFilter, profiles map[string]bool) ([]any, error) {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, DeviceIndex("")); err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, id := range ids {
+		if profiles != nil && !profiles[id] {
+			continue
+		}
+		devices, err := GetProfileDevices(id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &ProfileDevices{ProfileId: id, Devices: devices})
+	}
+	return result, nil
+}
+
+func loadDevices(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	profiles, err := filterProfiles(f)
+	if err != nil {
+		return 0, err
+	}
+	return loadEach(ms, func(p *ProfileDevices) (bool, error) {
+		if profiles != nil && !profiles[p.ProfileId] {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		for _, d := range p.Devices {
+			if err := saveDevice(d); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+func dumpProfileMessages(_ *TransferFilter, profiles map[string]bool) ([]any, error) {
+	var ids []string
+	collect := func(id string) error {
+		ids = append(ids, id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, ProfileMessageIndex("")); err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, id := range ids {
+		if profiles != nil && !profiles[id] {
+			continue
+		}
+		messages, err := GetAllProfileMessages(id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &ProfileMessages{ProfileId: id, Messages: messages})
+	}
+	return result, nil
+}
+
+func loadProfileMessages(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	profiles, err := filterProfiles(f)
+	if err != nil {
+		return 0, err
+	}
+	return loadEach(ms, func(p *ProfileMessages) (bool, error) {
+		if profiles != nil && !profiles[p.ProfileId] {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		// replace any existing messages, so that loading is idempotent
+		if err := platform.DeleteStorage(sCtx(), ProfileMessageIndex(p.ProfileId)); err != nil {
+			return false, err
+		}
+		if err := platform.DeleteStorage(sCtx(), UndeliveredMessageSet(p.ProfileId)); err != nil {
+			return false, err
+		}
+		for _, pm := range p.Messages {
+			if err := pm.save(p.ProfileId); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+func dumpMonitors(_ *TransferFilter, profiles map[string]bool) ([]any, error) {
+	var result []any
+	m := new(SpeechMonitor)
+	collect := func() error {
+		if profiles != nil && !profiles[m.ProfileId] {
+			return nil
+		}
+		score, err := platform.GetMemberScore(sCtx(), speechMonitors, m.ProfileId)
+		if err != nil {
+			// a monitor that isn't scheduled
+			score = -1
+		}
+		result = append(result, &MonitorRecord{Monitor: *m, Score: score})
+		return nil
+	}
+	if err := platform.MapObjects(sCtx(), collect, m); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func loadMonitors(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	profiles, err := filterProfiles(f)
+	if err != nil {
+		return 0, err
+	}
+	return loadEach(ms, func(r *MonitorRecord) (bool, error) {
+		if profiles != nil && !profiles[r.Monitor.ProfileId] {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		if err := platform.SaveObject(sCtx(), &r.Monitor); err != nil {
+			return false, err
+		}
+		if r.Score < 0 {
+			return true, nil
+		}
+		return true, platform.AddScoredMember(sCtx(), speechMonitors, r.Score, r.Monitor.ProfileId)
+	})
+}
+
+// version policies aren't tied to a study, so they only transfer when all studies do
+func dumpVersionPolicies(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	if len(f.StudyIds) > 0 {
+		return nil, nil
+	}
+	policies, err := GetAllClientVersionPolicies()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]any, 0, len(policies))
+	for _, p := range policies {
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+func loadVersionPolicies(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(p *ClientVersionPolicy) (bool, error) {
+		if len(f.StudyIds) > 0 {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, saveClientVersionPolicy(p)
+	})
+}
+
+// feature flags aren't tied to a study, so they only transfer when all studies do
+func dumpFeatureFlags(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	if len(f.StudyIds) > 0 {
+		return nil, nil
+	}
+	flags, err := GetAllFeatureFlags()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]any, 0, len(flags))
+	for _, flag := range flags {
+		result = append(result, flag)
+	}
+	return result, nil
+}
+
+func loadFeatureFlags(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(flag *FeatureFlag) (bool, error) {
+		if len(f.StudyIds) > 0 {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, saveFeatureFlag(flag)
+	})
+}
+
+func dumpProblemReports(f *TransferFilter, _ map[string]bool) ([]any, error) {
+	reports, err := GetAllProblemReports()
+	if err != nil {
+		return nil, err
+	}
+	var result []any
+	for _, p := range reports {
+		if len(f.StudyIds) == 0 || f.includesStudy(p.StudyId) {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func loadProblemReports(f *TransferFilter, ms []json.RawMessage, dryRun bool) (int, error) {
+	return loadEach(ms, func(p *ProblemReport) (bool, error) {
+		if len(f.StudyIds) > 0 && !f.includesStudy(p.StudyId) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		return true, p.save()
+	})
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/gob"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+// A ClientVersionPolicy sets the minimum supported app version for a client type.
+// Clients below the MinVersion are rejected, and clients below the WarnVersion
+// are asked to upgrade. Either version may be empty to turn that check off.
+type ClientVersionPolicy struct {
+	ClientType  string
+	MinVersion  string
+	WarnVersion string
+	Author      string // email of the admin who last changed it
+	Updated     int64  // Unix time in milliseconds
+}
+
+func (p *ClientVersionPolicy) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(p); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (p *ClientVersionPolicy) FromRedis(b []byte) error {
+	*p = ClientVersionPolicy{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(p)
+}
+
+// the global map from client type to its version policy
+var versionPolicies = platform.StorableMap("client-version-policies")
+
+type ClientVersionStatus = string
+
+const (
+	ClientVersionSupported ClientVersionStatus = "supported"
+	ClientVersionOutdated  ClientVersionStatus = "outdated"
+	ClientVersionRetired   ClientVersionStatus = "retired"
+	ClientVersionUnpoliced ClientVersionStatus = "" // no policy for the client type
+)
+
+// clientVersionActiveDays is how recently a device must have been seen to count in the distribution.
+const clientVersionActiveDays = 30
+
+var InvalidClientVersionError = errors.New("not a valid version number")
+
+// policy versions must be plain dotted numbers, even though clients may report more
+var policyVersionPattern = regexp.MustCompile(`^\d+(\.\d+)*$`)
+
+// Status returns whether a client running the given version is supported
+// by the policy. Clients that don't report a version count as too old.
+func (p *ClientVersionPolicy) Status(version string) ClientVersionStatus {
+	if p == nil {
+		return ClientVersionUnpoliced
+	}
+	if p.MinVersion != "" && platform.CompareVersions(version, p.MinVersion) < 0 {
+		return ClientVersionRetired
+	}
+	if p.WarnVersion != "" && platform.CompareVersions(version, p.WarnVersion) < 0 {
+		return ClientVersionOutdated
+	}
+	return ClientVersionSupported
+}
+
+// GetClientVersionPolicy returns the client type's version policy, or nil if it doesn't have one.
+func GetClientVersionPolicy(clientType string) (*ClientVersionPolicy, error) {
+	val, err := platform.MapGet(sCtx(), versionPolicies, clientType)
+	if err != nil {
+		sLog().Error("db failure on version policy fetch",
+			zap.String("clientType", clientType), zap.Error(err))
+		return nil, err
+	}
+	if val == "" {
+		return nil, nil
+	}
+	p := new(ClientVersionPolicy)
+	if err := p.FromRedis([]byte(val)); err != nil {
+		sLog().Error("deserialization failure on version policy",
+			zap.String("clientType", clientType), zap.Error(err))
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetAllClientVersionPolicies returns all the version policies, sorted by client type.
+func GetAllClientVersionPolicies() ([]*ClientVersionPolicy, error) {
+	m, err := platform.MapGetAll(sCtx(), versionPolicies)
+	if err != nil {
+		sLog().Error("db failure on version policies fetch", zap.Error(err))
+		return nil, err
+	}
+	result := make([]*ClientVersionPolicy, 0, len(m))
+	for clientType, val := range m {
+		p := new(ClientVersionPolicy)
+		if err := p.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on version policy",
+				zap.String("clientType", clientType), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	slices.SortFunc(result, func(a, b *ClientVersionPolicy) int {
+		return strings.Compare(a.ClientType, b.ClientType)
+	})
+	return result, nil
+}
+
+// SaveClientVersionPolicy sets the version policy for a client type,
+// after checking that its versions are valid.
+func SaveClientVersionPolicy(clientType, minVersion, warnVersion, author string) (*ClientVersionPolicy, error) {
+	for _, v := range []string{minVersion, warnVersion} {
+		if v != "" && !policyVersionPattern.MatchString(v) {
+			return nil, InvalidClientVersionError
+		}
+	}
+	p := &ClientVersionPolicy{
+		ClientType:  clientType,
+		MinVersion:  minVersion,
+		WarnVersion: warnVersion,
+		Author:      author,
+		Updated:     time.Now().UnixMilli(),
+	}
+	if err := saveClientVersionPolicy(p); err != nil {
+		return nil, err
+	}
+	sLog().Info("client version policy saved", zap.String("clientType", clientType),
+		zap.String("minVersion", minVersion), zap.String("warnVersion", warnVersion))
+	return p, nil
+}
+
+func saveClientVersionPolicy(p *ClientVersionPolicy) error {
+	b, err := p.ToRedis()
+	if err != nil {
+		sLog().Error("serialization failure on version policy",
+			zap.String("clientType", p.ClientType), zap.Error(err))
+		return err
+	}
+	if err := platform.MapSet(sCtx(), versionPolicies, p.ClientType, string(b)); err != nil {
+		sLog().Error("db failure on version policy save",
+			zap.String("clientType", p.ClientType), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func DeleteClientVersionPolicy(clientType string) error {
+	if err := platform.MapRemove(sCtx(), versionPolicies, clientType); err != nil {
+		sLog().Error("db failure on version policy delete",
+			zap.String("clientType", clientType), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// A ClientVersionCount is the number of active devices running a version of a client type.
+type ClientVersionCount struct {
+	ClientType string
+	AppVersion string
+	Devices    int
+	Status     ClientVersionStatus
+}
+
+// GetClientVersionDistribution counts the devices seen in the last 30 days,
+// grouped by client type and app version, newest versions first.
+func GetClientVersionDistribution() ([]*ClientVersionCount, error) {
+	var profileIds []string
+	collect := func(id string) error {
+		profileIds = append(profileIds, id)
+		return nil
+	}
+	if err := platform.MapKeys(sCtx(), collect, DeviceIndex("")); err != nil {
+		sLog().Error("db failure on device index scan", zap.Error(err))
+		return nil, err
+	}
+	policies, err := GetAllClientVersionPolicies()
+	if err != nil {
+		return nil, err
+	}
+	byType := make(map[string]*ClientVersionPolicy, len(policies))
+	for _, p := range policies {
+		byType[p.ClientType] = p
+	}
+	cutoff := time.Now().AddDate(0, 0, -clientVersionActiveDays).UnixMilli()
+	counts := make(map[[2]string]*ClientVersionCount)
+	for _, profileId := range profileIds {
+		devices, err := GetProfileDevices(profileId)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devices {
+			if d.Revoked > 0 || d.LastSeen < cutoff {
+				continue
+			}
+			key := [2]string{d.ClientType, d.AppVersion}
+			if counts[key] == nil {
+				counts[key] = &ClientVersionCount{
+					ClientType: d.ClientType,
+					AppVersion: d.AppVersion,
+					Status:     byType[d.ClientType].Status(d.AppVersion),
+				}
+			}
+			counts[key].Devices++
+		}
+	}
+	result := make([]*ClientVersionCount, 0, len(counts))
+	for _, c := range counts {
+		result = append(result, c)
+	}
+	slices.SortFunc(result, func(a, b *ClientVersionCount) int {
+		return cmp.Or(
+			strings.Compare(a.ClientType, b.ClientType),
+			platform.CompareVersions(b.AppVersion, a.AppVersion),
+			strings.Compare(a.AppVersion, b.AppVersion),
+		)
+	})
+	return result, nil
+}
@@ -0,0 +1,286 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+	"go.uber.org/zap"
+)
+
+// WithdrawnByParticipant is the initiator of a withdrawal made in the app.
+// Withdrawals made in the console are initiated by the admin's email.
+const WithdrawnByParticipant = "participant"
+
+// A WithdrawalTombstone is the audit record of a participant's withdrawal from a study.
+// It outlives the participant's data, even if the participant is later deleted.
+type WithdrawalTombstone struct {
+	StudyId   string
+	Upn       string
+	ProfileId string
+	By        string // WithdrawnByParticipant or an admin email
+	Reason    string
+	Purged    bool  // whether the participant's data was deleted
+	Started   int64 // Unix time in milliseconds
+	Withdrawn int64 // Unix time in milliseconds
+}
+
+func (w *WithdrawalTombstone) ToRedis() ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(w); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (w *WithdrawalTombstone) FromRedis(b []byte) error {
+	*w = WithdrawalTombstone{} // dump old data
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(w)
+}
+
+// The WithdrawalList of a study ID is all the study's withdrawal tombstones, oldest first.
+type WithdrawalList string
+
+func (l WithdrawalList) StoragePrefix() string {
+	return "withdrawals:"
+}
+func (l WithdrawalList) StorageId() string {
+	return string(l)
+}
+
+var ParticipantNotWithdrawableError = errors.New("participant never started or has already withdrawn")
+
+// WithdrawStudyParticipant removes the participant from the study for good, and
+// records a tombstone for the withdrawal. If purge is true, the participant's
+// line stats, phrase stats, and questionnaire responses are deleted, and their
+// phrase uses are subtracted from the study's phrase stats. Consent records are
+// always kept, as evidence of what the participant agreed to.
+//
+// The participant is marked as withdrawn before their data is purged and their
+// tombstone is recorded, so if either of those fails the withdrawal can be retried:
+// a withdrawn participant without a tombstone is treated as still being withdrawn.
+func WithdrawStudyParticipant(studyId, upn, by, reason string, purge bool) (*WithdrawalTombstone, error) {
+	// the tombstones can't be read during the update, so look for a completed
+	// withdrawal beforehand
+	var priorWithdrawn int64
+	var priorDone bool
+	if prior, err := GetStudyParticipant(studyId, upn); err != nil {
+		return nil, err
+	} else if prior != nil && prior.Withdrawn > 0 {
+		priorWithdrawn = prior.Withdrawn
+		if priorDone, err = HasWithdrawalTombstone(prior); err != nil {
+			return nil, err
+		}
+	}
+	p, err := updateParticipant(studyId, upn, func(p *StudyParticipant, found bool) (bool, error) {
+		if !found || p.Started == 0 {
+			return false, ParticipantNotWithdrawableError
+		}
+		if p.Withdrawn > 0 {
+			// a withdrawal that started after the lookup is still in progress
+			if priorDone || p.Withdrawn != priorWithdrawn {
+				return false, ParticipantNotWithdrawableError
+			}
+			// retrying an incomplete withdrawal
+			return false, nil
+		}
+		now := time.Now().UnixMilli()
+		if p.Finished == 0 {
+			p.Finished = now
+		}
+		p.Withdrawn = now
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the profile may have joined another study since it finished this one
+	if memberStudyId, memberUpn, err := GetProfileStudyMembership(p.ProfileId); err != nil {
+		return nil, err
+	} else if memberStudyId == studyId && memberUpn == p.Upn {
+		if err := platform.MapRemove(sCtx(), profileParticipantMap, p.ProfileId); err != nil {
+			sLog().Error("map remove failure on participant withdrawal",
+				zap.String("profileId", p.ProfileId), zap.String("studyId", studyId), zap.String("upn", upn),
+				zap.Error(err))
+			return nil, err
+		}
+		if err := clearProfileConsentRequired(p.ProfileId); err != nil {
+			return nil, err
+		}
+		if err := profileClientConfigDidChange(p.ProfileId); err != nil {
+			return nil, err
+		}
+	}
+	if err := platform.RemoveMembers(sCtx(), InactiveParticipants(studyId), p.Upn); err != nil {
+		sLog().Error("db failure on inactive participant remove",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return nil, err
+	}
+	if purge {
+		if err := purgeParticipantData(studyId, p.Upn); err != nil {
+			return nil, err
+		}
+	}
+	w := &WithdrawalTombstone{
+		StudyId:   studyId,
+		Upn:       p.Upn,
+		ProfileId: p.ProfileId,
+		By:        by,
+		Reason:    reason,
+		Purged:    purge,
+		Started:   p.Started,
+		Withdrawn: p.Withdrawn,
+	}
+	if err := pushWithdrawals(studyId, w); err != nil {
+		return nil, err
+	}
+	sLog().Info("participant withdrawn", zap.String("studyId", studyId), zap.String("upn", p.Upn),
+		zap.String("by", by), zap.Bool("purged", purge))
+	return w, nil
+}
+
+// HasWithdrawalTombstone returns whether the withdrawn participant's tombstone has been
+// recorded. If it hasn't, their withdrawal didn't complete and should be retried.
+func HasWithdrawalTombstone(p *StudyParticipant) (bool, error) {
+	withdrawals, err := FetchWithdrawals(p.StudyId)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(withdrawals, func(w *WithdrawalTombstone) bool {
+		return w.Upn == p.Upn && w.Withdrawn == p.Withdrawn
+	}), nil
+}
+
+// purgeParticipantData deletes the data collected from the participant, after
+// first subtracting their phrase uses from the study's phrase stats. Each phrase's
+// uses are removed from the participant's stats as soon as they have been subtracted,
+// so a purge that fails part way can be retried.
+func purgeParticipantData(studyId, upn string) error {
+	buckets, err := FetchParticipantPhraseStats(studyId, upn)
+	if err != nil {
+		sLog().Error("db failure on participant phrase stats fetch",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return err
+	}
+	totals := make(map[string]*PhraseStat)
+	fields := make(map[string][]string)
+	for field, s := range buckets {
+		_, hash, _ := strings.Cut(field, "+")
+		t := totals[hash]
+		if t == nil {
+			t = &PhraseStat{Hash: hash}
+			totals[hash] = t
+		}
+		t.FavoriteCount += s.FavoriteCount
+		t.RepeatCount += s.RepeatCount
+		t.LibraryCount += s.LibraryCount
+		fields[hash] = append(fields[hash], field)
+	}
+	for hash, t := range totals {
+		if err := subtractPhraseStat(studyId, hash, t); err != nil {
+			return err
+		}
+		for _, field := range fields[hash] {
+			if err := platform.MapRemove(sCtx(), ParticipantPhraseStatsIndex(studyId+"+"+upn), field); err != nil {
+				sLog().Error("db failure on participant phrase stats remove",
+					zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+				return err
+			}
+		}
+	}
+	if err = platform.DeleteStorage(sCtx(), ParticipantPhraseStatsIndex(studyId+"+"+upn)); err != nil {
+		sLog().Error("db failure on participant phrase stats delete",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return err
+	}
+	if err = platform.DeleteStorage(sCtx(), StudyTypedLineStatsIndex(studyId+"+"+upn)); err != nil {
+		sLog().Error("db failure on typed line stats delete",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return err
+	}
+	if err = platform.DeleteStorage(sCtx(), QuestionnaireResponseList(studyId+"+"+upn)); err != nil {
+		sLog().Error("db failure on questionnaire responses delete",
+			zap.String("studyId", studyId), zap.String("upn", upn), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// subtractPhraseStat removes the given counts from the study's stat for the phrase,
+// and removes the stat entirely if there are no uses left.
+func subtractPhraseStat(studyId, hash string, t *PhraseStat) error {
+	var s PhraseStat
+	unused := false
+	update := func(found bool) (bool, error) {
+		if !found {
+			return false, nil
+		}
+		s.FavoriteCount = max(0, s.FavoriteCount-t.FavoriteCount)
+		s.RepeatCount = max(0, s.RepeatCount-t.RepeatCount)
+		s.LibraryCount = max(0, s.LibraryCount-t.LibraryCount)
+		unused = s.FavoriteCount == 0 && s.RepeatCount == 0
+		return !unused, nil
+	}
+	if _, err := platform.MapUpdateValue(sCtx(), PhraseStatsIndex(studyId), hash, &s, update); err != nil {
+		sLog().Error("db failure on phrase stat subtract",
+			zap.String("studyId", studyId), zap.String("hash", hash), zap.Error(err))
+		return err
+	}
+	if unused {
+		if err := platform.MapRemove(sCtx(), PhraseStatsIndex(studyId), hash); err != nil {
+			sLog().Error("db failure on phrase stat remove",
+				zap.String("studyId", studyId), zap.String("hash", hash), zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+func pushWithdrawals(studyId string, withdrawals ...*WithdrawalTombstone) error {
+	vals := make([]string, 0, len(withdrawals))
+	for _, w := range withdrawals {
+		b, err := w.ToRedis()
+		if err != nil {
+			sLog().Error("serialization failure on withdrawal tombstone",
+				zap.String("studyId", studyId), zap.String("upn", w.Upn), zap.Error(err))
+			return err
+		}
+		vals = append(vals, string(b))
+	}
+	if err := platform.PushRange(sCtx(), WithdrawalList(studyId), false, vals...); err != nil {
+		sLog().Error("db failure on withdrawal tombstone push",
+			zap.String("studyId", studyId), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// FetchWithdrawals returns all the study's withdrawal tombstones, oldest first.
+func FetchWithdrawals(studyId string) ([]*WithdrawalTombstone, error) {
+	vals, err := platform.FetchRange(sCtx(), WithdrawalList(studyId), 0, -1)
+	if err != nil {
+		sLog().Error("db failure on withdrawal tombstones fetch",
+			zap.String("studyId", studyId), zap.Error(err))
+		return nil, err
+	}
+	result := make([]*WithdrawalTombstone, 0, len(vals))
+	for _, val := range vals {
+		w := new(WithdrawalTombstone)
+		if err := w.FromRedis([]byte(val)); err != nil {
+			sLog().Error("deserialization failure on withdrawal tombstone",
+				zap.String("studyId", studyId), zap.Error(err))
+			return nil, err
+		}
+		result = append(result, w)
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2025 Daniel C. Brotsky. All rights reserved.
+ * All the copyrighted work in this repository is licensed under the
+ * GNU Affero General Public License v3, reproduced in the LICENSE file.
+ */
+
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/whisper-project/in-my-voice.server.golang/platform"
+)
+
+func TestRetryIncompleteWithdrawal(t *testing.T) {
+	studyId, upn, profileId := uuid.NewString(), "upn-"+uuid.NewString(), uuid.NewString()
+	defer func() {
+		_ = platform.DeleteStorage(sCtx(), ParticipantIndex(studyId))
+		_ = platform.DeleteStorage(sCtx(), WithdrawalList(studyId))
+		_ = platform.MapRemove(sCtx(), profileParticipantMap, profileId)
+	}()
+	if _, err := CreateStudyParticipant(studyId, upn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnrollStudyParticipant(profileId, uuid.NewString(), studyId, upn, 0); err != nil {
+		t.Fatal(err)
+	}
+	// simulate a withdrawal that failed after marking the participant
+	p, err := GetStudyParticipant(studyId, upn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withdrawn := time.Now().UnixMilli()
+	if err := p.update(func(p *StudyParticipant) { p.Finished, p.Withdrawn = withdrawn, withdrawn }); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := HasWithdrawalTombstone(p); err != nil || done {
+		t.Fatalf("Incomplete withdrawal has a tombstone (%v)", err)
+	}
+	w, err := WithdrawStudyParticipant(studyId, upn, WithdrawnByParticipant, "retry", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Withdrawn != withdrawn {
+		t.Errorf("Retried withdrawal has time %d, expected the original %d", w.Withdrawn, withdrawn)
+	}
+	if studyId, _, err := GetProfileStudyMembership(profileId); err != nil || studyId != "" {
+		t.Errorf("Profile is still a member of study %q after withdrawal (%v)", studyId, err)
+	}
+	if done, err := HasWithdrawalTombstone(p); err != nil || !done {
+		t.Errorf("Completed withdrawal has no tombstone (%v)", err)
+	}
+	if _, err := WithdrawStudyParticipant(studyId, upn, WithdrawnByParticipant, "again", false); !errors.Is(err, ParticipantNotWithdrawableError) {
+		t.Errorf("Second withdrawal returned %v, expected ParticipantNotWithdrawableError", err)
+	}
+	withdrawals, err := FetchWithdrawals(studyId)
+	if err != nil || len(withdrawals) != 1 {
+		t.Errorf("Study has %d withdrawals (%v), expected 1", len(withdrawals), err)
+	}
+}